@@ -0,0 +1,151 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+// ErrAccountDeleted is returned when a deleted account tries to log in.
+var ErrAccountDeleted = errors.New("account is scheduled for deletion, restore it to log in again")
+
+// ErrRestoreWindowOver is returned when a deleted account is past its grace period.
+var ErrRestoreWindowOver = errors.New("the account can no longer be restored")
+
+// DeletionGracePeriod returns how long a deleted account can be restored before it is purged.
+//
+// Returns:
+// - time.Duration: The configured grace period, 30 days if none is set.
+func (app *Application) DeletionGracePeriod() time.Duration {
+	if app.Config == nil || app.Config.Security.AccountDeletion.GracePeriod <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return app.Config.Security.AccountDeletion.GracePeriod
+}
+
+// DeleteAccount marks an account as deleted. The user can no longer log in, every
+// session is revoked and the account is purged once the grace period is over.
+//
+// Parameters:
+// - userID: The ID of the user.
+//
+// Returns:
+// - time.Time: When the account will be purged.
+// - error: An error if the user does not exist or can not be updated.
+func (app *Application) DeleteAccount(userID string) (time.Time, error) {
+	user, err := app.Repository.GetUserByID(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if user.IsDeleted() {
+		return user.DeletedAt.Add(app.DeletionGracePeriod()), nil
+	}
+
+	now := time.Now()
+	if err := app.Repository.SoftDeleteUserByID(userID, now); err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(app.DeletionGracePeriod()), nil
+}
+
+// RestoreAccount undoes the deletion of an account within the grace period. Sessions
+// revoked by the deletion stay revoked, the user logs in again.
+//
+// Parameters:
+// - userID: The ID of the user.
+//
+// Returns:
+// - error: repositories.ErrUserNotDeleted if the account is not deleted, ErrRestoreWindowOver
+// if the grace period is over, or an error if the user can not be updated.
+func (app *Application) RestoreAccount(userID string) error {
+	user, err := app.Repository.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.IsDeleted() && time.Since(user.DeletedAt) > app.DeletionGracePeriod() {
+		return ErrRestoreWindowOver
+	}
+	return app.Repository.RestoreUserByID(userID)
+}
+
+// PurgeDeletedAccounts removes every account whose grace period is over, with all of
+// its records, profile image files and data export archives.
+//
+// Returns:
+// - int: The number of purged accounts.
+// - error: An error if the accounts can not be loaded.
+func (app *Application) PurgeDeletedAccounts() (int, error) {
+	users, err := app.Repository.GetUsersDeletedBefore(time.Now().Add(-app.DeletionGracePeriod()))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		images, err := app.Repository.GetProfileImagesByUserID(user.ID)
+		if err != nil {
+			log.Printf("error loading the profile images of %s: %v", user.ID, err)
+			continue
+		}
+		if err := app.removeDataExportFiles(user.ID); err != nil {
+			log.Printf("error removing the data exports of %s: %v", user.ID, err)
+			continue
+		}
+
+		if err := app.Repository.DeleteUserByID(user.ID); err != nil {
+			log.Printf("error purging user %s: %v", user.ID, err)
+			continue
+		}
+
+		for _, image := range images {
+			if err := os.Remove(image.FilePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("error removing the profile image %s: %v", image.FilePath, err)
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// accountPurgeJobTTL is how long a replica holds the account purge job.
+const accountPurgeJobTTL = 15 * time.Minute
+
+// RunAccountPurge calls PurgeDeletedAccounts and PurgeExpiredDataExports on the
+// configured interval until the context is cancelled. Each run is guarded by a claim
+// in the shared store, so replicas do not purge the same accounts at the same time.
+//
+// Parameters:
+// - ctx: Stops the loop when cancelled.
+func (app *Application) RunAccountPurge(ctx context.Context) {
+	interval := time.Hour
+	if app.Config != nil && app.Config.Security.AccountDeletion.PurgeInterval > 0 {
+		interval = app.Config.Security.AccountDeletion.PurgeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.runExclusive("account-purge", accountPurgeJobTTL, func() {
+			if purged, err := app.PurgeDeletedAccounts(); err != nil {
+				log.Printf("error purging deleted accounts: %v", err)
+			} else if purged > 0 {
+				log.Printf("purged %d deleted accounts", purged)
+			}
+			if purged, err := app.PurgeExpiredDataExports(); err != nil {
+				log.Printf("error purging expired data exports: %v", err)
+			} else if purged > 0 {
+				log.Printf("purged %d expired data exports", purged)
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package application_test
+
+import (
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/testenv"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// Test that deleted accounts are purged with their records and files once the grace period is over
+func TestPurgeDeletedAccounts(t *testing.T) {
+	env := testenv.New(t)
+
+	hammondID := env.Register(t, "hammond", "spared-no-expense")
+	nedryID := env.Register(t, "nedry", "ah-ah-ah-magic-word")
+	env.Mail.WaitFor(t, 2)
+
+	nedry, err := env.App.Repository.GetUserByID(nedryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportID, err := env.App.RequestDataExport(nedry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Mail.WaitFor(t, 3)
+	export, err := env.App.Repository.GetDataExportByID(exportID)
+	if err != nil || export.FilePath == "" {
+		t.Fatalf("expected a built export, got %+v, error: %v", export, err)
+	}
+
+	if _, err := env.App.DeleteAccount(nedryID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is purged during the grace period
+	if purged, err := env.App.PurgeDeletedAccounts(); err != nil || purged != 0 {
+		t.Fatalf("expected no purge during the grace period, got %d, error: %v", purged, err)
+	}
+	images, err := env.App.Repository.GetProfileImagesByUserID(nedryID)
+	if err != nil || len(images) != 1 {
+		t.Fatalf("expected one profile image, got %d, error: %v", len(images), err)
+	}
+	if _, err := os.Stat(images[0].FilePath); err != nil {
+		t.Fatalf("expected the profile image file, got %v", err)
+	}
+
+	env.App.Config.Security.AccountDeletion.GracePeriod = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	if purged, err := env.App.PurgeDeletedAccounts(); err != nil || purged != 1 {
+		t.Fatalf("expected one purged account, got %d, error: %v", purged, err)
+	}
+	if _, err := env.App.Repository.GetUserByID(nedryID); err == nil {
+		t.Error("expected the user to be purged")
+	}
+	if _, err := env.App.Repository.GetUserByID(hammondID); err != nil {
+		t.Errorf("expected the other user to be kept, got %v", err)
+	}
+	if _, err := os.Stat(images[0].FilePath); !os.IsNotExist(err) {
+		t.Errorf("expected the profile image file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(export.FilePath); !os.IsNotExist(err) {
+		t.Errorf("expected the data export archive to be removed, got %v", err)
+	}
+	for name, model := range map[string]interface{}{
+		"modes":         &models.Mode{},
+		"confirmations": &models.UserConfirmation{},
+		"images":        &models.ProfileImage{},
+		"exports":       &models.DataExport{},
+	} {
+		var count int64
+		env.App.Repository.DB.Unscoped().Model(model).Where("user_id = ?", nedryID).Count(&count)
+		if count != 0 {
+			t.Errorf("expected the %s to be purged, got %d", name, count)
+		}
+	}
+}
+
+// Test that the purge is skipped while another replica runs it
+func TestRunAccountPurge(t *testing.T) {
+	env := testenv.New(t)
+
+	nedryID := env.Register(t, "nedry", "ah-ah-ah-magic-word")
+	env.Mail.WaitFor(t, 1)
+	if _, err := env.App.DeleteAccount(nedryID); err != nil {
+		t.Fatal(err)
+	}
+	env.App.Config.Security.AccountDeletion.GracePeriod = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+
+	// A cancelled context runs the loop once
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if ok, err := env.App.Store.Claim(ctx, "job:account-purge", "other-replica", time.Minute); err != nil || !ok {
+		t.Fatalf("expected to claim the job, got %v, %v", ok, err)
+	}
+	env.App.RunAccountPurge(ctx)
+	if _, err := env.App.Repository.GetUserByID(nedryID); err != nil {
+		t.Fatalf("expected no purge while another replica runs it, got %v", err)
+	}
+
+	if _, err := env.App.Store.TakeCode(ctx, "job:account-purge"); err != nil {
+		t.Fatal(err)
+	}
+	env.App.RunAccountPurge(ctx)
+	if _, err := env.App.Repository.GetUserByID(nedryID); err == nil {
+		t.Error("expected the user to be purged")
+	}
+
+	// The claim is released after the run
+	if ok, err := env.App.Store.Claim(ctx, "job:account-purge", "other-replica", time.Minute); err != nil || !ok {
+		t.Errorf("expected the claim to be released, got %v, %v", ok, err)
+	}
+}
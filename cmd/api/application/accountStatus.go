@@ -0,0 +1,108 @@
+package application
+
+import (
+	"TriceraPass/internal/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAccountSuspended is returned when a suspended account tries to log in.
+var ErrAccountSuspended = errors.New("account is suspended")
+
+// ErrAccountLocked is returned when a locked account tries to log in.
+var ErrAccountLocked = errors.New("account is locked")
+
+// ErrApprovalPending is returned when an account waiting for approval tries to log in.
+var ErrApprovalPending = errors.New("account is waiting for approval")
+
+// ErrRegistrationRejected is returned when an account whose registration was rejected tries to log in.
+var ErrRegistrationRejected = errors.New("registration was rejected")
+
+// ErrInvalidStatus is returned when an account is suspended with an unknown status or an end in the past.
+var ErrInvalidStatus = errors.New("status must be suspended or locked and end in the future")
+
+// ErrSuspendSelf is returned when an admin tries to suspend their own account.
+var ErrSuspendSelf = errors.New("you can not suspend your own account")
+
+// AccountBlocked returns why an account can not log in or use its tokens, nil if it can.
+//
+// Parameters:
+// - user: The user to check.
+//
+// Returns:
+// - error: ErrAccountDeleted, ErrApprovalPending, ErrRegistrationRejected, ErrAccountSuspended
+// or ErrAccountLocked, the last two wrapped with the end of the block if there is one.
+func (app *Application) AccountBlocked(user *models.User) error {
+	var err error
+	switch user.CurrentStatus() {
+	case models.UserStatusPendingDeletion:
+		return ErrAccountDeleted
+	case models.UserStatusPendingApproval:
+		return ErrApprovalPending
+	case models.UserStatusRejected:
+		return ErrRegistrationRejected
+	case models.UserStatusSuspended:
+		err = ErrAccountSuspended
+	case models.UserStatusLocked:
+		err = ErrAccountLocked
+	default:
+		return nil
+	}
+
+	if user.StatusUntil != nil {
+		return fmt.Errorf("%w until %s", err, user.StatusUntil.UTC().Format(time.RFC3339))
+	}
+	return err
+}
+
+// SuspendAccount blocks an account until an admin reactivates it or until the given
+// time. Every session of the user is logged out right away. Deleted accounts can be
+// suspended too, they stay suspended when they are restored.
+//
+// Parameters:
+// - userID: The ID of the user.
+// - actorID: The ID of the admin.
+// - status: models.UserStatusSuspended or models.UserStatusLocked, suspended if empty.
+// - reason: Why the account is blocked.
+// - until: When the block ends by itself, nil if it does not.
+//
+// Returns:
+// - error: ErrInvalidStatus, ErrSuspendSelf, or an error if the user does not exist or can
+// not be updated.
+func (app *Application) SuspendAccount(userID, actorID, status, reason string, until *time.Time) error {
+	if status == "" {
+		status = models.UserStatusSuspended
+	}
+	if status != models.UserStatusSuspended && status != models.UserStatusLocked {
+		return ErrInvalidStatus
+	}
+	if until != nil && !until.After(time.Now()) {
+		return ErrInvalidStatus
+	}
+	if userID == actorID {
+		return ErrSuspendSelf
+	}
+
+	if _, err := app.Repository.GetUserByID(userID); err != nil {
+		return err
+	}
+	return app.Repository.SuspendUserByID(userID, status, reason, actorID, until)
+}
+
+// ReactivateAccount lifts the suspension or lock of an account. Sessions logged out by
+// the suspension stay logged out.
+//
+// Parameters:
+// - userID: The ID of the user.
+// - actorID: The ID of the admin.
+//
+// Returns:
+// - error: repositories.ErrUserNotSuspended if the account is neither suspended nor
+// locked, or an error if the user does not exist or can not be updated.
+func (app *Application) ReactivateAccount(userID, actorID string) error {
+	if _, err := app.Repository.GetUserByID(userID); err != nil {
+		return err
+	}
+	return app.Repository.ReactivateUserByID(userID, actorID)
+}
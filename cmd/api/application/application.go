@@ -7,20 +7,107 @@ package application
 
 import (
 	"TriceraPass/cmd/api/auth"
+	"TriceraPass/cmd/api/controllers"
 	"TriceraPass/internal/repositories"
+	"TriceraPass/internal/store"
+	"errors"
+	"log"
 )
 
 // Application holds the configuration and dependencies required by the API.
 // It includes database connection details, authentication configurations, and repository access.
 type Application struct {
-	DSN          string                 // Data Source Name for database connection.
-	Domain       string                 // Domain of the application.
-	Repository   *repositories.GORMRepo // Pointer to the GORMRepo, which handles database interactions.
-	Auth         auth.Auth              // Authentication handler for managing JWTs and user auth logic.
-	JWTSecret    string                 // Secret key used for signing JWT tokens.
-	JWTAudience  string                 // Audience claim for JWT tokens.
-	JWTIssuer    string                 // Issuer claim for JWT tokens.
-	CookieDomain string                 // Domain used for setting authentication cookies.
-	Root         string                 // Path to the root directory of the project
+	DSN          string                    // Data Source Name for database connection.
+	Domain       string                    // Domain of the application.
+	Repository   *repositories.GORMRepo    // Pointer to the GORMRepo, which handles database interactions.
+	Auth         auth.Auth                 // Authentication handler for managing JWTs and user auth logic.
+	JWTSecret    string                    // Secret key used for signing JWT tokens.
+	JWTAudience  string                    // Audience claim for JWT tokens.
+	JWTIssuer    string                    // Issuer claim for JWT tokens.
+	CookieDomain string                    // Domain used for setting authentication cookies.
+	Root         string                    // Path to the root directory of the project
+	Config       *Config                   // Parsed settings.yml configuration.
+	Breaches     controllers.BreachChecker // Breached password list, nil when screening is disabled.
+	Store        store.Store               // Rate limit buckets, failed logins and one-time codes, kept in memory if nil.
 	// APIKey     string                // (Optional) API key for external services or further authentication.
 }
+
+// ScopesForMode returns the scopes configured for a user mode in settings.yml.
+//
+// Parameters:
+// - mode: The name of the user mode.
+//
+// Returns:
+// - []string: The scopes granted to the mode, or nil if none are configured.
+func (app *Application) ScopesForMode(mode string) []string {
+	if app.Config == nil {
+		return nil
+	}
+	return app.Config.Security.Scopes[mode]
+}
+
+// PasswordPolicy returns the password policy from settings.yml with the defaults applied.
+//
+// Returns:
+// - controllers.PasswordPolicy: The policy every new password is checked against.
+func (app *Application) PasswordPolicy() controllers.PasswordPolicy {
+	if app.Config == nil {
+		return controllers.PasswordPolicy{}.WithDefaults()
+	}
+	return app.Config.Security.PasswordPolicy.WithDefaults()
+}
+
+// ValidatePassword checks a new password against the password policy and, when
+// enabled, against the breached password list.
+//
+// Parameters:
+// - password: The plain-text password to check.
+// - username: The username of the account.
+// - email: The email address of the account.
+//
+// Returns:
+// - error: A *controllers.PasswordPolicyError listing every violated rule, or nil if the password is acceptable.
+func (app *Application) ValidatePassword(password, username, email string) error {
+	err := app.PasswordPolicy().Validate(password, username, email)
+
+	var policyErr *controllers.PasswordPolicyError
+	if err != nil && !errors.As(err, &policyErr) {
+		return err
+	}
+
+	if app.PasswordBreached(password) {
+		if policyErr == nil {
+			policyErr = &controllers.PasswordPolicyError{}
+		}
+		policyErr.Violations = append(policyErr.Violations, controllers.PasswordViolation{
+			Rule:    "breached",
+			Message: "password appears in a known data breach, choose a different one",
+		})
+	}
+
+	if policyErr != nil {
+		return policyErr
+	}
+	return nil
+}
+
+// PasswordBreached reports whether a password is on the breached password list.
+// A failing lookup is logged and treated as not breached, so a broken list never locks users out.
+//
+// Parameters:
+// - password: The plain-text password to check.
+//
+// Returns:
+// - bool: True if the password is breached.
+func (app *Application) PasswordBreached(password string) bool {
+	if app.Breaches == nil {
+		return false
+	}
+
+	breached, err := app.Breaches.Breached(password)
+	if err != nil {
+		log.Printf("breached password lookup failed: %v", err)
+		return false
+	}
+	return breached
+}
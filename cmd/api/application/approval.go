@@ -0,0 +1,126 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/repositories"
+	"fmt"
+	"log"
+	"os"
+)
+
+// RegistrationApprovalData is passed to the registration approved and rejected templates.
+type RegistrationApprovalData struct {
+	UserID   string
+	Username string
+	Reason   string
+}
+
+// ApprovalRequired reports whether new users wait for an admin to approve them.
+func (app *Application) ApprovalRequired() bool {
+	return app.Config != nil && app.Config.Security.Registration.RequireApproval
+}
+
+// RegistrationStatus returns the status of a user who registers now. With approvals
+// required new users wait for an admin, unless an invite vouches for them or they are
+// the first user, who becomes the admin.
+//
+// Parameters:
+// - invite: The invite used for the registration, nil if there is none.
+//
+// Returns:
+// - string: models.UserStatusPendingApproval or models.UserStatusActive.
+// - error: An error if the users can not be counted.
+func (app *Application) RegistrationStatus(invite *models.Invite) (string, error) {
+	if !app.ApprovalRequired() || invite != nil {
+		return models.UserStatusActive, nil
+	}
+	count, err := app.Repository.CountUsers()
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return models.UserStatusActive, nil
+	}
+	return models.UserStatusPendingApproval, nil
+}
+
+// ApproveUser lets a user waiting for approval log in and emails them about it. Only
+// users who confirmed their email address can be approved.
+//
+// Parameters:
+// - userID: The ID of the user.
+// - actorID: The ID of the admin.
+//
+// Returns:
+// - error: repositories.ErrUserNotPending if the user is not waiting for approval,
+// ErrEmailNotConfirmed if the email address is not confirmed yet, or an error if the
+// user does not exist or can not be updated.
+func (app *Application) ApproveUser(userID, actorID string) error {
+	user, err := app.Repository.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Status != models.UserStatusPendingApproval {
+		return repositories.ErrUserNotPending
+	}
+	if !app.EmailConfirmed(user) {
+		return ErrEmailNotConfirmed
+	}
+
+	if err := app.Repository.DecideApprovalByID(userID, models.UserStatusActive, "", actorID); err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Your Account Was Approved %s", user.UserName)
+	msg := "Your registration was approved, you can log in now."
+	app.sendApprovalEmail(user, "", subject, msg, "registrationApproved")
+	return nil
+}
+
+// RejectUser rejects the registration of a user waiting for approval and emails them
+// the reason. The account stays, so the email address can not register again until
+// an admin deletes it.
+//
+// Parameters:
+// - userID: The ID of the user.
+// - actorID: The ID of the admin.
+// - reason: Why the registration was rejected, sent to the user.
+//
+// Returns:
+// - error: repositories.ErrUserNotPending if the user is not waiting for approval, or an
+// error if the user does not exist or can not be updated.
+func (app *Application) RejectUser(userID, actorID, reason string) error {
+	user, err := app.Repository.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := app.Repository.DecideApprovalByID(userID, models.UserStatusRejected, reason, actorID); err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Your Registration Was Not Approved %s", user.UserName)
+	msg := "Your registration was not approved."
+	if reason != "" {
+		msg = fmt.Sprintf("Your registration was not approved: %s", reason)
+	}
+	app.sendApprovalEmail(user, reason, subject, msg, "registrationRejected")
+	return nil
+}
+
+// sendApprovalEmail tells a user about the decision on the registration. The decision
+// is stored already, so a failed email is only logged.
+func (app *Application) sendApprovalEmail(user *models.User, reason, subject, msg, templateName string) {
+	data := RegistrationApprovalData{
+		UserID:   user.ID,
+		Username: user.UserName,
+		Reason:   reason,
+	}
+
+	apiKey := os.Getenv("MAIL_SERVER_API_KEY")
+	domain := os.Getenv("MAIL_SERVER_DOMAIN")
+	if _, err := controllers.SendTemplateEmail(domain, apiKey, user.Email, subject, msg, templateName, data, 0); err != nil {
+		log.Printf("error sending the %s email to %s: %v", templateName, user.ID, err)
+	}
+}
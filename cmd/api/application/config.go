@@ -1,9 +1,14 @@
 package application
 
 import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/passwords"
+	"TriceraPass/internal/ratelimit"
+	"TriceraPass/internal/store"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -12,13 +17,10 @@ import (
 // It holds configuration settings for the API, server, database, security, application, and styles.
 type Config struct {
 	API struct {
-		Name        string `yaml:"name"`        // API name
-		Version     string `yaml:"version"`     // API version
-		Description string `yaml:"description"` // API description
-		RateLimit   struct {
-			RequestsPerMinute int `yaml:"requests_per_minute"` // Max requests per minute
-			Burst             int `yaml:"burst"`               // Burst size for rate limiting
-		} `yaml:"rate_limiting"` // Rate limiting configuration
+		Name        string           `yaml:"name"`          // API name
+		Version     string           `yaml:"version"`       // API version
+		Description string           `yaml:"description"`   // API description
+		RateLimit   ratelimit.Config `yaml:"rate_limiting"` // Rate limiting configuration
 	} `yaml:"api"`
 
 	Server struct {
@@ -44,6 +46,61 @@ type Config struct {
 			Issuer   string `yaml:"issuer"`   // JWT issuer claim
 			Audience string `yaml:"audience"` // JWT audience claim
 		} `yaml:"jwt"`
+		Scopes            map[string][]string           `yaml:"scopes"`             // Scopes granted to each user mode
+		PasswordPolicy    controllers.PasswordPolicy    `yaml:"password_policy"`    // Rules for new passwords
+		BreachedPasswords controllers.BreachedPasswords `yaml:"breached_passwords"` // Offline breached password screening
+		PasswordHashing   passwords.Config              `yaml:"password_hashing"`   // Algorithm and parameters for password hashes
+		PasswordExpiry    struct {
+			MaxAgeDays    map[string]int `yaml:"max_age_days"`   // Maximum password age per user mode, 0 or missing never expires
+			WarnDays      int            `yaml:"warn_days"`      // Days before expiry the warning email is sent
+			CheckInterval time.Duration  `yaml:"check_interval"` // How often expiring passwords are looked for
+		} `yaml:"password_expiry"` // Forced password rotation
+		PasswordReset struct {
+			TokenTTL time.Duration `yaml:"token_ttl"` // How long an emailed reset link is valid, 1h by default
+			URL      string        `yaml:"url"`       // Page of the client application the reset link points to, the token is added as ?token=
+		} `yaml:"password_reset"` // Emailed password reset links
+		Lockout struct {
+			Enabled         bool          `yaml:"enabled"`          // Track failed logins and block guessing
+			FreeAttempts    int           `yaml:"free_attempts"`    // Failed logins allowed before the backoff starts
+			BaseDelay       time.Duration `yaml:"base_delay"`       // First backoff delay, doubled with every further failure
+			MaxDelay        time.Duration `yaml:"max_delay"`        // Longest backoff delay
+			MaxAttempts     int           `yaml:"max_attempts"`     // Failed logins per email address before the lockout
+			IPMaxAttempts   int           `yaml:"ip_max_attempts"`  // Failed logins per IP address before the lockout
+			Window          time.Duration `yaml:"window"`           // Failures older than this are forgotten
+			LockoutDuration time.Duration `yaml:"lockout_duration"` // How long a lockout lasts
+			UnlockURL       string        `yaml:"unlock_url"`       // Link in the unlock email, the token is added as ?token=
+		} `yaml:"lockout"` // Brute-force protection for the login
+		Registration struct {
+			Mode           string        `yaml:"mode"`            // open, closed, invite or domain, open by default
+			AllowedDomains []string      `yaml:"allowed_domains"` // Email domains that may register with the domain mode, any if empty
+			DeniedDomains  []string      `yaml:"denied_domains"`  // Email domains that may not register with the domain mode
+			InviteTTL      time.Duration `yaml:"invite_ttl"`      // Lifetime of invites created without an expiry, 0 keeps them valid until revoked
+			InviteURL      string        `yaml:"invite_url"`      // Sign-up page of the client application linked in invitations, the code is added as ?invite=
+			// RequireApproval lets new users log in only once an admin approved them, users with an invite are approved
+			RequireApproval bool `yaml:"require_approval"`
+		} `yaml:"registration"` // Who may register
+		EmailConfirmation struct {
+			Policy         string        `yaml:"policy"`          // claim, block or grace, claim by default
+			GracePeriod    time.Duration `yaml:"grace_period"`    // How long after registering unconfirmed users may log in with the grace policy
+			ResendInterval time.Duration `yaml:"resend_interval"` // Shortest time between two confirmation emails to one account, 1m by default
+			TokenTTL       time.Duration `yaml:"token_ttl"`       // How long the link in a confirmation email is valid, 24h by default
+			URL            string        `yaml:"url"`             // Confirmation page the link points to, the token is added as ?token=
+		} `yaml:"email_confirmation"` // What unconfirmed users may do
+		EmailChange struct {
+			TokenTTL     time.Duration `yaml:"token_ttl"`     // How long the link sent to the new address is valid, 24h by default
+			RevertWindow time.Duration `yaml:"revert_window"` // How long the link sent to the old address can undo the change, 168h by default
+			ConfirmURL   string        `yaml:"confirm_url"`   // Link sent to the new address, the token is added as ?token=
+			RevertURL    string        `yaml:"revert_url"`    // Link sent to the old address, the token is added as ?token=
+		} `yaml:"email_change"` // Verified changes of the email address
+		AccountDeletion struct {
+			GracePeriod   time.Duration `yaml:"grace_period"`   // How long deleted accounts can be restored before the purge, 720h by default
+			PurgeInterval time.Duration `yaml:"purge_interval"` // How often accounts past the grace period are purged, 1h by default
+		} `yaml:"account_deletion"` // Soft deletion of accounts
+		DataExport struct {
+			Dir     string        `yaml:"dir"`      // Where the archives are kept until their link expires, <root>/exports by default
+			LinkTTL time.Duration `yaml:"link_ttl"` // How long the emailed download link is valid, 24h by default
+			URL     string        `yaml:"url"`      // Download link in the email, the token is added as ?token=
+		} `yaml:"data_export"` // Downloadable archives of a user's data
 	} `yaml:"security"`
 
 	Application struct {
@@ -52,6 +109,15 @@ type Config struct {
 		Domain       string `yaml:"domain"`        // Application domain
 	} `yaml:"application"`
 
+	Redis store.RedisConfig `yaml:"redis"` // Shared state of all replicas, kept in memory without a host
+
+	Proxy struct {
+		Enabled        bool         `yaml:"enabled"`         // Run the embedded authenticating reverse proxy
+		LoginURL       string       `yaml:"login_url"`       // Where unauthenticated browser requests are redirected
+		IdentitySecret string       `yaml:"identity_secret"` // Secret used to sign the identity headers sent upstream
+		Routes         []ProxyRoute `yaml:"routes"`          // Upstreams served by path prefix
+	} `yaml:"proxy"`
+
 	Styles struct {
 		HeaderBackground string `yaml:"header_background"` // Background color for the header
 		HeaderColor      string `yaml:"header_color"`      // Text color for the header
@@ -63,6 +129,14 @@ type Config struct {
 	} `yaml:"styles"`
 }
 
+// ProxyRoute maps a path prefix to an upstream application behind the embedded reverse proxy.
+type ProxyRoute struct {
+	Prefix      string `yaml:"prefix"`       // Path prefix handled by this route, e.g. /legacy
+	Upstream    string `yaml:"upstream"`     // URL of the upstream application
+	Access      string `yaml:"access"`       // Access rule: public, authenticated or admin
+	StripPrefix bool   `yaml:"strip_prefix"` // Remove the prefix from the path before proxying
+}
+
 // LoadConfig loads and parses the settings.yml file into the Config struct.
 // It reads the configuration file and unmarshals its YAML content into the Config struct.
 //
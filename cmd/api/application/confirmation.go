@@ -0,0 +1,246 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Policies for users who did not confirm their email address yet.
+const (
+	ConfirmationPolicyClaim = "claim" // Log in, the tokens carry email_verified=false
+	ConfirmationPolicyBlock = "block" // No login before the confirmation
+	ConfirmationPolicyGrace = "grace" // Log in during the grace period after registering
+)
+
+// Outcomes of following a confirmation link.
+const (
+	ConfirmationConfirmed        = "confirmed"         // The email address is confirmed now
+	ConfirmationAlreadyConfirmed = "already_confirmed" // The email address was confirmed before
+	ConfirmationExpired          = "expired"           // The link expired, a new one has to be requested
+	ConfirmationInvalid          = "invalid"           // No confirmation carries the token
+)
+
+// ErrEmailNotConfirmed is returned when the confirmation policy rejects a login.
+var ErrEmailNotConfirmed = errors.New("email address not confirmed, check your inbox or request a new confirmation email")
+
+// ConfirmationData is passed to the confirmation email template.
+type ConfirmationData struct {
+	UserID     string
+	Username   string
+	ConfirmURL string
+	ExpiresIn  string
+}
+
+// ConfirmationPolicy returns the configured policy for unconfirmed users.
+//
+// Returns:
+// - string: One of the ConfirmationPolicy constants, ConfirmationPolicyClaim by default.
+func (app *Application) ConfirmationPolicy() string {
+	if app.Config == nil {
+		return ConfirmationPolicyClaim
+	}
+	switch policy := app.Config.Security.EmailConfirmation.Policy; policy {
+	case ConfirmationPolicyBlock, ConfirmationPolicyGrace:
+		return policy
+	}
+	return ConfirmationPolicyClaim
+}
+
+// EmailConfirmed reports whether the user confirmed the email address. Errors count
+// as unconfirmed.
+//
+// Parameters:
+// - user: The user.
+//
+// Returns:
+// - bool: True if one of the user's confirmations was confirmed.
+func (app *Application) EmailConfirmed(user *models.User) bool {
+	confirmed, err := app.Repository.IsUserConfirmed(user.ID)
+	if err != nil {
+		log.Printf("error loading the confirmation of user %s: %v", user.ID, err)
+		return false
+	}
+	return confirmed
+}
+
+// LoginAllowed applies the confirmation policy to a user whose credentials are valid.
+//
+// Parameters:
+// - user: The user logging in.
+// - confirmed: Whether the user confirmed the email address.
+//
+// Returns:
+// - error: ErrEmailNotConfirmed if the user has to confirm the email address first.
+func (app *Application) LoginAllowed(user *models.User, confirmed bool) error {
+	if confirmed {
+		return nil
+	}
+
+	switch app.ConfirmationPolicy() {
+	case ConfirmationPolicyBlock:
+		return ErrEmailNotConfirmed
+	case ConfirmationPolicyGrace:
+		if time.Since(user.CreatedAt) > app.Config.Security.EmailConfirmation.GracePeriod {
+			return ErrEmailNotConfirmed
+		}
+	}
+	return nil
+}
+
+// ResendConfirmation creates a new confirmation for the account of the email address
+// and sends the confirmation email again. Unknown and confirmed accounts, and accounts
+// that got an email within the resend interval, are skipped without an error, so the
+// caller can not tell them apart.
+//
+// Parameters:
+// - email: The email address of the account.
+//
+// Returns:
+// - error: An error if the confirmation can not be created or the email can not be sent.
+func (app *Application) ResendConfirmation(email string) error {
+	user, err := app.Repository.GetUserByEmail(email)
+	if err != nil || user.IsDeleted() || app.EmailConfirmed(user) {
+		return nil
+	}
+
+	confirmations, err := app.Repository.GetConfirmationsByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, confirmation := range confirmations {
+		if time.Since(confirmation.CreatedAt) < app.confirmationResendInterval() {
+			return nil
+		}
+	}
+
+	return app.SendConfirmation(user)
+}
+
+// ConfirmationTTL returns how long the link in a confirmation email is valid.
+//
+// Returns:
+// - time.Duration: The configured lifetime, 24 hours if none is set.
+func (app *Application) ConfirmationTTL() time.Duration {
+	if app.Config == nil || app.Config.Security.EmailConfirmation.TokenTTL <= 0 {
+		return 24 * time.Hour
+	}
+	return app.Config.Security.EmailConfirmation.TokenTTL
+}
+
+// ConfirmationURL returns the link sent in the confirmation email.
+//
+// Parameters:
+// - token: The raw confirmation token.
+//
+// Returns:
+// - string: The configured confirmation page with the token as query parameter.
+func (app *Application) ConfirmationURL(token string) string {
+	base := "http://localhost:1993/auth/api/confirmation"
+	if app.Config != nil && app.Config.Security.EmailConfirmation.URL != "" {
+		base = app.Config.Security.EmailConfirmation.URL
+	}
+	return linkWithToken(base, token)
+}
+
+// SendConfirmation stores a new single-use confirmation token for the user and emails
+// the link carrying it. Only the hash of the token is stored.
+//
+// Parameters:
+// - user: The user whose email address is to be confirmed.
+//
+// Returns:
+// - error: An error if the token can not be created or the email can not be sent.
+func (app *Application) SendConfirmation(user *models.User) error {
+	token, tokenHash, err := controllers.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := app.ConfirmationTTL()
+	now := time.Now()
+	confirmation := models.UserConfirmation{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiredAt: now.Add(ttl).Unix(),
+		CreatedAt: now,
+		Confirmed: false,
+	}
+	if _, err := app.Repository.InsertConfirmation(&confirmation); err != nil {
+		return err
+	}
+
+	data := ConfirmationData{
+		UserID:     user.ID,
+		Username:   user.UserName,
+		ConfirmURL: app.ConfirmationURL(token),
+		ExpiresIn:  humanDuration(ttl),
+	}
+	subject := fmt.Sprintf("Sign Up Confirmation for %s", user.UserName)
+	msg := fmt.Sprintf("Thank you for registering at Authentication API! Please verify your email to confirm your account using this link: %s", data.ConfirmURL)
+
+	apiKey := os.Getenv("MAIL_SERVER_API_KEY")
+	domain := os.Getenv("MAIL_SERVER_DOMAIN")
+
+	_, err = controllers.SendTemplateEmail(domain, apiKey, user.Email, subject, msg, "confirmationEmail", data, 0)
+	return err
+}
+
+// ConfirmEmail confirms the email address of the confirmation that carries the token.
+// Each token confirms once, a second use reports ConfirmationAlreadyConfirmed.
+//
+// Parameters:
+// - token: The raw token from the confirmation link.
+//
+// Returns:
+// - string: One of the Confirmation outcome constants.
+// - error: An error if the confirmation can not be stored.
+func (app *Application) ConfirmEmail(token string) (string, error) {
+	if token == "" {
+		return ConfirmationInvalid, nil
+	}
+
+	confirmation, err := app.Repository.GetConfirmationByTokenHash(controllers.HashToken(token))
+	if err != nil {
+		return ConfirmationInvalid, nil
+	}
+	if confirmation.Confirmed {
+		return ConfirmationAlreadyConfirmed, nil
+	}
+
+	// An older link of an account confirmed through a newer one
+	confirmed, err := app.Repository.IsUserConfirmed(confirmation.UserID)
+	if err != nil {
+		return "", err
+	}
+	if confirmed {
+		return ConfirmationAlreadyConfirmed, nil
+	}
+	if confirmation.IsExpired() {
+		return ConfirmationExpired, nil
+	}
+
+	marked, err := app.Repository.MarkConfirmed(confirmation.ID)
+	if err != nil {
+		return "", err
+	}
+	if !marked {
+		return ConfirmationAlreadyConfirmed, nil
+	}
+	return ConfirmationConfirmed, nil
+}
+
+// confirmationResendInterval returns the shortest time between two confirmation emails, 1 minute if none is configured.
+func (app *Application) confirmationResendInterval() time.Duration {
+	if app.Config == nil || app.Config.Security.EmailConfirmation.ResendInterval <= 0 {
+		return time.Minute
+	}
+	return app.Config.Security.EmailConfirmation.ResendInterval
+}
@@ -0,0 +1,384 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrExportPending is returned when a user asks for an export while the last one is still being built.
+var ErrExportPending = errors.New("a data export is already being prepared")
+
+// ErrInvalidExportToken is returned when a download link is unknown or expired.
+var ErrInvalidExportToken = errors.New("invalid or expired download link")
+
+// DataExportReadyData is passed to the data export ready template.
+type DataExportReadyData struct {
+	UserID      string
+	Username    string
+	DownloadURL string
+	ExpiresIn   string
+}
+
+// exportProfile is the profile of the user in an export, without the password hash.
+type exportProfile struct {
+	ID                       string     `json:"id"`
+	UserName                 string     `json:"username"`
+	FirstName                string     `json:"first_name"`
+	LastName                 string     `json:"last_name"`
+	Email                    string     `json:"email"`
+	CreatedAt                time.Time  `json:"created_at"`
+	PasswordChangedAt        time.Time  `json:"password_changed_at"`
+	PasswordRotationRequired bool       `json:"password_rotation_required"`
+	PasswordExpiryWarnedAt   *time.Time `json:"password_expiry_warned_at,omitempty"`
+}
+
+// exportSessions describes the sessions of the user. Tokens are stateless JWTs, so only
+// the time every earlier session was logged out is stored.
+type exportSessions struct {
+	SessionsRevokedAt *time.Time `json:"sessions_revoked_at,omitempty"`
+	Note              string     `json:"note"`
+}
+
+// exportEvent is an entry of the account history in an export.
+type exportEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+}
+
+// DataExportLinkTTL returns how long the emailed download link is valid.
+//
+// Returns:
+// - time.Duration: The configured lifetime, 24 hours if none is set.
+func (app *Application) DataExportLinkTTL() time.Duration {
+	if app.Config == nil || app.Config.Security.DataExport.LinkTTL <= 0 {
+		return 24 * time.Hour
+	}
+	return app.Config.Security.DataExport.LinkTTL
+}
+
+// RequestDataExport starts building an archive of the user's data in the background.
+// The user is emailed a download link once it is ready.
+//
+// Parameters:
+// - user: The user whose data is exported.
+//
+// Returns:
+// - string: The ID of the export.
+// - error: ErrExportPending if an export of the user is still being built, or an error
+// if the export can not be stored.
+func (app *Application) RequestDataExport(user *models.User) (string, error) {
+	pending, err := app.Repository.HasPendingDataExport(user.ID)
+	if err != nil {
+		return "", err
+	}
+	if pending {
+		return "", ErrExportPending
+	}
+
+	export := models.DataExport{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		Status:    models.DataExportPending,
+		CreatedAt: time.Now(),
+	}
+	if _, err := app.Repository.InsertDataExport(&export); err != nil {
+		return "", err
+	}
+
+	go func() {
+		if err := app.BuildDataExport(export.ID); err != nil {
+			log.Printf("error building the data export %s: %v", export.ID, err)
+			app.failDataExport(export.ID)
+		}
+	}()
+	return export.ID, nil
+}
+
+// failDataExport removes the archive of an export that could not be built or
+// emailed, and marks the export as failed so it is never downloaded.
+func (app *Application) failDataExport(exportID string) {
+	path := filepath.Join(app.dataExportDir(), exportID+".zip")
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("error removing the data export %s: %v", path, err)
+	}
+	if err := app.Repository.FailDataExport(exportID); err != nil {
+		log.Printf("error marking the data export %s as failed: %v", exportID, err)
+	}
+}
+
+// BuildDataExport writes the ZIP archive of an export and emails the user a
+// time-limited download link. Only a hash of the link's token is stored.
+//
+// Parameters:
+// - exportID: The ID of a pending export.
+//
+// Returns:
+// - error: An error if the archive can not be written or the email can not be sent.
+func (app *Application) BuildDataExport(exportID string) error {
+	export, err := app.Repository.GetDataExportByID(exportID)
+	if err != nil {
+		return err
+	}
+	user, err := app.Repository.GetUserByID(export.UserID)
+	if err != nil {
+		return err
+	}
+
+	dir := app.dataExportDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, export.ID+".zip")
+	if err := app.writeDataExport(path, user); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	token, tokenHash, err := controllers.GenerateToken()
+	if err != nil {
+		return err
+	}
+	ttl := app.DataExportLinkTTL()
+	if err := app.Repository.CompleteDataExport(export.ID, path, tokenHash, time.Now().Add(ttl)); err != nil {
+		return err
+	}
+
+	data := DataExportReadyData{
+		UserID:      user.ID,
+		Username:    user.UserName,
+		DownloadURL: app.dataExportURL(token),
+		ExpiresIn:   humanDuration(ttl),
+	}
+	subject := fmt.Sprintf("Your Data Export Is Ready %s", user.UserName)
+	msg := fmt.Sprintf("The archive of your account data can be downloaded from the following link: %s", data.DownloadURL)
+
+	apiKey := os.Getenv("MAIL_SERVER_API_KEY")
+	domain := os.Getenv("MAIL_SERVER_DOMAIN")
+	_, err = controllers.SendTemplateEmail(domain, apiKey, user.Email, subject, msg, "dataExportReady", data, 0)
+	return err
+}
+
+// DataExportFile returns the archive whose download link carries the token.
+//
+// Parameters:
+// - token: The raw token from the download link.
+//
+// Returns:
+// - *models.DataExport: The export, FilePath is the archive.
+// - error: ErrInvalidExportToken if the token is unknown or the link expired.
+func (app *Application) DataExportFile(token string) (*models.DataExport, error) {
+	if token == "" {
+		return nil, ErrInvalidExportToken
+	}
+	export, err := app.Repository.GetDataExportByTokenHash(controllers.HashToken(token))
+	if err != nil || export.IsExpired() {
+		return nil, ErrInvalidExportToken
+	}
+	return export, nil
+}
+
+// PurgeExpiredDataExports removes the exports whose download link expired, with their archives.
+//
+// Returns:
+// - int: The number of removed exports.
+// - error: An error if the exports can not be loaded.
+func (app *Application) PurgeExpiredDataExports() (int, error) {
+	exports, err := app.Repository.GetExpiredDataExports(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, export := range exports {
+		if err := os.Remove(export.FilePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("error removing the data export %s: %v", export.FilePath, err)
+			continue
+		}
+		if err := app.Repository.DeleteDataExport(export.ID); err != nil {
+			log.Printf("error deleting the data export %s: %v", export.ID, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// writeDataExport writes the archive of the user's data to path.
+func (app *Application) writeDataExport(path string, user *models.User) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	confirmations, err := app.Repository.GetConfirmationsByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	resetTokens, err := app.Repository.GetPasswordTokensByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	emailChanges, err := app.Repository.GetEmailChangesByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	images, err := app.Repository.GetProfileImagesByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+
+	entries := []struct {
+		name  string
+		value interface{}
+	}{
+		{"profile.json", exportProfile{
+			ID:                       user.ID,
+			UserName:                 user.UserName,
+			FirstName:                user.FirstName,
+			LastName:                 user.LastName,
+			Email:                    user.Email,
+			CreatedAt:                user.CreatedAt,
+			PasswordChangedAt:        user.PasswordChangedAt,
+			PasswordRotationRequired: user.PasswordRotationRequired,
+			PasswordExpiryWarnedAt:   user.PasswordExpiryWarnedAt,
+		}},
+		{"mode.json", user.Mode},
+		{"confirmations.json", confirmations},
+		{"password_reset_tokens.json", resetTokens},
+		{"email_changes.json", emailChanges},
+		{"sessions.json", exportSessions{
+			SessionsRevokedAt: user.SessionsRevokedAt,
+			Note:              "Sessions are signed tokens that are not stored. Every session issued before sessions_revoked_at was logged out.",
+		}},
+		{"audit_events.json", auditEvents(user, confirmations, resetTokens, emailChanges)},
+	}
+	for _, entry := range entries {
+		if err := writeJSONEntry(archive, entry.name, entry.value); err != nil {
+			return err
+		}
+	}
+
+	for _, image := range images {
+		if image.DeletedAt.Valid {
+			continue
+		}
+		if err := copyFileEntry(archive, "profile_image/"+filepath.Base(image.FilePath), image.FilePath); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// auditEvents lists what happened to the account, oldest first, from the stored records.
+func auditEvents(user *models.User, confirmations []*models.UserConfirmation, resetTokens []models.PasswordRestToken, emailChanges []models.EmailChange) []exportEvent {
+	events := []exportEvent{{user.CreatedAt, "account created"}}
+	for _, confirmation := range confirmations {
+		events = append(events, exportEvent{confirmation.CreatedAt, "confirmation email sent"})
+	}
+	for _, token := range resetTokens {
+		events = append(events, exportEvent{token.CreatedAt, "password reset requested"})
+	}
+	for _, change := range emailChanges {
+		events = append(events, exportEvent{change.CreatedAt, fmt.Sprintf("email change to %s requested", change.NewEmail)})
+		if change.ConfirmedAt != nil {
+			events = append(events, exportEvent{*change.ConfirmedAt, fmt.Sprintf("email changed to %s", change.NewEmail)})
+		}
+		if change.RevertedAt != nil {
+			events = append(events, exportEvent{*change.RevertedAt, fmt.Sprintf("email change to %s reverted", change.NewEmail)})
+		}
+	}
+	if !user.PasswordChangedAt.IsZero() {
+		events = append(events, exportEvent{user.PasswordChangedAt, "password changed"})
+	}
+	if user.SessionsRevokedAt != nil {
+		events = append(events, exportEvent{*user.SessionsRevokedAt, "all sessions logged out"})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events
+}
+
+// writeJSONEntry adds value to the archive as an indented JSON file.
+func writeJSONEntry(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// copyFileEntry adds the file at path to the archive.
+func copyFileEntry(archive *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// dataExportDir returns where the archives are kept.
+func (app *Application) dataExportDir() string {
+	if app.Config != nil && app.Config.Security.DataExport.Dir != "" {
+		return app.Config.Security.DataExport.Dir
+	}
+	return filepath.Join(app.Root, "exports")
+}
+
+// dataExportURL returns the download link sent in the email.
+func (app *Application) dataExportURL(token string) string {
+	base := "http://localhost:1993/auth/api/export/download"
+	if app.Config != nil && app.Config.Security.DataExport.URL != "" {
+		base = app.Config.Security.DataExport.URL
+	}
+	return linkWithToken(base, token)
+}
+
+// removeDataExportFiles removes the archives of every export of a user, before
+// the exports themselves are deleted with the account.
+func (app *Application) removeDataExportFiles(userID string) error {
+	exports, err := app.Repository.GetDataExportsByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if export.FilePath == "" {
+			continue
+		}
+		if err := os.Remove(export.FilePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
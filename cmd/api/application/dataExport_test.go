@@ -0,0 +1,92 @@
+package application_test
+
+import (
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/testenv"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that exports whose link expired are removed with their archive
+func TestPurgeExpiredDataExports(t *testing.T) {
+	env := testenv.New(t)
+
+	userID := env.Register(t, "malcolm", "chaos-theory")
+	env.Mail.WaitFor(t, 1)
+	user, err := env.App.Repository.GetUserByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exportID, err := env.App.RequestDataExport(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Mail.WaitFor(t, 2)
+	export, err := env.App.Repository.GetDataExportByID(exportID)
+	if err != nil || export.Status != models.DataExportReady {
+		t.Fatalf("expected a ready export, got %+v, error: %v", export, err)
+	}
+
+	// Links that are still valid are kept
+	if purged, err := env.App.PurgeExpiredDataExports(); err != nil || purged != 0 {
+		t.Fatalf("expected no purge before the link expires, got %d, error: %v", purged, err)
+	}
+
+	if err := env.App.Repository.DB.Model(&models.DataExport{}).Where("id = ?", exportID).
+		Update("expired_at", time.Now().Add(-time.Minute).Unix()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := env.App.PurgeExpiredDataExports(); err != nil || purged != 1 {
+		t.Errorf("expected one purged export, got %d, error: %v", purged, err)
+	}
+	if _, err := os.Stat(export.FilePath); !os.IsNotExist(err) {
+		t.Errorf("expected the archive to be removed, got %v", err)
+	}
+	if _, err := env.App.Repository.GetDataExportByID(exportID); err == nil {
+		t.Error("expected the export record to be removed")
+	}
+}
+
+// Test that an export whose email can not be sent is failed and its archive removed
+func TestFailedDataExport(t *testing.T) {
+	env := testenv.New(t)
+
+	userID := env.Register(t, "muldoon", "shoot-her")
+	env.Mail.WaitFor(t, 1)
+	user, err := env.App.Repository.GetUserByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	t.Setenv("MAIL_SERVER_API_BASE", unreachable.URL+"/v3")
+
+	exportID, err := env.App.RequestDataExport(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export *models.DataExport
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		if export, err = env.App.Repository.GetDataExportByID(exportID); err == nil && export.Status == models.DataExportFailed {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if export == nil || export.Status != models.DataExportFailed {
+		t.Fatalf("expected the export to fail, got %+v, error: %v", export, err)
+	}
+	if export.FilePath != "" || export.TokenHash != "" {
+		t.Errorf("expected the archive and the token to be forgotten, got %+v", export)
+	}
+	path := filepath.Join(env.App.Root, "exports", exportID+".zip")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the archive to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/repositories"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outcomes of following an email change link.
+const (
+	EmailChangeConfirmed        = "confirmed"         // The new address replaced the old one
+	EmailChangeAlreadyConfirmed = "already_confirmed" // The change was confirmed before
+	EmailChangeReverted         = "reverted"          // The old address is back and every session was logged out
+	EmailChangeAlreadyReverted  = "already_reverted"  // The change was reverted or cancelled before
+	EmailChangeExpired          = "expired"           // The link expired
+	EmailChangeTaken            = "taken"             // Another account uses the new address by now
+	EmailChangeInvalid          = "invalid"           // No change carries the token
+)
+
+// ErrInvalidEmail is returned when a new email address can not be parsed.
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// EmailChangeData is passed to the email change templates.
+type EmailChangeData struct {
+	UserID     string
+	Username   string
+	OldEmail   string
+	NewEmail   string
+	ConfirmURL string
+	RevertURL  string
+	ExpiresIn  string
+	RevertIn   string
+}
+
+// EmailChangeTTL returns how long the link sent to the new address is valid.
+//
+// Returns:
+// - time.Duration: The configured lifetime, 24 hours if none is set.
+func (app *Application) EmailChangeTTL() time.Duration {
+	if app.Config == nil || app.Config.Security.EmailChange.TokenTTL <= 0 {
+		return 24 * time.Hour
+	}
+	return app.Config.Security.EmailChange.TokenTTL
+}
+
+// EmailChangeRevertWindow returns how long the link sent to the old address can undo a change.
+//
+// Returns:
+// - time.Duration: The configured window, 7 days if none is set.
+func (app *Application) EmailChangeRevertWindow() time.Duration {
+	if app.Config == nil || app.Config.Security.EmailChange.RevertWindow <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return app.Config.Security.EmailChange.RevertWindow
+}
+
+// ValidateEmailChange checks a requested address before the change is started.
+//
+// Parameters:
+// - user: The user whose address changes.
+// - newEmail: The requested address.
+//
+// Returns:
+// - string: The normalized address.
+// - error: ErrInvalidEmail for a malformed address, or repositories.ErrUserExists if
+// another account uses it.
+func (app *Application) ValidateEmailChange(user *models.User, newEmail string) (string, error) {
+	newEmail = strings.TrimSpace(newEmail)
+	if address, err := mail.ParseAddress(newEmail); err != nil || address.Address != newEmail {
+		return "", ErrInvalidEmail
+	}
+	newEmail = models.NormalizeEmail(newEmail)
+	if existing, err := app.Repository.GetUserByEmail(newEmail); err == nil && existing.ID != user.ID {
+		return "", repositories.ErrUserExists
+	}
+	return newEmail, nil
+}
+
+// RequestEmailChange starts a change of the user's email address. The address is not
+// changed yet: the new address gets a confirmation link and the old address a notice
+// with a link that undoes the change. Earlier changes that were not confirmed yet are
+// cancelled. Only hashes of both tokens are stored.
+//
+// Parameters:
+// - user: The user whose address changes.
+// - newEmail: The requested address.
+//
+// Returns:
+// - error: ErrInvalidEmail for a malformed address, repositories.ErrUserExists if another
+// account uses it, or an error if the change can not be stored or the emails can not be sent.
+func (app *Application) RequestEmailChange(user *models.User, newEmail string) error {
+	newEmail, err := app.ValidateEmailChange(user, newEmail)
+	if err != nil {
+		return err
+	}
+
+	if err := app.Repository.CancelPendingEmailChanges(user.ID); err != nil {
+		return err
+	}
+
+	token, tokenHash, err := controllers.GenerateToken()
+	if err != nil {
+		return err
+	}
+	revertToken, revertTokenHash, err := controllers.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := app.EmailChangeTTL()
+	window := app.EmailChangeRevertWindow()
+	now := time.Now()
+	change := models.EmailChange{
+		ID:              uuid.NewString(),
+		UserID:          user.ID,
+		OldEmail:        user.Email,
+		NewEmail:        newEmail,
+		TokenHash:       tokenHash,
+		RevertTokenHash: revertTokenHash,
+		ExpiredAt:       now.Add(ttl).Unix(),
+		RevertExpiredAt: now.Add(window).Unix(),
+		CreatedAt:       now,
+	}
+	if _, err := app.Repository.InsertEmailChange(&change); err != nil {
+		return err
+	}
+
+	data := EmailChangeData{
+		UserID:     user.ID,
+		Username:   user.UserName,
+		OldEmail:   user.Email,
+		NewEmail:   newEmail,
+		ConfirmURL: app.emailChangeConfirmURL(token),
+		RevertURL:  app.emailChangeRevertURL(revertToken),
+		ExpiresIn:  humanDuration(ttl),
+		RevertIn:   humanDuration(window),
+	}
+
+	apiKey := os.Getenv("MAIL_SERVER_API_KEY")
+	domain := os.Getenv("MAIL_SERVER_DOMAIN")
+
+	subject := fmt.Sprintf("Confirm Your New Email Address %s", user.UserName)
+	msg := fmt.Sprintf("To use this address for your account, please open the following link: %s", data.ConfirmURL)
+	if _, err := controllers.SendTemplateEmail(domain, apiKey, newEmail, subject, msg, "emailChangeConfirm", data, 0); err != nil {
+		return err
+	}
+
+	subject = fmt.Sprintf("Your Email Address Is Being Changed %s", user.UserName)
+	msg = fmt.Sprintf("Someone asked to change the email address of your account to %s. If it was not you, open the following link: %s", newEmail, data.RevertURL)
+	_, err = controllers.SendTemplateEmail(domain, apiKey, user.Email, subject, msg, "emailChangeNotice", data, 0)
+	return err
+}
+
+// ConfirmEmailChange swaps the email address of the change whose confirmation link
+// carries the token.
+//
+// Parameters:
+// - token: The raw token from the link sent to the new address.
+//
+// Returns:
+// - string: One of the EmailChange outcome constants.
+// - error: An error if the change can not be stored.
+func (app *Application) ConfirmEmailChange(token string) (string, error) {
+	if token == "" {
+		return EmailChangeInvalid, nil
+	}
+
+	tokenHash := controllers.HashToken(token)
+	change, err := app.Repository.GetEmailChangeByTokenHash(tokenHash)
+	switch {
+	case err != nil:
+		return EmailChangeInvalid, nil
+	case change.ConfirmedAt != nil:
+		return EmailChangeAlreadyConfirmed, nil
+	case change.RevertedAt != nil:
+		return EmailChangeAlreadyReverted, nil
+	case change.IsExpired():
+		return EmailChangeExpired, nil
+	}
+
+	_, err = app.Repository.ConfirmEmailChange(tokenHash)
+	switch {
+	case errors.Is(err, repositories.ErrUserExists):
+		return EmailChangeTaken, nil
+	case errors.Is(err, repositories.ErrInvalidEmailChangeToken):
+		// Confirmed, reverted or replaced concurrently
+		return EmailChangeInvalid, nil
+	case err != nil:
+		return "", err
+	}
+	return EmailChangeConfirmed, nil
+}
+
+// RevertEmailChange restores the address the change replaced and logs the user out
+// everywhere, to recover an account whose address was changed by someone else.
+//
+// Parameters:
+// - token: The raw token from the link sent to the old address.
+//
+// Returns:
+// - string: One of the EmailChange outcome constants.
+// - error: An error if the change can not be stored.
+func (app *Application) RevertEmailChange(token string) (string, error) {
+	if token == "" {
+		return EmailChangeInvalid, nil
+	}
+
+	tokenHash := controllers.HashToken(token)
+	change, err := app.Repository.GetEmailChangeByRevertTokenHash(tokenHash)
+	switch {
+	case err != nil:
+		return EmailChangeInvalid, nil
+	case change.RevertedAt != nil:
+		return EmailChangeAlreadyReverted, nil
+	case change.IsRevertExpired():
+		return EmailChangeExpired, nil
+	}
+
+	_, err = app.Repository.RevertEmailChange(tokenHash)
+	switch {
+	case errors.Is(err, repositories.ErrUserExists):
+		// Another account took the old address in the meantime
+		return EmailChangeTaken, nil
+	case errors.Is(err, repositories.ErrInvalidEmailChangeToken):
+		return EmailChangeAlreadyReverted, nil
+	case err != nil:
+		return "", err
+	}
+	return EmailChangeReverted, nil
+}
+
+// emailChangeConfirmURL returns the link sent to the new address.
+func (app *Application) emailChangeConfirmURL(token string) string {
+	base := "http://localhost:1993/auth/api/email_change/confirm"
+	if app.Config != nil && app.Config.Security.EmailChange.ConfirmURL != "" {
+		base = app.Config.Security.EmailChange.ConfirmURL
+	}
+	return linkWithToken(base, token)
+}
+
+// emailChangeRevertURL returns the link sent to the old address.
+func (app *Application) emailChangeRevertURL(token string) string {
+	base := "http://localhost:1993/auth/api/email_change/revert"
+	if app.Config != nil && app.Config.Security.EmailChange.RevertURL != "" {
+		base = app.Config.Security.EmailChange.RevertURL
+	}
+	return linkWithToken(base, token)
+}
+
+// humanDuration formats a lifetime for an email, in days, hours or minutes.
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+}
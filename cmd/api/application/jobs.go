@@ -0,0 +1,41 @@
+package application
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runExclusive runs a background job unless another replica is running it. The job is
+// guarded by a claim in app.Store, which is released when the job ends and expires
+// after the ttl if the replica stops in the middle of the run.
+//
+// Parameters:
+// - name: The name of the job, used as the claimed key.
+// - ttl: How long the claim is kept at most, longer than a run is expected to take.
+// - job: The job to run.
+//
+// Returns:
+// - bool: False if the job was skipped because another replica holds the claim.
+func (app *Application) runExclusive(name string, ttl time.Duration, job func()) bool {
+	ctx := context.Background()
+	key := "job:" + name
+	claimed, err := app.Store.Claim(ctx, key, uuid.NewString(), ttl)
+	if err != nil {
+		log.Printf("error claiming the %s job: %v", name, err)
+		return false
+	}
+	if !claimed {
+		return false
+	}
+	defer func() {
+		if _, err := app.Store.TakeCode(ctx, key); err != nil {
+			log.Printf("error releasing the %s job: %v", name, err)
+		}
+	}()
+
+	job()
+	return true
+}
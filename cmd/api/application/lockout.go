@@ -0,0 +1,250 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// ErrInvalidUnlockToken is returned when an account unlock token is unknown, expired or was used.
+var ErrInvalidUnlockToken = errors.New("invalid or used unlock token")
+
+// AccountLockedData is passed to the account locked template.
+type AccountLockedData struct {
+	UserID    string
+	Username  string
+	UnlockURL string
+	LockedFor string
+}
+
+// LockoutEnabled reports whether failed logins are tracked. The counters are kept in
+// app.Store, so all replicas share them when Redis is configured.
+func (app *Application) LockoutEnabled() bool {
+	return app.Config != nil && app.Config.Security.Lockout.Enabled
+}
+
+// LoginBlocked reports whether a login for the email address from the IP address has to
+// wait, because of the backoff after failed logins or a lockout. Unknown email addresses
+// are throttled the same way, so the answer does not tell whether an account exists.
+// IP addresses have no backoff, since many users can share one, they are only locked out.
+//
+// Parameters:
+// - email: The email address of the login.
+// - ip: The IP address of the client.
+//
+// Returns:
+// - time.Duration: How long the client has to wait.
+// - bool: True if the login must be rejected without checking the password.
+func (app *Application) LoginBlocked(email, ip string) (time.Duration, bool) {
+	if !app.LockoutEnabled() {
+		return 0, false
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{ipThrottleKey(ip), emailThrottleKey(email)} {
+		throttle, err := app.Store.GetThrottle(context.Background(), key)
+		if err != nil {
+			log.Printf("error loading the login throttle of %s: %v", key, err)
+			continue
+		}
+
+		if throttle.Locked(now) {
+			wait = maxDuration(wait, throttle.LockedUntil.Sub(now))
+			continue
+		}
+		if key == ipThrottleKey(ip) || now.Sub(throttle.LastFailureAt) > app.lockoutWindow() {
+			continue
+		}
+		if next := throttle.LastFailureAt.Add(app.loginBackoff(throttle.Failures)); now.Before(next) {
+			wait = maxDuration(wait, next.Sub(now))
+		}
+	}
+	return wait, wait > 0
+}
+
+// RecordFailedLogin counts a failed login for the email and the IP address and locks
+// them out once their thresholds are reached. The owner of a locked account is emailed
+// an unlock link.
+//
+// Parameters:
+// - email: The email address of the login.
+// - ip: The IP address of the client.
+// - user: The account of the email address, nil if there is none.
+func (app *Application) RecordFailedLogin(email, ip string, user *models.User) {
+	if !app.LockoutEnabled() {
+		return
+	}
+	config := app.Config.Security.Lockout
+	now := time.Now()
+
+	ctx := context.Background()
+
+	key := emailThrottleKey(email)
+	throttle, err := app.Store.RecordFailure(ctx, key, now, app.lockoutWindow())
+	if err != nil {
+		log.Printf("error recording a failed login for %s: %v", email, err)
+	} else if config.MaxAttempts > 0 && throttle.Failures >= config.MaxAttempts && !throttle.Locked(now) {
+		app.lockAccount(key, now.Add(app.lockoutDuration()), user)
+	}
+
+	key = ipThrottleKey(ip)
+	throttle, err = app.Store.RecordFailure(ctx, key, now, app.lockoutWindow())
+	if err != nil {
+		log.Printf("error recording a failed login from %s: %v", ip, err)
+	} else if config.IPMaxAttempts > 0 && throttle.Failures >= config.IPMaxAttempts && !throttle.Locked(now) {
+		if err := app.Store.Lock(ctx, key, now.Add(app.lockoutDuration())); err != nil {
+			log.Printf("error locking logins from %s: %v", ip, err)
+		}
+	}
+}
+
+// RecordSuccessfulLogin forgets the failed logins of the email address.
+//
+// Parameters:
+// - email: The email address of the login.
+func (app *Application) RecordSuccessfulLogin(email string) {
+	if !app.LockoutEnabled() {
+		return
+	}
+	if err := app.Store.ResetThrottle(context.Background(), emailThrottleKey(email)); err != nil {
+		log.Printf("error resetting the failed logins of %s: %v", email, err)
+	}
+}
+
+// UnlockAccount lifts the lockout of an email address and forgets its failed logins.
+//
+// Parameters:
+// - email: The email address of the account.
+//
+// Returns:
+// - error: An error if the lockout can not be removed.
+func (app *Application) UnlockAccount(email string) error {
+	return app.Store.ResetThrottle(context.Background(), emailThrottleKey(email))
+}
+
+// UnlockAccountWithToken lifts the lockout the unlock email was sent for. The token works once.
+//
+// Parameters:
+// - token: The token from the unlock link.
+//
+// Returns:
+// - error: ErrInvalidUnlockToken if the token is unknown, expired or was used.
+func (app *Application) UnlockAccountWithToken(token string) error {
+	ctx := context.Background()
+	key, err := app.Store.TakeCode(ctx, unlockCodeKey(controllers.HashToken(token)))
+	if errors.Is(err, store.ErrCodeNotFound) {
+		return ErrInvalidUnlockToken
+	}
+	if err != nil {
+		return err
+	}
+	return app.Store.ResetThrottle(ctx, key)
+}
+
+// lockAccount locks the email address and, if it belongs to an account, emails the
+// owner a single-use unlock link. Only a hash of the link's token is stored.
+func (app *Application) lockAccount(key string, until time.Time, user *models.User) {
+	ctx := context.Background()
+	if err := app.Store.Lock(ctx, key, until); err != nil {
+		log.Printf("error locking %s: %v", key, err)
+		return
+	}
+	if user == nil {
+		return
+	}
+
+	token, tokenHash, err := controllers.GenerateToken()
+	if err == nil {
+		err = app.Store.SetCode(ctx, unlockCodeKey(tokenHash), key, time.Until(until))
+	}
+	if err != nil {
+		log.Printf("error creating the unlock token of %s: %v", user.ID, err)
+		return
+	}
+
+	go func() {
+		data := AccountLockedData{
+			UserID:    user.ID,
+			Username:  user.UserName,
+			UnlockURL: app.unlockURL(token),
+			LockedFor: fmt.Sprintf("%d minutes", int(app.lockoutDuration().Minutes())),
+		}
+		subject := fmt.Sprintf("Your Account Was Locked %s", user.UserName)
+		msg := fmt.Sprintf("There were too many failed login attempts on your account. To unlock it open the following link: %s", data.UnlockURL)
+
+		apiKey := os.Getenv("MAIL_SERVER_API_KEY")
+		domain := os.Getenv("MAIL_SERVER_DOMAIN")
+		if _, err := controllers.SendTemplateEmail(domain, apiKey, user.Email, subject, msg, "accountLocked", data, 0); err != nil {
+			log.Printf("error sending the unlock email to %s: %v", user.ID, err)
+		}
+	}()
+}
+
+// loginBackoff returns how long to wait after the given number of failed logins.
+func (app *Application) loginBackoff(failures int) time.Duration {
+	config := app.Config.Security.Lockout
+	excess := failures - config.FreeAttempts
+	if excess <= 0 || config.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := config.BaseDelay
+	for i := 1; i < excess && (config.MaxDelay <= 0 || delay < config.MaxDelay); i++ {
+		delay *= 2
+	}
+	if config.MaxDelay > 0 && delay > config.MaxDelay {
+		delay = config.MaxDelay
+	}
+	return delay
+}
+
+// lockoutWindow returns how long failed logins are remembered, 15 minutes if none is configured.
+func (app *Application) lockoutWindow() time.Duration {
+	if app.Config.Security.Lockout.Window <= 0 {
+		return 15 * time.Minute
+	}
+	return app.Config.Security.Lockout.Window
+}
+
+// lockoutDuration returns how long a lockout lasts, 30 minutes if none is configured.
+func (app *Application) lockoutDuration() time.Duration {
+	if app.Config.Security.Lockout.LockoutDuration <= 0 {
+		return 30 * time.Minute
+	}
+	return app.Config.Security.Lockout.LockoutDuration
+}
+
+// unlockURL returns the link sent in the unlock email.
+func (app *Application) unlockURL(token string) string {
+	base := "http://localhost:1993/auth/api/unlock"
+	if app.Config != nil && app.Config.Security.Lockout.UnlockURL != "" {
+		base = app.Config.Security.Lockout.UnlockURL
+	}
+	return linkWithToken(base, token)
+}
+
+func emailThrottleKey(email string) string {
+	return "login:email:" + models.NormalizeEmail(email)
+}
+
+func ipThrottleKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+func unlockCodeKey(tokenHash string) string {
+	return "unlock:" + tokenHash
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
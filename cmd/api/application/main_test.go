@@ -0,0 +1,11 @@
+package application_test
+
+import (
+	"TriceraPass/internal/testenv"
+	"testing"
+)
+
+// TestMain runs the suite from the repository root so the email templates can be found.
+func TestMain(m *testing.M) {
+	testenv.Main(m)
+}
@@ -1,12 +1,17 @@
 package application
 
 import (
+	"TriceraPass/cmd/api/utils"
+	"TriceraPass/internal/models"
+	"TriceraPass/pkg/verifier"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -26,8 +31,9 @@ const userContextKey contextKey = "userID"
 // - http.Handler: The middleware handler that processes CORS and calls the next handler.
 func (app *Application) EnableCORS(h http.Handler) http.Handler {
 
+	// The env file is optional when the variables are already exported
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && os.Getenv("CORS") == "" {
 		log.Fatal(fmt.Printf("Cannot locate the env file: %v", err))
 	}
 
@@ -62,7 +68,7 @@ func (app *Application) EnableCORS(h http.Handler) http.Handler {
 
 // AuthRequired is a middleware function that checks if a request is authenticated.
 // It verifies the JWT token from the Authorization header, extracts the user ID,
-// and stores it in the request context for further processing.
+// and stores it together with the verified claims in the request context for further processing.
 //
 // Parameters:
 // - next: The next HTTP handler to call after authentication succeeds.
@@ -70,17 +76,90 @@ func (app *Application) EnableCORS(h http.Handler) http.Handler {
 // Returns:
 // - http.Handler: The middleware handler that checks authentication and calls the next handler.
 func (app *Application) AuthRequired(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID, _, err := app.Auth.GetTokenFromHeaderAndVerify(w, r)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+	return app.AuthAllowing()(next)
+}
 
-		// Store the user ID in the request context
-		ctx := context.WithValue(r.Context(), userContextKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// AuthAllowing works like AuthRequired but also lets restricted tokens with one of the
+// given restrictions through, e.g. the password change required tokens on the change
+// password route. Other restricted tokens are answered with 403.
+//
+// Parameters:
+// - restrictions: The token restrictions accepted by the protected routes.
+//
+// Returns:
+// - func(http.Handler) http.Handler: The authentication middleware.
+func (app *Application) AuthAllowing(restrictions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, claims, err := app.Auth.GetTokenFromHeaderAndVerifyAllowing(w, r, verifier.RestrictionPasswordChange)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if app.SessionRevoked(claims) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if claims.Restriction != "" && !containsRestriction(restrictions, claims.Restriction) {
+				utils.ErrorJSON(w, errors.New("password change required"), http.StatusForbidden)
+				return
+			}
+
+			// Store the user ID and the claims in the request context
+			ctx := context.WithValue(r.Context(), userContextKey, claims.Subject)
+			ctx = verifier.NewContext(ctx, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// SessionRevoked reports whether the token was issued before the user's sessions were
+// revoked, e.g. by a password reset. Tokens of deleted users, including users waiting
+// for their purge, and of suspended or locked users count as revoked.
+//
+// Parameters:
+// - claims: The verified claims of the token.
+//
+// Returns:
+// - bool: True if the token must no longer be accepted.
+func (app *Application) SessionRevoked(claims *verifier.Claims) bool {
+	user, err := app.Repository.GetUserByID(claims.Subject)
+	if err != nil {
+		return true
+	}
+	return app.UserSessionRevoked(user, claims)
+}
+
+// UserSessionRevoked works like SessionRevoked for a user that was already loaded.
+//
+// Parameters:
+// - user: The user of the token.
+// - claims: The verified claims of the token.
+//
+// Returns:
+// - bool: True if the token must no longer be accepted.
+func (app *Application) UserSessionRevoked(user *models.User, claims *verifier.Claims) bool {
+	if app.AccountBlocked(user) != nil {
+		return true
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	return user.TokenRevoked(issuedAt)
+}
+
+// containsRestriction reports whether the restriction is in the list.
+func containsRestriction(restrictions []string, restriction string) bool {
+	for _, r := range restrictions {
+		if r == restriction {
+			return true
+		}
+	}
+	return false
 }
 
 // AdminRequired is a middleware function that ensures the user has admin privileges.
@@ -100,6 +179,11 @@ func (app *Application) AdminRequired(next http.Handler) http.Handler {
 			return
 		}
 
+		if app.SessionRevoked(claims) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
 		isAdmin, err := app.IsUserAdmin(claims.Subject)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -111,7 +195,8 @@ func (app *Application) AdminRequired(next http.Handler) http.Handler {
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// Store the claims so admin handlers know who acts
+		next.ServeHTTP(w, r.WithContext(verifier.NewContext(r.Context(), claims)))
 	})
 }
 
@@ -0,0 +1,165 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// PasswordExpiryData is passed to the password expiry warning template.
+type PasswordExpiryData struct {
+	UserID    string
+	Username  string
+	ExpiresAt string
+	DaysLeft  int
+}
+
+// PasswordMaxAge returns the maximum password age configured for a user mode.
+//
+// Parameters:
+// - mode: The name of the user mode.
+//
+// Returns:
+// - time.Duration: The maximum age, 0 if passwords of the mode never expire.
+func (app *Application) PasswordMaxAge(mode string) time.Duration {
+	if app.Config == nil {
+		return 0
+	}
+	days := app.Config.Security.PasswordExpiry.MaxAgeDays[mode]
+	if days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// PasswordExpiresAt returns when the user's password expires.
+//
+// Parameters:
+// - user: The user, with the mode loaded.
+//
+// Returns:
+// - time.Time: The expiry time.
+// - bool: False if the password never expires.
+func (app *Application) PasswordExpiresAt(user *models.User) (time.Time, bool) {
+	maxAge := app.PasswordMaxAge(user.Mode.Name)
+	if maxAge == 0 {
+		return time.Time{}, false
+	}
+	return user.PasswordSetAt().Add(maxAge), true
+}
+
+// PasswordChangeRequired reports whether the user has to change the password before
+// using the API, because it expired or was found in a breach list.
+//
+// Parameters:
+// - user: The user, with the mode loaded.
+//
+// Returns:
+// - bool: True if only a password change should be allowed.
+func (app *Application) PasswordChangeRequired(user *models.User) bool {
+	if user.PasswordRotationRequired {
+		return true
+	}
+	expiresAt, expires := app.PasswordExpiresAt(user)
+	return expires && !time.Now().Before(expiresAt)
+}
+
+// WarnExpiringPasswords emails every user whose password expires within the configured
+// warning period and who has not been warned about it yet.
+//
+// Returns:
+// - int: The number of warnings sent.
+// - error: An error if the users can not be loaded.
+func (app *Application) WarnExpiringPasswords() (int, error) {
+	if app.Config == nil || app.Config.Security.PasswordExpiry.WarnDays <= 0 {
+		return 0, nil
+	}
+	warnBefore := time.Duration(app.Config.Security.PasswordExpiry.WarnDays) * 24 * time.Hour
+
+	users, err := app.Repository.GetAllUsers()
+	if err != nil {
+		return 0, err
+	}
+
+	apiKey := os.Getenv("MAIL_SERVER_API_KEY")
+	domain := os.Getenv("MAIL_SERVER_DOMAIN")
+
+	now := time.Now()
+	sent := 0
+	for i := range users {
+		user := &users[i]
+		// Deleted, suspended and unapproved accounts can not change their password anyway
+		if app.AccountBlocked(user) != nil {
+			continue
+		}
+		expiresAt, expires := app.PasswordExpiresAt(user)
+		if !expires || now.Before(expiresAt.Add(-warnBefore)) || !now.Before(expiresAt) {
+			continue
+		}
+
+		// Only warn once per password
+		if user.PasswordExpiryWarnedAt != nil && user.PasswordExpiryWarnedAt.After(user.PasswordSetAt()) {
+			continue
+		}
+
+		data := PasswordExpiryData{
+			UserID:    user.ID,
+			Username:  user.UserName,
+			ExpiresAt: expiresAt.Format("January 2, 2006"),
+			DaysLeft:  int(expiresAt.Sub(now).Hours()/24) + 1,
+		}
+		subject := fmt.Sprintf("Your Password Expires Soon %s", user.UserName)
+		msg := fmt.Sprintf("The password of your account expires on %s, please change it before then", data.ExpiresAt)
+
+		_, err := controllers.SendTemplateEmail(domain, apiKey, user.Email, subject, msg, "passwordExpiry", data, 0)
+		if err != nil {
+			log.Printf("error sending password expiry warning to %s: %v", user.ID, err)
+			continue
+		}
+
+		if err := app.Repository.MarkPasswordExpiryWarned(user.ID, now); err != nil {
+			log.Printf("error marking password expiry warning of %s: %v", user.ID, err)
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// passwordExpiryJobTTL is how long a replica holds the password expiry warnings job.
+const passwordExpiryJobTTL = time.Hour
+
+// RunPasswordExpiryWarnings calls WarnExpiringPasswords on the configured interval
+// until the context is cancelled. Replicas sharing a Redis store take turns, so a
+// warning is never sent twice by replicas checking at the same time.
+//
+// Parameters:
+// - ctx: Stops the loop when cancelled.
+func (app *Application) RunPasswordExpiryWarnings(ctx context.Context) {
+	interval := app.Config.Security.PasswordExpiry.CheckInterval
+	if interval <= 0 {
+		interval = 12 * time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.runExclusive("password-expiry-warnings", passwordExpiryJobTTL, func() {
+			if sent, err := app.WarnExpiringPasswords(); err != nil {
+				log.Printf("error checking for expiring passwords: %v", err)
+			} else if sent > 0 {
+				log.Printf("sent %d password expiry warnings", sent)
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
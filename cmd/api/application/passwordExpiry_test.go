@@ -0,0 +1,88 @@
+package application_test
+
+import (
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/testenv"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that users are warned once when their password is about to expire
+func TestWarnExpiringPasswords(t *testing.T) {
+	env := testenv.New(t)
+	expiry := &env.App.Config.Security.PasswordExpiry
+	expiry.MaxAgeDays = map[string]int{"admin": 30}
+	expiry.WarnDays = 7
+
+	userID := env.Register(t, "arnold", "hold-on-to-your-butts")
+	env.Mail.WaitFor(t, 1)
+
+	// Not yet within the warning period
+	if sent, err := env.App.WarnExpiringPasswords(); err != nil || sent != 0 {
+		t.Fatalf("expected no warnings for a new password, got %d, error: %v", sent, err)
+	}
+
+	err := env.App.Repository.DB.Model(&models.User{}).Where("id = ?", userID).
+		Update("password_changed_at", time.Now().AddDate(0, 0, -25)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []int{1, 0} {
+		sent, err := env.App.WarnExpiringPasswords()
+		if err != nil || sent != expected {
+			t.Fatalf("sweep %d: expected %d warnings, got %d, error: %v", i, expected, sent, err)
+		}
+	}
+	warned := false
+	for _, email := range env.Mail.WaitFor(t, 2) {
+		warned = warned || (email.To == "arnold@jurassic.park" && strings.Contains(email.Subject, "Expires Soon"))
+	}
+	if !warned {
+		t.Error("expected a password expiry warning email")
+	}
+}
+
+// Test that blocked accounts are not warned and that only one replica runs the warnings
+func TestRunPasswordExpiryWarnings(t *testing.T) {
+	env := testenv.New(t)
+	expiry := &env.App.Config.Security.PasswordExpiry
+	expiry.MaxAgeDays = map[string]int{"admin": 30, "default": 30}
+	expiry.WarnDays = 7
+
+	adminID := env.Register(t, "arnold", "hold-on-to-your-butts")
+	userID := env.Register(t, "nedry", "ah-ah-ah-magic-word")
+	env.Mail.WaitFor(t, 2)
+	for _, id := range []string{adminID, userID} {
+		err := env.App.Repository.DB.Model(&models.User{}).Where("id = ?", id).
+			Update("password_changed_at", time.Now().AddDate(0, 0, -25)).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := env.App.Repository.SuspendUserByID(userID, models.UserStatusSuspended, "security", adminID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another replica is running the warnings
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if ok, err := env.App.Store.Claim(ctx, "job:password-expiry-warnings", "other-replica", time.Minute); err != nil || !ok {
+		t.Fatalf("expected to claim the job, got %v, %v", ok, err)
+	}
+	env.App.RunPasswordExpiryWarnings(ctx)
+	if sent := env.Mail.Sent(); len(sent) != 2 {
+		t.Fatalf("expected no warnings while another replica runs the job, got %d emails", len(sent))
+	}
+
+	if _, err := env.App.Store.TakeCode(ctx, "job:password-expiry-warnings"); err != nil {
+		t.Fatal(err)
+	}
+	env.App.RunPasswordExpiryWarnings(ctx)
+	sent := env.Mail.WaitFor(t, 3)
+	time.Sleep(100 * time.Millisecond)
+	if sent = env.Mail.Sent(); len(sent) != 3 || sent[2].To != "arnold@jurassic.park" || !strings.Contains(sent[2].Subject, "Expires Soon") {
+		t.Errorf("expected a single warning to the active account, got %+v", sent)
+	}
+}
@@ -0,0 +1,104 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordResetData is passed to the password reset template.
+type PasswordResetData struct {
+	UserID    string
+	Username  string
+	ResetURL  string
+	ExpiresIn string
+}
+
+// PasswordResetTTL returns how long an emailed password reset token is valid.
+//
+// Returns:
+// - time.Duration: The configured lifetime, 1 hour if none is set.
+func (app *Application) PasswordResetTTL() time.Duration {
+	if app.Config == nil || app.Config.Security.PasswordReset.TokenTTL <= 0 {
+		return time.Hour
+	}
+	return app.Config.Security.PasswordReset.TokenTTL
+}
+
+// PasswordResetURL returns the link sent in the password reset email.
+//
+// Parameters:
+// - token: The raw reset token.
+//
+// Returns:
+// - string: The configured reset page with the token as query parameter.
+func (app *Application) PasswordResetURL(token string) string {
+	base := "http://localhost:3000/admin/new-password"
+	if app.Config != nil && app.Config.Security.PasswordReset.URL != "" {
+		base = app.Config.Security.PasswordReset.URL
+	}
+	return linkWithToken(base, token)
+}
+
+// SendPasswordResetLink stores a new single-use reset token for the user and emails
+// the link carrying it. Only the hash of the token is stored.
+//
+// Parameters:
+// - user: The user who asked for the reset.
+//
+// Returns:
+// - error: An error if the token can not be created or the email can not be sent.
+func (app *Application) SendPasswordResetLink(user *models.User) error {
+	token, tokenHash, err := controllers.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := app.PasswordResetTTL()
+	now := time.Now()
+	passwordToken := models.PasswordRestToken{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiredAt: now.Add(ttl).Unix(),
+		CreatedAt: now,
+		TokenUsed: false,
+	}
+	if _, err := app.Repository.InsertPasswordToken(&passwordToken); err != nil {
+		return err
+	}
+
+	data := PasswordResetData{
+		UserID:    user.ID,
+		Username:  user.UserName,
+		ResetURL:  app.PasswordResetURL(token),
+		ExpiresIn: fmt.Sprintf("%d minutes", int(ttl.Minutes())),
+	}
+	subject := fmt.Sprintf("Password Reset for %s", user.UserName)
+	msg := fmt.Sprintf("You have requested to reset your password, in order to continue please open the following link: %s", data.ResetURL)
+
+	apiKey := os.Getenv("MAIL_SERVER_API_KEY")
+	domain := os.Getenv("MAIL_SERVER_DOMAIN")
+
+	_, err = controllers.SendTemplateEmail(domain, apiKey, user.Email, subject, msg, "resetPassword", data, 0)
+	return err
+}
+
+// linkWithToken appends a token to a link as the token query parameter.
+func linkWithToken(base, token string) string {
+	return linkWithParam(base, "token", token)
+}
+
+// linkWithParam appends a query parameter to a link.
+func linkWithParam(base, name, value string) string {
+	separator := "?"
+	if u, err := url.Parse(base); err == nil && u.RawQuery != "" {
+		separator = "&"
+	}
+	return base + separator + name + "=" + url.QueryEscape(value)
+}
@@ -0,0 +1,108 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/utils"
+	"TriceraPass/internal/ratelimit"
+	"TriceraPass/pkg/verifier"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientIP returns the IP address of the client that sent the request. Behind one of
+// the api.rate_limiting.trusted_proxies the address is taken from X-Forwarded-For.
+//
+// Parameters:
+// - r: The request.
+//
+// Returns:
+// - string: The IP address without port.
+func (app *Application) ClientIP(r *http.Request) string {
+	var trusted []string
+	if app.Config != nil {
+		trusted = app.Config.API.RateLimit.TrustedProxies
+	}
+	return ratelimit.ClientIP(r, trusted)
+}
+
+// RateLimit is a middleware that limits the requests of a route group as configured
+// under api.rate_limiting in settings.yml. The empty group is the default limit of every
+// route, named groups such as login are looked up in api.rate_limiting.routes and apply
+// on top of the default. Rejected requests are answered with 429 and a Retry-After header,
+// all requests get the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+//
+// Parameters:
+// - group: The route group, empty for the default limit.
+//
+// Returns:
+// - func(http.Handler) http.Handler: The rate limiting middleware.
+func (app *Application) RateLimit(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rule, ok := app.rateLimitRule(group)
+			if !ok || app.Store == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := "ratelimit:" + group + ":" + app.rateLimitKey(r, rule.KeyBy)
+			result, err := app.Store.Take(r.Context(), key, rule, time.Now())
+			if err != nil {
+				// Rather serve than fail when the store is down
+				log.Printf("error taking a rate limit token for %s: %v", key, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+				utils.ErrorJSON(w, errors.New("too many requests, try again later"), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitRule returns the configured limit of the route group.
+func (app *Application) rateLimitRule(group string) (ratelimit.Rule, bool) {
+	if app.Config == nil {
+		return ratelimit.Rule{}, false
+	}
+
+	config := app.Config.API.RateLimit
+	rule := config.Default()
+	if group != "" {
+		rule = config.Routes[group]
+	}
+	return rule, rule.Enabled()
+}
+
+// rateLimitKey tells the clients of a rule apart.
+func (app *Application) rateLimitKey(r *http.Request, keyBy string) string {
+	switch keyBy {
+	case ratelimit.KeyByRoute:
+		return "all"
+	case ratelimit.KeyByUser:
+		header := r.Header.Get("Authorization")
+		if token := strings.TrimPrefix(header, "Bearer "); token != header {
+			if claims, err := app.Auth.VerifyToken(token, verifier.RestrictionPasswordChange); err == nil {
+				return "user:" + claims.Subject
+			}
+		}
+	}
+	return "ip:" + app.ClientIP(r)
+}
+
+// ceilSeconds formats a duration as whole seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
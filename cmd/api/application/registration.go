@@ -0,0 +1,247 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/repositories"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Registration modes.
+const (
+	RegistrationOpen   = "open"   // Anyone can register
+	RegistrationClosed = "closed" // Nobody can register
+	RegistrationInvite = "invite" // Only people with an invite code can register
+	RegistrationDomain = "domain" // Only email addresses of the allowed domains can register
+)
+
+// ErrRegistrationClosed is returned when registration is closed.
+var ErrRegistrationClosed = errors.New("registration is closed")
+
+// ErrInviteRequired is returned when registering without an invite code while registration is invite-only.
+var ErrInviteRequired = errors.New("registration requires an invite")
+
+// ErrEmailDomainNotAllowed is returned when the email domain may not register.
+var ErrEmailDomainNotAllowed = errors.New("email addresses of this domain can not register")
+
+// InvitationData is passed to the invitation template.
+type InvitationData struct {
+	Code      string
+	InviteURL string
+	ExpiresIn string
+}
+
+// RegistrationMode returns the configured registration mode.
+//
+// Returns:
+// - string: One of the Registration mode constants, open if none is set.
+func (app *Application) RegistrationMode() string {
+	if app.Config == nil || app.Config.Security.Registration.Mode == "" {
+		return RegistrationOpen
+	}
+	return app.Config.Security.Registration.Mode
+}
+
+// AdmitRegistration checks whether the email address may register under the
+// registration mode and uses up the invite if a code is given. The first user can
+// always register, so a new installation gets its admin. Invites work in every mode
+// except closed, in the domain mode they admit addresses outside the allowed domains.
+//
+// Parameters:
+// - email: The email address of the new user.
+// - inviteCode: The invite code of the registration, may be empty.
+//
+// Returns:
+// - *models.Invite: The used invite, nil if no code was given.
+// - error: ErrRegistrationClosed, ErrInviteRequired, repositories.ErrInvalidInvite or
+// ErrEmailDomainNotAllowed if the registration is rejected.
+func (app *Application) AdmitRegistration(email, inviteCode string) (*models.Invite, error) {
+	count, err := app.Repository.CountUsers()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	mode := app.RegistrationMode()
+	if mode == RegistrationClosed {
+		return nil, ErrRegistrationClosed
+	}
+
+	if inviteCode != "" {
+		invite, err := app.Repository.GetInviteByCodeHash(controllers.HashToken(inviteCode))
+		if err != nil || !invite.Usable() {
+			return nil, repositories.ErrInvalidInvite
+		}
+		if invite.Email != "" && !strings.EqualFold(invite.Email, strings.TrimSpace(email)) {
+			return nil, repositories.ErrInvalidInvite
+		}
+		if err := app.Repository.UseInvite(invite.ID); err != nil {
+			return nil, err
+		}
+		return invite, nil
+	}
+
+	switch mode {
+	case RegistrationInvite:
+		return nil, ErrInviteRequired
+	case RegistrationDomain:
+		if !app.emailDomainAllowed(email) {
+			return nil, ErrEmailDomainNotAllowed
+		}
+	}
+	return nil, nil
+}
+
+// ReleaseInvite gives back the use of an invite when the registration failed after
+// AdmitRegistration.
+//
+// Parameters:
+// - invite: The invite returned by AdmitRegistration, may be nil.
+func (app *Application) ReleaseInvite(invite *models.Invite) {
+	if invite == nil {
+		return
+	}
+	if err := app.Repository.ReleaseInvite(invite.ID); err != nil {
+		log.Printf("error releasing invite %s: %v", invite.ID, err)
+	}
+}
+
+// CreateInvite creates an invite and, if it is for an email address, emails the
+// invitation. The code is only returned here, the invite keeps a hash of it.
+//
+// Parameters:
+// - actorID: The ID of the admin creating the invite.
+// - email: The only address that can use the invite, any address if empty.
+// - modeName: The mode of the users registering with it, default if empty.
+// - maxUses: How many users can register with it, 0 for no limit.
+// - expiresAt: When the invite expires, nil for the configured invite lifetime.
+//
+// Returns:
+// - *models.Invite: The stored invite.
+// - string: The invite code.
+// - error: ErrInvalidEmail for a malformed address, or an error if the invite can not be
+// stored or the invitation can not be sent.
+func (app *Application) CreateInvite(actorID, email, modeName string, maxUses int, expiresAt *time.Time) (*models.Invite, string, error) {
+	email = strings.TrimSpace(email)
+	if email != "" {
+		if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+			return nil, "", ErrInvalidEmail
+		}
+	}
+	if maxUses < 0 {
+		maxUses = 0
+	}
+
+	code, codeHash, err := controllers.GenerateToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	invite := models.Invite{
+		ID:        uuid.NewString(),
+		CodeHash:  codeHash,
+		Email:     email,
+		ModeName:  strings.TrimSpace(modeName),
+		MaxUses:   maxUses,
+		CreatedBy: actorID,
+		CreatedAt: time.Now(),
+	}
+	if expiresAt != nil {
+		invite.ExpiredAt = expiresAt.Unix()
+	} else if app.Config != nil && app.Config.Security.Registration.InviteTTL > 0 {
+		invite.ExpiredAt = invite.CreatedAt.Add(app.Config.Security.Registration.InviteTTL).Unix()
+	}
+	if _, err := app.Repository.InsertInvite(&invite); err != nil {
+		return nil, "", err
+	}
+
+	if email != "" {
+		if err := app.sendInvitation(&invite, code); err != nil {
+			return &invite, code, err
+		}
+	}
+	return &invite, code, nil
+}
+
+// InviteURL returns the sign-up link for an invite code. The sign-up page belongs to the
+// client application, the API only offers the POST registration route.
+//
+// Parameters:
+// - code: The invite code.
+//
+// Returns:
+// - string: The sign-up page with the code as the invite query parameter, empty if no
+// invite_url is configured.
+func (app *Application) InviteURL(code string) string {
+	if app.Config == nil || app.Config.Security.Registration.InviteURL == "" {
+		return ""
+	}
+	return linkWithParam(app.Config.Security.Registration.InviteURL, "invite", code)
+}
+
+// sendInvitation emails the invite code to the invited address.
+func (app *Application) sendInvitation(invite *models.Invite, code string) error {
+	data := InvitationData{
+		Code:      code,
+		InviteURL: app.InviteURL(code),
+	}
+	if invite.ExpiredAt > 0 {
+		data.ExpiresIn = humanDuration(time.Until(time.Unix(invite.ExpiredAt, 0)))
+	}
+
+	name := "TriceraPass"
+	if app.Config != nil && app.Config.Application.ClientName != "" {
+		name = app.Config.Application.ClientName
+	}
+	subject := fmt.Sprintf("You Are Invited to %s", name)
+	msg := fmt.Sprintf("You are invited to create an account. Sign up with the following link: %s", data.InviteURL)
+	if data.InviteURL == "" {
+		msg = fmt.Sprintf("You are invited to create an account. Enter the invite code %s when you register.", code)
+	}
+
+	apiKey := os.Getenv("MAIL_SERVER_API_KEY")
+	domain := os.Getenv("MAIL_SERVER_DOMAIN")
+	_, err := controllers.SendTemplateEmail(domain, apiKey, invite.Email, subject, msg, "invitation", data, 0)
+	return err
+}
+
+// emailDomainAllowed checks the domain of an email address against the allow and deny lists.
+func (app *Application) emailDomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+
+	config := app.Config.Security.Registration
+	for _, denied := range config.DeniedDomains {
+		if domainMatches(domain, denied) {
+			return false
+		}
+	}
+	if len(config.AllowedDomains) == 0 {
+		return true
+	}
+	for _, allowed := range config.AllowedDomains {
+		if domainMatches(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainMatches reports whether the domain is the listed domain or one of its subdomains.
+func domainMatches(domain, listed string) bool {
+	listed = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(listed), "@"))
+	return listed != "" && (domain == listed || strings.HasSuffix(domain, "."+listed))
+}
@@ -0,0 +1,105 @@
+package application
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/repositories"
+	"TriceraPass/internal/userimport"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImportUsers creates the users of an export with their existing password hashes.
+// Imported users get the default mode and are confirmed if the export marks their
+// email as verified. Records that can not be imported are listed in the report.
+//
+// Parameters:
+// - records: The users read by userimport.Parse.
+// - dryRun: Only validate the records, nothing is written.
+//
+// Returns:
+// - userimport.Report: The number of imported users and the skipped records.
+func (app *Application) ImportUsers(records []userimport.Record, dryRun bool) userimport.Report {
+	report := userimport.Report{DryRun: dryRun, Total: len(records), Failed: []userimport.Failure{}}
+	seen := map[string]bool{}
+	seenNames := map[string]bool{}
+
+	for i := range records {
+		record := &records[i]
+		fail := func(reason string) {
+			report.Failed = append(report.Failed, userimport.Failure{Row: i + 1, Email: record.Email, Reason: reason})
+		}
+
+		if err := record.Validate(); err != nil {
+			fail(err.Error())
+			continue
+		}
+
+		email := models.NormalizeEmail(record.Email)
+		if seen[email] {
+			fail("duplicate email in the export")
+			continue
+		}
+		seen[email] = true
+
+		// Usernames are unique regardless of their case, users without one do not collide
+		name := strings.ToLower(models.NormalizeUserName(record.UserName))
+		if name != "" && seenNames[name] {
+			fail("duplicate username in the export")
+			continue
+		}
+		seenNames[name] = true
+
+		if dryRun {
+			if _, err := app.Repository.GetUserByEmail(record.Email); err == nil {
+				fail(repositories.ErrUserExists.Error())
+				continue
+			}
+			taken, err := app.Repository.UserNameTaken(record.UserName)
+			if err != nil {
+				fail(err.Error())
+				continue
+			}
+			if taken {
+				fail(repositories.ErrUserNameTaken.Error())
+				continue
+			}
+			report.Imported++
+			continue
+		}
+
+		now := time.Now()
+		user := models.User{
+			ID:                uuid.NewString(),
+			CreatedAt:         now,
+			UserName:          record.UserName,
+			FirstName:         record.FirstName,
+			LastName:          record.LastName,
+			Email:             record.Email,
+			Password:          record.PasswordHash,
+			PasswordChangedAt: now,
+		}
+		if err := app.Repository.ImportUser(&user, "default", record.EmailVerified); err != nil {
+			if !errors.Is(err, repositories.ErrUserExists) {
+				log.Printf("error importing user %s: %v", record.Email, err)
+			}
+			fail(err.Error())
+			continue
+		}
+		report.Imported++
+
+		// Give the user a profile image like a registration does
+		filename, profilePath, err := controllers.UploadDefaultProfile(app.Root, user.ID)
+		if err == nil {
+			_, err = app.Repository.InsertProfileImage(&models.ProfileImage{Filename: filename, FilePath: profilePath, UserID: user.ID})
+		}
+		if err != nil {
+			log.Printf("error adding the profile image of imported user %s: %v", user.ID, err)
+		}
+	}
+	return report
+}
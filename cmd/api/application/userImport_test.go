@@ -0,0 +1,38 @@
+package application_test
+
+import (
+	"TriceraPass/internal/repositories"
+	"TriceraPass/internal/testenv"
+	"TriceraPass/internal/userimport"
+	"testing"
+)
+
+// Test that a dry run reports the same username collisions as the import
+func TestImportUsersUserNames(t *testing.T) {
+	env := testenv.New(t)
+	env.Register(t, "hammond", "spared-no-expense")
+
+	records := func() []userimport.Record {
+		return []userimport.Record{
+			{Email: "john@ingen.com", UserName: "HAMMOND"},
+			{Email: "henry@ingen.com", UserName: "wu"},
+			{Email: "wu@ingen.com", UserName: " Wu "},
+			{Email: "lex@ingen.com"},
+			{Email: "tim@ingen.com"},
+		}
+	}
+	expected := map[int]string{1: repositories.ErrUserNameTaken.Error(), 3: "duplicate username in the export"}
+
+	for _, dryRun := range []bool{true, false} {
+		report := env.App.ImportUsers(records(), dryRun)
+		if report.Imported != 3 || len(report.Failed) != len(expected) {
+			t.Errorf("dry run %v: expected 3 imported users and 2 failures, got %+v", dryRun, report)
+			continue
+		}
+		for _, failure := range report.Failed {
+			if expected[failure.Row] != failure.Reason {
+				t.Errorf("dry run %v: row %d: expected %q, got %q", dryRun, failure.Row, expected[failure.Row], failure.Reason)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package auth
 
 import (
+	"TriceraPass/pkg/verifier"
 	"errors"
 	"fmt"
 	"net/http"
@@ -22,26 +23,36 @@ type Auth struct {
 	CookieDomain  string        // Domain for setting the refresh token cookie.
 	CookieName    string        // Name of the refresh token cookie.
 	CookiePath    string        // Path for setting the refresh token cookie.
+	// AccessCookieName is the name of the access token cookie that authenticates browser
+	// navigations at the forward-auth endpoint and the embedded proxy.
+	AccessCookieName string
 }
 
 // JwtUser represents a user and their associated JWT claims.
 type JwtUser struct {
-	ID        string `json:"id"`         // User ID.
-	FirstName string `json:"first_name"` // User's first name.
-	UserName  string `json:"username"`   // User's username.
-	LastName  string `json:"last_name"`  // User's last name.
+	ID        string   `json:"id"`         // User ID.
+	FirstName string   `json:"first_name"` // User's first name.
+	UserName  string   `json:"username"`   // User's username.
+	LastName  string   `json:"last_name"`  // User's last name.
+	Mode      string   `json:"mode"`       // Name of the user's mode (e.g. "admin").
+	Scopes    []string `json:"scopes"`     // Scopes granted to the user through their mode.
+	// Restriction limits the tokens to a single purpose, e.g. verifier.RestrictionPasswordChange.
+	Restriction string `json:"restriction,omitempty"`
+	// EmailVerified tells services whether the user confirmed the email address.
+	EmailVerified bool `json:"email_verified"`
 }
 
 // TokenPairs represents the access and refresh tokens.
 type TokenPairs struct {
 	Token        string `json:"access_token"`  // JWT access token.
 	RefreshToken string `json:"refresh_token"` // JWT refresh token.
+	// PasswordRotationRequired tells the client to make the user choose a new password.
+	PasswordRotationRequired bool `json:"password_rotation_required,omitempty"`
 }
 
-// Claims represents the JWT claims for the user.
-type Claims struct {
-	jwt.RegisteredClaims // Standard JWT registered claims (e.g., iat, exp, etc.).
-}
+// Claims represents the JWT claims for the user. It is shared with the public
+// verifier package so downstream services decode tokens into the same type.
+type Claims = verifier.Claims
 
 // generateTokenID generates a new unique token ID.
 //
@@ -72,8 +83,18 @@ func (j *Auth) GenerateTokenPair(user *JwtUser) (TokenPairs, error) {
 	claims["aud"] = j.Audience
 	claims["iss"] = j.Issuer
 	claims["iat"] = time.Now().UTC().Unix()
-	claims["typ"] = "JWT"
+	claims["typ"] = verifier.TokenTypeAccess
 	claims["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()
+	claims["email_verified"] = user.EmailVerified
+	if user.Mode != "" {
+		claims["mode"] = user.Mode
+	}
+	if len(user.Scopes) > 0 {
+		claims["scope"] = strings.Join(user.Scopes, " ")
+	}
+	if user.Restriction != "" {
+		claims["restriction"] = user.Restriction
+	}
 
 	// Create a signed access token
 	signedAccessToken, err := token.SignedString([]byte(j.Secret))
@@ -86,8 +107,13 @@ func (j *Auth) GenerateTokenPair(user *JwtUser) (TokenPairs, error) {
 	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
 	refreshTokenClaims["jti"] = tokenID
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
+	refreshTokenClaims["iss"] = j.Issuer
+	refreshTokenClaims["typ"] = verifier.TokenTypeRefresh
 	refreshTokenClaims["iat"] = time.Now().UTC().Unix()
 	refreshTokenClaims["exp"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
+	if user.Restriction != "" {
+		refreshTokenClaims["restriction"] = user.Restriction
+	}
 
 	// Create signed refresh token
 	signedRefreshToken, err := refreshToken.SignedString([]byte(j.Secret))
@@ -141,6 +167,79 @@ func (j *Auth) GetExpiredRefreshCookie() *http.Cookie {
 	}
 }
 
+// GetAccessCookie returns an HTTP cookie for the access token. Browsers can not send an
+// Authorization header when they navigate, so the forward-auth endpoint and the embedded
+// proxy read the access token from this cookie. It uses SameSite Lax, unlike the refresh
+// cookie, so links from other sites into proxied applications keep the user logged in.
+//
+// Parameters:
+// - accessToken: The access token to set in the cookie.
+//
+// Returns:
+// - *http.Cookie: A pointer to the HTTP cookie containing the access token.
+func (j *Auth) GetAccessCookie(accessToken string) *http.Cookie {
+	return &http.Cookie{
+		Name:     j.AccessCookieName,
+		Path:     j.CookiePath,
+		Value:    accessToken,
+		Expires:  time.Now().Add(j.TokenExpiry),
+		MaxAge:   int(j.TokenExpiry.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+		Domain:   j.CookieDomain,
+		HttpOnly: true,
+		Secure:   true,
+	}
+}
+
+// GetExpiredAccessCookie returns an access token cookie that is immediately expired.
+//
+// Returns:
+// - *http.Cookie: A pointer to the expired HTTP cookie.
+func (j *Auth) GetExpiredAccessCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     j.AccessCookieName,
+		Path:     j.CookiePath,
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
+		Domain:   j.CookieDomain,
+		HttpOnly: true,
+		Secure:   true,
+	}
+}
+
+// GetTokenFromRequestAndVerify verifies the access token from the Authorization header,
+// falling back to the access token cookie when no header is sent. The cookie goes
+// through the same typed verification, so a refresh token in it is rejected.
+//
+// Parameters:
+// - w: The HTTP response writer to modify headers.
+// - r: The HTTP request carrying the token.
+//
+// Returns:
+// - string: The token if valid.
+// - *Claims: A pointer to the Claims struct containing the token claims.
+// - error: An error if no token is present or the token is invalid or expired.
+func (j *Auth) GetTokenFromRequestAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
+	if r.Header.Get("Authorization") != "" || j.AccessCookieName == "" {
+		return j.GetTokenFromHeaderAndVerify(w, r)
+	}
+
+	w.Header().Add("Vary", "Authorization")
+	w.Header().Add("Vary", "Cookie")
+	cookie, err := r.Cookie(j.AccessCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", nil, errors.New("no auth header or cookie")
+	}
+
+	claims, err := j.VerifyToken(cookie.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	return cookie.Value, claims, nil
+}
+
 // GetTokenFromHeaderAndVerify retrieves a token from the Authorization header and verifies its validity.
 // It checks for proper token structure, signature, and claims, such as issuer and expiration.
 //
@@ -153,6 +252,22 @@ func (j *Auth) GetExpiredRefreshCookie() *http.Cookie {
 // - *Claims: A pointer to the Claims struct containing the token claims.
 // - error: An error if the token is invalid or expired.
 func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
+	return j.GetTokenFromHeaderAndVerifyAllowing(w, r)
+}
+
+// GetTokenFromHeaderAndVerifyAllowing works like GetTokenFromHeaderAndVerify but also
+// accepts restricted tokens with one of the given restrictions.
+//
+// Parameters:
+// - w: The HTTP response writer to modify headers.
+// - r: The HTTP request containing the Authorization header.
+// - restrictions: The token restrictions that are accepted.
+//
+// Returns:
+// - string: The token if valid.
+// - *Claims: A pointer to the Claims struct containing the token claims.
+// - error: An error if the token is invalid, expired or restricted otherwise.
+func (j *Auth) GetTokenFromHeaderAndVerifyAllowing(w http.ResponseWriter, r *http.Request, restrictions ...string) (string, *Claims, error) {
 	w.Header().Add("Vary", "Authorization")
 
 	// get the auth header
@@ -175,27 +290,52 @@ func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 
 	token := headerParts[1]
 
-	// declare an empty claims
-	claims := &Claims{}
-
-	// parse the token
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(j.Secret), nil
-	})
-
+	claims, err := j.VerifyToken(token, restrictions...)
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "token is expired by") {
-			return "", nil, errors.New("expired token")
-		}
 		return "", nil, err
 	}
 
-	if claims.Issuer != j.Issuer {
-		return "", nil, errors.New("invalid issuer")
+	return token, claims, nil
+}
+
+// VerifyToken parses a signed access token and checks its signature, expiry and issuer.
+// Restricted tokens are rejected unless their restriction is passed in, refresh tokens
+// are always rejected.
+//
+// Parameters:
+// - token: The signed JWT string.
+// - restrictions: The token restrictions that are accepted.
+//
+// Returns:
+// - *Claims: A pointer to the Claims struct containing the token claims.
+// - error: An error if the token is invalid, expired or restricted.
+func (j *Auth) VerifyToken(token string, restrictions ...string) (*Claims, error) {
+	v, err := verifier.New(verifier.Config{Secret: j.Secret, Issuer: j.Issuer, AllowedRestrictions: restrictions})
+	if err != nil {
+		return nil, err
 	}
+	return v.Verify(token)
+}
 
-	return token, claims, nil
+// VerifyRefreshToken parses a signed refresh token and checks its signature, expiry, issuer
+// and type. Restricted refresh tokens are accepted, the renewed tokens get the restriction
+// the user needs at that time.
+//
+// Parameters:
+// - token: The signed JWT string from the refresh cookie.
+//
+// Returns:
+// - *Claims: A pointer to the Claims struct containing the token claims.
+// - error: An error if the token is invalid, expired or not a refresh token.
+func (j *Auth) VerifyRefreshToken(token string) (*Claims, error) {
+	v, err := verifier.New(verifier.Config{
+		Secret:              j.Secret,
+		Issuer:              j.Issuer,
+		TokenType:           verifier.TokenTypeRefresh,
+		AllowedRestrictions: []string{verifier.RestrictionPasswordChange},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.Verify(token)
 }
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"TriceraPass/pkg/verifier"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testAuth() Auth {
+	return Auth{
+		Issuer:        "test-issuer",
+		Audience:      "test-audience",
+		Secret:        "test-secret",
+		TokenExpiry:   time.Minute,
+		RefreshExpiry: time.Hour,
+		CookieName:    "refresh_token",
+		CookiePath:    "/",
+	}
+}
+
+// Test that the mode and scopes of a user end up in the verified claims
+func TestGenerateTokenPairClaims(t *testing.T) {
+	a := testAuth()
+	tokens, err := a.GenerateTokenPair(&JwtUser{ID: "user-1", Mode: "admin", Scopes: []string{"users:read", "users:write"}})
+	if err != nil {
+		t.Fatalf("Error generating tokens: %v", err)
+	}
+
+	claims, err := a.VerifyToken(tokens.Token)
+	if err != nil {
+		t.Fatalf("expected access token to verify, got %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Mode != "admin" {
+		t.Errorf("unexpected claims: subject %q, mode %q", claims.Subject, claims.Mode)
+	}
+	if !claims.HasScope("users:write") || claims.HasScope("profile") {
+		t.Errorf("unexpected scopes: %v", claims.Scopes())
+	}
+
+	// The refresh token is only good for renewing the session
+	if _, err := a.VerifyToken(tokens.RefreshToken); !errors.Is(err, verifier.ErrWrongTokenType) {
+		t.Errorf("expected the refresh token to be rejected as an access token, got %v", err)
+	}
+	if claims, err := a.VerifyRefreshToken(tokens.RefreshToken); err != nil || claims.Subject != "user-1" {
+		t.Errorf("expected the refresh token to verify as one, got claims: %v, error: %v", claims, err)
+	}
+	if _, err := a.VerifyRefreshToken(tokens.Token); !errors.Is(err, verifier.ErrWrongTokenType) {
+		t.Errorf("expected the access token to be rejected as a refresh token, got %v", err)
+	}
+}
+
+// Test that only the Authorization header authenticates, never the refresh cookie
+func TestGetTokenFromHeaderAndVerify(t *testing.T) {
+	a := testAuth()
+	tokens, err := a.GenerateTokenPair(&JwtUser{ID: "user-1"})
+	if err != nil {
+		t.Fatalf("Error generating tokens: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/auth/api/verify", nil)
+	r.AddCookie(a.GetRefreshCookie(tokens.RefreshToken))
+	if _, _, err := a.GetTokenFromHeaderAndVerify(httptest.NewRecorder(), r); err == nil {
+		t.Errorf("expected the refresh cookie to be ignored")
+	}
+
+	r.Header.Set("Authorization", "Bearer "+tokens.RefreshToken)
+	if _, _, err := a.GetTokenFromHeaderAndVerify(httptest.NewRecorder(), r); err == nil {
+		t.Errorf("expected a refresh token in the header to be rejected")
+	}
+
+	r.Header.Set("Authorization", "Bearer "+tokens.Token)
+	if _, claims, err := a.GetTokenFromHeaderAndVerify(httptest.NewRecorder(), r); err != nil || claims.Subject != "user-1" {
+		t.Errorf("expected header token to verify, got claims: %v, error: %v", claims, err)
+	}
+
+	r.Header.Set("Authorization", "Bearer invalid")
+	if _, _, err := a.GetTokenFromHeaderAndVerify(httptest.NewRecorder(), r); err == nil {
+		t.Errorf("expected invalid header token to be rejected")
+	}
+
+	other := testAuth()
+	other.Issuer = "someone-else"
+	if _, err := other.VerifyToken(tokens.Token); err == nil {
+		t.Errorf("expected token with a foreign issuer to be rejected")
+	}
+}
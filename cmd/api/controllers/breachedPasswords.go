@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BreachChecker reports whether a password appears in a list of breached passwords.
+type BreachChecker interface {
+	Breached(password string) (bool, error)
+}
+
+// BreachedPasswords configures the offline breached password screening.
+// It is configured under security.breached_passwords in settings.yml.
+type BreachedPasswords struct {
+	Enabled      bool   `yaml:"enabled"`        // Screen new passwords against the breach list
+	Format       string `yaml:"format"`         // "hashes" for a HIBP SHA-1 file, "bloom" for a filter built with cmd/breachfilter
+	Path         string `yaml:"path"`           // Location of the breach list
+	MinCount     int    `yaml:"min_count"`      // Reject a password seen at least this many times (hashes format only)
+	CheckOnLogin bool   `yaml:"check_on_login"` // Flag accounts whose current password is breached on login
+}
+
+// NewBreachChecker opens the breach list described by the configuration.
+//
+// Parameters:
+// - config: The breached password settings.
+//
+// Returns:
+// - BreachChecker: The checker for the configured format.
+// - error: An error if the file can not be opened or the format is unknown.
+func NewBreachChecker(config BreachedPasswords) (BreachChecker, error) {
+	switch config.Format {
+	case "", "hashes":
+		return OpenHashListChecker(config.Path, config.MinCount)
+	case "bloom":
+		return LoadBloomChecker(config.Path)
+	default:
+		return nil, fmt.Errorf("unknown breached password format %q", config.Format)
+	}
+}
+
+// HashListChecker searches a Have I Been Pwned style file of "SHA1:COUNT" lines
+// ordered by hash (pwned-passwords-sha1-ordered-by-hash). The file is binary
+// searched on disk, so it is never loaded into memory.
+type HashListChecker struct {
+	file     *os.File
+	size     int64
+	minCount int
+}
+
+// OpenHashListChecker opens an ordered SHA-1 hash file.
+//
+// Parameters:
+// - path: The location of the file.
+// - minCount: The breach count from which a password is rejected, at least 1.
+//
+// Returns:
+// - *HashListChecker: The checker.
+// - error: An error if the file can not be opened.
+func OpenHashListChecker(path string, minCount int) (*HashListChecker, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breached password file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if minCount < 1 {
+		minCount = 1
+	}
+	return &HashListChecker{file: file, size: info.Size(), minCount: minCount}, nil
+}
+
+// Breached reports whether the password was seen in at least minCount breaches.
+func (c *HashListChecker) Breached(password string) (bool, error) {
+	count, err := c.Count(password)
+	if err != nil {
+		return false, err
+	}
+	return count >= c.minCount, nil
+}
+
+// Count returns how often the password was seen in breaches, 0 if it is not in the file.
+//
+// Parameters:
+// - password: The plain-text password.
+//
+// Returns:
+// - int: The breach count.
+// - error: An error if the file can not be read.
+func (c *HashListChecker) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	target := []byte(strings.ToUpper(hex.EncodeToString(sum[:])))
+
+	// Binary search over byte offsets, aligning each probe to the next full line
+	low, high := int64(0), c.size
+	for low < high {
+		mid := low + (high-low)/2
+		start, line, err := c.lineAfter(mid)
+		if err != nil {
+			return 0, err
+		}
+		if line == nil {
+			high = mid
+			continue
+		}
+
+		switch cmp := bytes.Compare(lineHash(line), target); {
+		case cmp == 0:
+			return lineCount(line), nil
+		case cmp < 0:
+			low = start + int64(len(line)) + 1
+		default:
+			high = mid
+		}
+	}
+
+	// The first line is never reached by lineAfter for offsets above zero
+	_, line, err := c.lineAt(0)
+	if err != nil || line == nil {
+		return 0, err
+	}
+	if bytes.Equal(lineHash(line), target) {
+		return lineCount(line), nil
+	}
+	return 0, nil
+}
+
+// Close closes the underlying file.
+func (c *HashListChecker) Close() error {
+	return c.file.Close()
+}
+
+// lineAfter returns the first complete line starting after the given offset.
+func (c *HashListChecker) lineAfter(offset int64) (int64, []byte, error) {
+	if offset == 0 {
+		return c.lineAt(0)
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(c.file, offset-1, c.size-offset+1))
+	skipped, err := reader.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF {
+			return 0, nil, nil
+		}
+		return 0, nil, err
+	}
+	return c.lineAt(offset - 1 + int64(len(skipped)))
+}
+
+// lineAt reads the line starting at the given offset.
+func (c *HashListChecker) lineAt(offset int64) (int64, []byte, error) {
+	if offset >= c.size {
+		return offset, nil, nil
+	}
+	reader := bufio.NewReader(io.NewSectionReader(c.file, offset, c.size-offset))
+	line, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return offset, nil, nil
+	}
+	return offset, line, nil
+}
+
+// lineHash returns the upper case hash part of a "SHA1:COUNT" line.
+func lineHash(line []byte) []byte {
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return bytes.ToUpper(line)
+}
+
+// lineCount returns the count part of a "SHA1:COUNT" line, 1 if it has none.
+func lineCount(line []byte) int {
+	i := bytes.IndexByte(line, ':')
+	if i < 0 {
+		return 1
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(line[i+1:])))
+	if err != nil {
+		return 1
+	}
+	return count
+}
+
+// bloomMagic identifies a bloom filter file written by BloomFilter.WriteTo.
+var bloomMagic = [4]byte{'T', 'P', 'B', 'F'}
+
+// BloomFilter is a compact, probabilistic set of SHA-1 password hashes. It never
+// misses a breached password but reports a small share of other passwords as breached.
+type BloomFilter struct {
+	bits   []uint64
+	m      uint64
+	hashes uint32
+}
+
+// NewBloomFilter sizes a filter for the expected number of hashes and false positive rate.
+//
+// Parameters:
+// - expected: The number of hashes that will be added.
+// - falsePositiveRate: The accepted share of false positives, e.g. 0.001.
+//
+// Returns:
+// - *BloomFilter: The empty filter.
+func NewBloomFilter(expected uint64, falsePositiveRate float64) *BloomFilter {
+	if expected == 0 {
+		expected = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.001
+	}
+
+	m := uint64(math.Ceil(-float64(expected) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(expected)*math.Ln2)))
+	m = (m + 63) / 64 * 64
+
+	return &BloomFilter{bits: make([]uint64, m/64), m: m, hashes: k}
+}
+
+// AddHash adds a SHA-1 digest to the filter.
+func (f *BloomFilter) AddHash(sum [sha1.Size]byte) {
+	h1, h2 := bloomHashes(sum)
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// ContainsHash reports whether a SHA-1 digest is probably in the filter.
+func (f *BloomFilter) ContainsHash(sum [sha1.Size]byte) bool {
+	h1, h2 := bloomHashes(sum)
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Breached reports whether the password is probably in the filter.
+func (f *BloomFilter) Breached(password string) (bool, error) {
+	return f.ContainsHash(sha1.Sum([]byte(password))), nil
+}
+
+// WriteTo writes the filter in its binary file format.
+func (f *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, 16)
+	copy(header, bloomMagic[:])
+	binary.BigEndian.PutUint32(header[4:], f.hashes)
+	binary.BigEndian.PutUint64(header[8:], f.m)
+
+	written, err := w.Write(header)
+	if err != nil {
+		return int64(written), err
+	}
+	err = binary.Write(w, binary.BigEndian, f.bits)
+	return int64(written) + int64(len(f.bits)*8), err
+}
+
+// LoadBloomChecker reads a bloom filter file written by cmd/breachfilter.
+//
+// Parameters:
+// - path: The location of the filter file.
+//
+// Returns:
+// - *BloomFilter: The loaded filter.
+// - error: An error if the file can not be read or is not a filter.
+func LoadBloomChecker(path string) (*BloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breached password filter: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("could not read breached password filter: %w", err)
+	}
+	if !bytes.Equal(header[:4], bloomMagic[:]) {
+		return nil, errors.New("not a breached password filter")
+	}
+
+	f := &BloomFilter{
+		hashes: binary.BigEndian.Uint32(header[4:]),
+		m:      binary.BigEndian.Uint64(header[8:]),
+	}
+	if f.m == 0 || f.m%64 != 0 || f.hashes == 0 {
+		return nil, errors.New("corrupt breached password filter")
+	}
+	f.bits = make([]uint64, f.m/64)
+	if err := binary.Read(reader, binary.BigEndian, f.bits); err != nil {
+		return nil, fmt.Errorf("could not read breached password filter: %w", err)
+	}
+	return f, nil
+}
+
+// ParseHashLine parses a "SHA1:COUNT" line of a HIBP file.
+//
+// Parameters:
+// - line: The line without its line break.
+//
+// Returns:
+// - [sha1.Size]byte: The SHA-1 digest.
+// - int: The breach count, 1 if the line has none.
+// - error: An error if the hash is not a hex encoded SHA-1 digest.
+func ParseHashLine(line string) ([sha1.Size]byte, int, error) {
+	var sum [sha1.Size]byte
+	hash := line
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		hash = line[:i]
+	}
+	decoded, err := hex.DecodeString(strings.TrimSpace(hash))
+	if err != nil || len(decoded) != sha1.Size {
+		return sum, 0, fmt.Errorf("invalid SHA-1 hash %q", hash)
+	}
+	copy(sum[:], decoded)
+	return sum, lineCount([]byte(line)), nil
+}
+
+// bloomHashes derives the two base hashes for double hashing from the SHA-1 digest,
+// which is already uniformly distributed.
+func bloomHashes(sum [sha1.Size]byte) (uint64, uint64) {
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+	return h1, h2
+}
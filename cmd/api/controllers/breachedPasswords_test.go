@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeHashList writes an ordered HIBP style file for the given password counts
+func writeHashList(t *testing.T, counts map[string]int) string {
+	t.Helper()
+
+	var lines []string
+	for password, count := range counts {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), count))
+	}
+	// Padding so that the binary search has to cross several lines
+	for i := 0; i < 200; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("padding-%d", i)))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i+1))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Test the hash list lookup and its threshold
+func TestHashListChecker(t *testing.T) {
+	path := writeHashList(t, map[string]int{"password": 9545824, "clever-girl": 3})
+
+	checker, err := OpenHashListChecker(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checker.Close()
+
+	for password, expected := range map[string]int{"password": 9545824, "clever-girl": 3, "Tr1ceratops!": 0, "padding-0": 1, "padding-199": 200} {
+		count, err := checker.Count(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Errorf("expected count %d for %q, got %d", expected, password, count)
+		}
+	}
+
+	for password, expected := range map[string]bool{"password": true, "clever-girl": false, "Tr1ceratops!": false} {
+		breached, err := checker.Breached(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if breached != expected {
+			t.Errorf("expected breached=%v for %q, got %v", expected, password, breached)
+		}
+	}
+}
+
+// Test that a bloom filter survives a round trip through its file format
+func TestBloomFilterRoundTrip(t *testing.T) {
+	filter := NewBloomFilter(100, 0.001)
+	for _, password := range []string{"password", "123456", "clever-girl"} {
+		filter.AddHash(sha1.Sum([]byte(password)))
+	}
+
+	path := filepath.Join(t.TempDir(), "breached.bloom")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filter.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	checker, err := NewBreachChecker(BreachedPasswords{Format: "bloom", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{"password", "123456", "clever-girl"} {
+		if breached, _ := checker.Breached(password); !breached {
+			t.Errorf("expected %q to be breached", password)
+		}
+	}
+	if breached, _ := checker.Breached("Tr1ceratops!"); breached {
+		t.Error("expected an unknown password not to be breached")
+	}
+}
@@ -20,7 +20,8 @@ type UserData struct {
 	Username string
 }
 
-// SendEmail sends an email using the Mailgun API. It supports three types of emails: password reset, password change, and account confirmation.
+// SendEmail sends an email using the Mailgun API. It supports the password change notification.
+// Password reset and confirmation emails carry a token link and are sent with SendTemplateEmail.
 // The email content is generated using HTML templates and personalized with the user's data.
 //
 // Parameters:
@@ -29,7 +30,7 @@ type UserData struct {
 // - emailTo: The recipient's email address.
 // - userName: The recipient's username (for personalization).
 // - userID: The recipient's user ID (for personalization and link generation).
-// - emailType: The type of email to send ("password", "passwordChange", or "confirmation").
+// - emailType: The type of email to send ("passwordChange").
 // - delay: The delay (in seconds) before sending the email.
 //
 // Returns:
@@ -42,26 +43,38 @@ func SendEmail(domain, apiKey, emailTo, userName, userID, emailType string, dela
 
 	// Determine the email template and subject based on the emailType
 	switch emailType {
-	case "password":
-		htmlFilename = "resetPassword"
-		emailSubject = "Password Reset for"
-		msg = "You have requested to reset your password, in order to continue please click on the following link"
 	case "passwordChange":
 		htmlFilename = "passwordChanged"
 		emailSubject = "Password Was Successfully Updated"
 		msg = "You have successfully updated your password"
-	case "confirmation":
-		htmlFilename = "confirmationEmail"
-		emailSubject = "Sign Up Confirmation for"
-		msg = "Thank you for registering at Authentication API! Please verify your email to confirm your account using this link"
 	}
 
 	user := UserData{UserID: userID, Username: userName}
 
-	mg := mailgun.NewMailgun(domain, apiKey)
+	return SendTemplateEmail(domain, apiKey, emailTo, fmt.Sprintf("%s %s", emailSubject, userName), msg, htmlFilename, user, delay)
+}
+
+// SendTemplateEmail sends an email whose HTML body is rendered from template/<templateName>.html.
+// It is used for emails that need more data than UserData carries.
+//
+// Parameters:
+// - domain: The Mailgun domain used to send the email.
+// - apiKey: The Mailgun API key.
+// - emailTo: The recipient's email address.
+// - subject: The subject line.
+// - msg: The plain text body.
+// - templateName: The name of the HTML template without extension.
+// - data: The data the template is executed with.
+// - delay: The delay (in seconds) before sending the email.
+//
+// Returns:
+// - string: The ID of the email sent by Mailgun (if successful).
+// - error: An error if the email fails to send or any step in the process fails.
+func SendTemplateEmail(domain, apiKey, emailTo, subject, msg, templateName string, data interface{}, delay int) (string, error) {
+	mg := newMailgun(domain, apiKey)
 
 	// Read the HTML template file
-	htmlContent, err := os.ReadFile(fmt.Sprintf("template/%s.html", htmlFilename))
+	htmlContent, err := os.ReadFile(fmt.Sprintf("template/%s.html", templateName))
 	if err != nil {
 		return "", err
 	}
@@ -69,19 +82,19 @@ func SendEmail(domain, apiKey, emailTo, userName, userID, emailType string, dela
 	// Create a new email message
 	m := mg.NewMessage(
 		fmt.Sprintf("Authentication API <mailgun@%s>", domain),
-		fmt.Sprintf("%s %s", emailSubject, userName),
+		subject,
 		msg,
 		emailTo,
 	)
 
-	// Parse and execute the HTML template with user data
+	// Parse and execute the HTML template with the data
 	tmpl, err := template.New("email").Parse(string(htmlContent))
 	if err != nil {
 		return "", err
 	}
 
 	var emailBodyBuffer bytes.Buffer
-	err = tmpl.Execute(&emailBodyBuffer, user)
+	err = tmpl.Execute(&emailBodyBuffer, data)
 	if err != nil {
 		return "", err
 	}
@@ -113,7 +126,7 @@ func SendEmail(domain, apiKey, emailTo, userName, userID, emailType string, dela
 // - string: The ID of the email sent by Mailgun (if successful).
 // - error: An error if the email fails to send or any step in the process fails.
 func ContactSubmit(domain, apiKey, userEmail, msg string) (string, error) {
-	mg := mailgun.NewMailgun(domain, apiKey)
+	mg := newMailgun(domain, apiKey)
 
 	// Create a new email message for the contact form submission
 	m := mg.NewMessage(
@@ -131,3 +144,20 @@ func ContactSubmit(domain, apiKey, userEmail, msg string) (string, error) {
 	_, id, err := mg.Send(ctx, m)
 	return id, err
 }
+
+// newMailgun creates a Mailgun client. The API base defaults to the US region and can be
+// overridden with the MAIL_SERVER_API_BASE environment variable, e.g. https://api.eu.mailgun.net/v3.
+//
+// Parameters:
+// - domain: The Mailgun domain used to send the email.
+// - apiKey: The Mailgun API key.
+//
+// Returns:
+// - *mailgun.MailgunImpl: The configured Mailgun client.
+func newMailgun(domain, apiKey string) *mailgun.MailgunImpl {
+	mg := mailgun.NewMailgun(domain, apiKey)
+	if apiBase := os.Getenv("MAIL_SERVER_API_BASE"); apiBase != "" {
+		mg.SetAPIBase(apiBase)
+	}
+	return mg
+}
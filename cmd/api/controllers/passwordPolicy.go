@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Default limits applied when settings.yml does not configure them.
+const (
+	DefaultMinPasswordLength = 8
+	DefaultMaxPasswordLength = 72
+)
+
+// PasswordPolicy describes the rules a new password has to satisfy.
+// It is configured under security.password_policy in settings.yml.
+type PasswordPolicy struct {
+	MinLength        int  `yaml:"min_length" json:"min_length"`                 // Minimum number of characters
+	MaxLength        int  `yaml:"max_length" json:"max_length"`                 // Maximum number of bytes
+	RequireUpper     bool `yaml:"require_upper" json:"require_upper"`           // Require an upper case letter
+	RequireLower     bool `yaml:"require_lower" json:"require_lower"`           // Require a lower case letter
+	RequireDigit     bool `yaml:"require_digit" json:"require_digit"`           // Require a digit
+	RequireSymbol    bool `yaml:"require_symbol" json:"require_symbol"`         // Require a symbol or punctuation character
+	MaxRepeated      int  `yaml:"max_repeated" json:"max_repeated"`             // Maximum run of the same character, 0 disables the rule
+	DisallowUserInfo bool `yaml:"disallow_user_info" json:"disallow_user_info"` // Reject passwords containing the username or email
+	History          int  `yaml:"history" json:"history"`                       // Number of previous passwords that can not be reused
+}
+
+// PasswordViolation is a single rule of the policy that a password does not satisfy.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`    // Machine readable name of the rule
+	Message string `json:"message"` // Human readable explanation
+}
+
+// PasswordPolicyError is returned when a password violates the policy.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+// Error implements the error interface.
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the password policy"
+}
+
+// WithDefaults fills in the length limits that are not configured.
+//
+// Returns:
+// - PasswordPolicy: A copy of the policy with the defaults applied.
+func (p PasswordPolicy) WithDefaults() PasswordPolicy {
+	if p.MinLength <= 0 {
+		p.MinLength = DefaultMinPasswordLength
+	}
+	if p.MaxLength <= 0 {
+		p.MaxLength = DefaultMaxPasswordLength
+	}
+	return p
+}
+
+// Validate checks a password against every rule of the policy.
+//
+// Parameters:
+// - password: The plain-text password to check.
+// - username: The username of the account, used by the user info rule.
+// - email: The email address of the account, used by the user info rule.
+//
+// Returns:
+// - error: A *PasswordPolicyError listing every violated rule, or nil if the password is acceptable.
+func (p PasswordPolicy) Validate(password, username, email string) error {
+	var violations []PasswordViolation
+	violate := func(rule, message string) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: message})
+	}
+
+	if len([]rune(password)) < p.MinLength {
+		violate("min_length", fmt.Sprintf("password must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violate("max_length", fmt.Sprintf("password must be at most %d bytes long", p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violate("require_upper", "password must contain an upper case letter")
+	}
+	if p.RequireLower && !hasLower {
+		violate("require_lower", "password must contain a lower case letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violate("require_digit", "password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violate("require_symbol", "password must contain a symbol")
+	}
+
+	if p.MaxRepeated > 0 && longestRun(password) > p.MaxRepeated {
+		violate("max_repeated", fmt.Sprintf("password must not repeat the same character more than %d times in a row", p.MaxRepeated))
+	}
+
+	if p.DisallowUserInfo && containsUserInfo(password, username, email) {
+		violate("disallow_user_info", "password must not contain your username or email address")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// longestRun returns the length of the longest run of identical characters.
+func longestRun(password string) int {
+	longest, current := 0, 0
+	var previous rune
+	for i, c := range []rune(password) {
+		if i > 0 && c == previous {
+			current++
+		} else {
+			current = 1
+		}
+		if current > longest {
+			longest = current
+		}
+		previous = c
+	}
+	return longest
+}
+
+// containsUserInfo reports whether the password contains the username, the email
+// address or the local part of the email address, ignoring case. Values shorter
+// than three characters are ignored to avoid rejecting passwords by accident.
+func containsUserInfo(password, username, email string) bool {
+	lowered := strings.ToLower(password)
+	candidates := []string{username, email}
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		candidates = append(candidates, email[:at])
+	}
+
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(strings.TrimSpace(candidate))
+		if len(candidate) >= 3 && strings.Contains(lowered, candidate) {
+			return true
+		}
+	}
+	return false
+}
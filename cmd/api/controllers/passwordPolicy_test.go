@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+)
+
+// Test PasswordPolicy.Validate against each rule
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{
+		MinLength:        10,
+		MaxLength:        72,
+		RequireUpper:     true,
+		RequireLower:     true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		MaxRepeated:      3,
+		DisallowUserInfo: true,
+	}
+
+	cases := []struct {
+		password string
+		rules    []string
+	}{
+		{"Tr1ceratops!", nil},
+		{"", []string{"min_length", "require_upper", "require_lower", "require_digit", "require_symbol"}},
+		{"tr1ceratops!", []string{"require_upper"}},
+		{"TR1CERATOPS!", []string{"require_lower"}},
+		{"Triceratops!", []string{"require_digit"}},
+		{"Tr1ceratops", []string{"require_symbol"}},
+		{"Tr1ceraaaatops!", []string{"max_repeated"}},
+		{"Malcolm-1993!", []string{"disallow_user_info"}},
+		{"Hello-Ian-1993", []string{"disallow_user_info"}},
+	}
+
+	for _, c := range cases {
+		err := policy.Validate(c.password, "malcolm", "ian@jurassic.park")
+		if c.rules == nil {
+			if err != nil {
+				t.Errorf("expected %q to be accepted, got %v", c.password, err)
+			}
+			continue
+		}
+
+		var policyErr *PasswordPolicyError
+		if !errors.As(err, &policyErr) {
+			t.Errorf("expected %q to be rejected, got %v", c.password, err)
+			continue
+		}
+
+		var rules []string
+		for _, violation := range policyErr.Violations {
+			rules = append(rules, violation.Rule)
+		}
+		if len(rules) != len(c.rules) {
+			t.Errorf("expected %q to violate %v, got %v", c.password, c.rules, rules)
+			continue
+		}
+		for i := range rules {
+			if rules[i] != c.rules[i] {
+				t.Errorf("expected %q to violate %v, got %v", c.password, c.rules, rules)
+				break
+			}
+		}
+	}
+}
+
+// Test that the zero value policy still enforces the default length limits
+func TestPasswordPolicyDefaults(t *testing.T) {
+	policy := PasswordPolicy{}.WithDefaults()
+
+	if err := policy.Validate("short", "", ""); err == nil {
+		t.Errorf("expected a password shorter than %d characters to be rejected", DefaultMinPasswordLength)
+	}
+	if err := policy.Validate(string(make([]byte, DefaultMaxPasswordLength+1)), "", ""); err == nil {
+		t.Errorf("expected a password longer than %d bytes to be rejected", DefaultMaxPasswordLength)
+	}
+	if err := policy.Validate("long enough", "", ""); err != nil {
+		t.Errorf("expected password to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateToken creates a random single-use token for email links. Only the hash
+// is meant to be stored, the raw token is sent to the user.
+//
+// Returns:
+// - string: The raw token, 256 bits encoded as URL-safe base64.
+// - string: The hex encoded SHA-256 hash of the token.
+// - error: An error if no random bytes are available.
+func GenerateToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hash under which a token generated by GenerateToken is stored.
+// A fast hash is enough because the tokens have full entropy.
+//
+// Parameters:
+// - token: The raw token.
+//
+// Returns:
+// - string: The hex encoded SHA-256 hash of the token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,47 +1,50 @@
 package controllers
 
 import (
+	"TriceraPass/internal/passwords"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"math/rand"
 	"os"
 	"path/filepath"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-// HashAPassword generates a bcrypt hash of a plain-text password.
-// The hash is generated using a cost factor of 14, providing strong security.
+// ErrPasswordMismatch is returned by VerifyPasswordNonDuplicate when the passwords differ.
+var ErrPasswordMismatch = errors.New("passwords do not match")
+
+// HashAPassword hashes a plain-text password with the configured password hasher
+// (argon2id by default, see security.password_hashing in settings.yml).
 //
 // Parameters:
 // - password: The plain-text password to hash.
 //
 // Returns:
-// - string: The bcrypt hash of the password.
+// - string: The self-describing hash of the password.
 // - error: An error if hashing fails.
 func HashAPassword(password string) (string, error) {
-	// Generate a hashed version of the password using bcrypt
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	return passwords.Hash(password)
 }
 
 // VerifyPasswordNonDuplicate compares a stored hashed password with a plain-text password.
 // It ensures that the old and new passwords are not the same by checking if the new password matches the stored hash.
 //
 // Parameters:
-// - oldPassword: The bcrypt hashed version of the old password.
+// - oldPassword: The stored hash of the old password, in any supported format.
 // - newPassword: The plain-text new password to verify against the old hash.
 //
 // Returns:
 // - bool: True if the passwords match, false otherwise.
-// - error: An error if the comparison fails.
+// - error: ErrPasswordMismatch if the passwords differ, or an error if the comparison fails.
 func VerifyPasswordNonDuplicate(oldPassword, newPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(oldPassword), []byte(newPassword))
+	match, err := passwords.Verify(oldPassword, newPassword)
 	if err != nil {
 		return false, err
 	}
-
+	if !match {
+		return false, ErrPasswordMismatch
+	}
 	return true, nil
 }
 
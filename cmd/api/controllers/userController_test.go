@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -13,9 +14,9 @@ func TestHashAPassword(t *testing.T) {
 		t.Errorf("expected no error, got %v", err)
 	}
 
-	// bcrypt hashes are always 60 characters long
-	if len(hashedPassword) != 60 {
-		t.Errorf("expected hashed password to be 60 characters long, got %d", len(hashedPassword))
+	// The default hasher produces argon2id PHC strings
+	if !strings.HasPrefix(hashedPassword, "$argon2id$v=19$") {
+		t.Errorf("expected an argon2id PHC string, got %q", hashedPassword)
 	}
 
 	// Verify that the hashed password is not the same as the original password
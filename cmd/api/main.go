@@ -6,10 +6,14 @@ package main
 import (
 	"TriceraPass/cmd/api/application"
 	"TriceraPass/cmd/api/auth"
+	"TriceraPass/cmd/api/controllers"
 	"TriceraPass/cmd/api/server"
+	"TriceraPass/internal/passwords"
 	"TriceraPass/internal/repositories"
+	"TriceraPass/internal/store"
 	"net/http"
 
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -59,6 +63,29 @@ func main() {
 		config.Database.ConnectTimout,
 	)
 
+	app.Config = config
+
+	// Invitations link to the sign-up page of the client application, the API has none
+	if config.Security.Registration.Mode == application.RegistrationInvite && config.Security.Registration.InviteURL == "" {
+		log.Fatal("security.registration.invite_url is required with the invite registration mode")
+	}
+
+	// Configure the password hashing algorithm
+	hasher, err := passwords.FromConfig(config.Security.PasswordHashing)
+	if err != nil {
+		log.Fatal(fmt.Printf("Error configuring password hashing: %v", err))
+	}
+	passwords.SetDefault(hasher)
+	passwords.SetDefaultPool(passwords.NewPool(config.Security.PasswordHashing.Pool))
+
+	// Open the breached password list
+	if config.Security.BreachedPasswords.Enabled {
+		app.Breaches, err = controllers.NewBreachChecker(config.Security.BreachedPasswords)
+		if err != nil {
+			log.Fatal(fmt.Printf("Error loading breached passwords: %v", err))
+		}
+	}
+
 	// read from command line
 	flag.StringVar(&app.DSN, "dsn", defaultDSN, "Postgres connection string")
 	flag.StringVar(&app.JWTSecret, "jwt-secret", config.Security.JWT.Secret, "JWT signing secret")
@@ -77,14 +104,15 @@ func main() {
 
 	// Initiate the auth object
 	app.Auth = auth.Auth{
-		Issuer:        app.JWTIssuer,
-		Audience:      app.JWTAudience,
-		Secret:        app.JWTSecret,
-		TokenExpiry:   time.Minute * 15,
-		RefreshExpiry: time.Hour * 24,
-		CookiePath:    "/",
-		CookieName:    "refresh_token",
-		CookieDomain:  app.CookieDomain,
+		Issuer:           app.JWTIssuer,
+		Audience:         app.JWTAudience,
+		Secret:           app.JWTSecret,
+		TokenExpiry:      time.Minute * 15,
+		RefreshExpiry:    time.Hour * 24,
+		CookiePath:       "/",
+		CookieName:       "refresh_token",
+		AccessCookieName: "access_token",
+		CookieDomain:     app.CookieDomain,
 	}
 
 	// Open database
@@ -102,6 +130,28 @@ func main() {
 		return
 	}
 
+	// Share rate limits, failed logins and one-time codes between replicas
+	app.Store = store.NewMemory()
+	if config.Redis.Host != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		redisStore, err := store.OpenRedis(ctx, config.Redis)
+		cancel()
+		if err != nil {
+			log.Printf("Falling back to in-memory state: %v", err)
+		} else {
+			app.Store = redisStore
+			defer redisStore.Close()
+		}
+	}
+
+	// Warn users whose password expires soon
+	if config.Security.PasswordExpiry.WarnDays > 0 {
+		go app.RunPasswordExpiryWarnings(context.Background())
+	}
+
+	// Purge deleted accounts once their grace period is over
+	go app.RunAccountPurge(context.Background())
+
 	fs := http.FileServer(http.Dir("./docs/assets"))
 	http.Handle("/assets/", http.StripPrefix("/assets/", fs))
 	// Handle the home route
@@ -0,0 +1,188 @@
+// Package proxy provides the embedded authenticating reverse proxy. It forwards
+// requests to configured upstream applications by path prefix, enforces the access
+// rule of each route, strips the caller's credentials and injects signed identity
+// headers so upstreams can trust who the user is without handling tokens themselves.
+package proxy
+
+import (
+	"TriceraPass/cmd/api/application"
+	"TriceraPass/cmd/api/utils"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access rules supported by a proxy route.
+const (
+	AccessPublic        = "public"
+	AccessAuthenticated = "authenticated"
+	AccessAdmin         = "admin"
+)
+
+// Identity headers injected into every proxied request.
+const (
+	HeaderUserID    = "X-User-Id"
+	HeaderUserEmail = "X-User-Email"
+	HeaderUserMode  = "X-User-Mode"
+	HeaderTimestamp = "X-User-Timestamp"
+	HeaderSignature = "X-User-Signature"
+)
+
+// identityHeaders lists the headers that are always removed from the incoming
+// request so that clients can not spoof an identity.
+var identityHeaders = []string{HeaderUserID, HeaderUserEmail, HeaderUserMode, HeaderTimestamp, HeaderSignature}
+
+// New builds the handler for a single proxy route.
+//
+// Parameters:
+// - app: A pointer to the application context containing authentication logic and repositories.
+// - route: The route configuration from settings.yml.
+//
+// Returns:
+// - http.Handler: The handler that authenticates and forwards requests for the route.
+// - error: An error if the route configuration is invalid.
+func New(app *application.Application, route application.ProxyRoute) (http.Handler, error) {
+	if !strings.HasPrefix(route.Prefix, "/") || strings.TrimSuffix(route.Prefix, "/") == "" {
+		return nil, fmt.Errorf("proxy route prefix %q must be a path below /", route.Prefix)
+	}
+
+	upstream, err := url.Parse(route.Upstream)
+	if err != nil || upstream.Scheme == "" || upstream.Host == "" {
+		return nil, fmt.Errorf("proxy route %s has an invalid upstream %q", route.Prefix, route.Upstream)
+	}
+
+	access := route.Access
+	if access == "" {
+		access = AccessAuthenticated
+	}
+	if access != AccessPublic && access != AccessAuthenticated && access != AccessAdmin {
+		return nil, fmt.Errorf("proxy route %s has an unknown access rule %q", route.Prefix, route.Access)
+	}
+
+	secret := app.Config.Proxy.IdentitySecret
+	if secret == "" {
+		return nil, errors.New("proxy identity_secret must be set when the proxy is enabled")
+	}
+
+	var forward http.Handler = httputil.NewSingleHostReverseProxy(upstream)
+	if route.StripPrefix {
+		forward = http.StripPrefix(strings.TrimSuffix(route.Prefix, "/"), forward)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range identityHeaders {
+			r.Header.Del(header)
+		}
+
+		if access != AccessPublic {
+			// Browser navigations carry the access token in the cookie set at login
+			_, claims, err := app.Auth.GetTokenFromRequestAndVerify(w, r)
+			if err != nil {
+				unauthenticated(app, w, r)
+				return
+			}
+
+			user, err := app.Repository.GetUserByID(claims.Subject)
+			if err != nil || app.UserSessionRevoked(user, claims) {
+				unauthenticated(app, w, r)
+				return
+			}
+
+			if access == AccessAdmin && user.Mode.Name != "admin" {
+				utils.ErrorJSON(w, errors.New("forbidden"), http.StatusForbidden)
+				return
+			}
+
+			timestamp := strconv.FormatInt(time.Now().UTC().Unix(), 10)
+			r.Header.Set(HeaderUserID, user.ID)
+			r.Header.Set(HeaderUserEmail, user.Email)
+			r.Header.Set(HeaderUserMode, user.Mode.Name)
+			r.Header.Set(HeaderTimestamp, timestamp)
+			r.Header.Set(HeaderSignature, SignIdentity(secret, user.ID, user.Email, user.Mode.Name, timestamp))
+		}
+
+		// The upstream only ever sees the signed identity, never the caller's tokens
+		r.Header.Del("Authorization")
+		stripCookie(r, app.Auth.CookieName)
+		stripCookie(r, app.Auth.AccessCookieName)
+
+		forward.ServeHTTP(w, r)
+	}), nil
+}
+
+// SignIdentity computes the hex encoded HMAC-SHA256 signature of the identity headers.
+// Upstreams recompute it with the shared identity secret to check that the headers
+// were set by the proxy.
+//
+// Parameters:
+// - secret: The shared identity secret.
+// - userID, email, mode: The identity header values.
+// - timestamp: The Unix timestamp sent in the X-User-Timestamp header.
+//
+// Returns:
+// - string: The signature sent in the X-User-Signature header.
+func SignIdentity(secret, userID, email, mode, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{userID, email, mode, timestamp}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyIdentity checks the signed identity headers of a request received from the proxy.
+//
+// Parameters:
+// - secret: The shared identity secret.
+// - r: The request received by the upstream.
+// - maxAge: How old the timestamp may be before the headers are rejected.
+//
+// Returns:
+// - error: An error if the headers are missing, stale or not signed with the secret.
+func VerifyIdentity(secret string, r *http.Request, maxAge time.Duration) error {
+	timestamp := r.Header.Get(HeaderTimestamp)
+	issued, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing identity timestamp")
+	}
+	if time.Since(time.Unix(issued, 0)) > maxAge {
+		return errors.New("stale identity headers")
+	}
+
+	expected := SignIdentity(secret, r.Header.Get(HeaderUserID), r.Header.Get(HeaderUserEmail), r.Header.Get(HeaderUserMode), timestamp)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))) {
+		return errors.New("invalid identity signature")
+	}
+	return nil
+}
+
+// unauthenticated redirects browser navigations to the hosted login page and
+// answers every other request with 401.
+func unauthenticated(app *application.Application, w http.ResponseWriter, r *http.Request) {
+	isBrowser := (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+
+	if isBrowser && app.Config.Proxy.LoginURL != "" {
+		target := app.Config.Proxy.LoginURL + "?redirect=" + url.QueryEscape(r.URL.RequestURI())
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+
+	utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+}
+
+// stripCookie removes a single cookie from the request while keeping the others.
+func stripCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"TriceraPass/cmd/api/application"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test that the signature only verifies with the right secret and untouched headers
+func TestVerifyIdentity(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	timestamp := "4102444800"
+	r.Header.Set(HeaderUserID, "user-1")
+	r.Header.Set(HeaderUserEmail, "alan@grant.com")
+	r.Header.Set(HeaderUserMode, "default")
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderSignature, SignIdentity("secret", "user-1", "alan@grant.com", "default", timestamp))
+
+	// A timestamp in the future is never stale
+	if err := VerifyIdentity("secret", r, time.Minute); err != nil {
+		t.Errorf("expected identity to verify, got %v", err)
+	}
+
+	if err := VerifyIdentity("other-secret", r, time.Minute); err == nil {
+		t.Errorf("expected identity signed with another secret to be rejected")
+	}
+
+	r.Header.Set(HeaderUserMode, "admin")
+	if err := VerifyIdentity("secret", r, time.Minute); err == nil {
+		t.Errorf("expected tampered identity to be rejected")
+	}
+}
+
+// Test that public routes strip credentials and spoofed identity headers
+func TestPublicRouteStripsCredentials(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || r.Header.Get(HeaderUserID) != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, err := r.Cookie("refresh_token"); err == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, err := r.Cookie("access_token"); err == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	app := &application.Application{Config: &application.Config{}}
+	app.Config.Proxy.IdentitySecret = "secret"
+	app.Auth.CookieName = "refresh_token"
+	app.Auth.AccessCookieName = "access_token"
+
+	handler, err := New(app, application.ProxyRoute{Prefix: "/legacy", Upstream: upstream.URL, Access: AccessPublic, StripPrefix: true})
+	if err != nil {
+		t.Fatalf("Error creating proxy: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/legacy/page", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set(HeaderUserID, "spoofed")
+	r.AddCookie(&http.Cookie{Name: "refresh_token", Value: "token"})
+	r.AddCookie(&http.Cookie{Name: "access_token", Value: "token"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Body.String() != "/page" {
+		t.Errorf("expected 200 with stripped path, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+// Test that invalid routes are rejected
+func TestNewRejectsInvalidRoutes(t *testing.T) {
+	app := &application.Application{Config: &application.Config{}}
+	app.Config.Proxy.IdentitySecret = "secret"
+
+	routes := []application.ProxyRoute{
+		{Prefix: "/", Upstream: "http://localhost:8080"},
+		{Prefix: "/legacy", Upstream: "localhost"},
+		{Prefix: "/legacy", Upstream: "http://localhost:8080", Access: "everyone"},
+	}
+	for _, route := range routes {
+		if _, err := New(app, route); err == nil {
+			t.Errorf("expected route %+v to be rejected", route)
+		}
+	}
+}
@@ -4,7 +4,13 @@ import (
 	"TriceraPass/cmd/api/application"
 	"TriceraPass/cmd/api/utils"
 	"TriceraPass/internal/models"
+	"TriceraPass/internal/repositories"
+	"TriceraPass/internal/userimport"
+	"TriceraPass/pkg/verifier"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -12,14 +18,39 @@ import (
 
 // Type to cast for admin user
 type UserDataAsAdmin struct {
-	ID        string      `gorm:"type:uuid;primary_key"`
-	CreatedAt time.Time   `json:"created_at"`
-	DeletedAt time.Time   `json:"deleted_at"`
-	UserName  string      `json:"username"`
-	FirstName string      `json:"first_name"`
-	LastName  string      `json:"last_name"`
-	Email     string      `json:"email"`
-	Mode      models.Mode `gorm:"foreignKey:UserID" json:"mode,omitempty"`
+	ID        string    `gorm:"type:uuid;primary_key"`
+	CreatedAt time.Time `json:"created_at"`
+	DeletedAt time.Time `json:"deleted_at"`
+	// Status is the effective status, see models.User.CurrentStatus
+	Status          string      `json:"status"`
+	StatusReason    string      `json:"status_reason,omitempty"`
+	StatusChangedBy string      `json:"status_changed_by,omitempty"`
+	StatusChangedAt *time.Time  `json:"status_changed_at,omitempty"`
+	StatusUntil     *time.Time  `json:"status_until,omitempty"`
+	UserName        string      `json:"username"`
+	FirstName       string      `json:"first_name"`
+	LastName        string      `json:"last_name"`
+	Email           string      `json:"email"`
+	Mode            models.Mode `gorm:"foreignKey:UserID" json:"mode,omitempty"`
+}
+
+// userDataAsAdmin returns the admin view of a user.
+func userDataAsAdmin(u models.User) UserDataAsAdmin {
+	return UserDataAsAdmin{
+		ID:              u.ID,
+		CreatedAt:       u.CreatedAt,
+		DeletedAt:       u.DeletedAt,
+		Status:          u.CurrentStatus(),
+		StatusReason:    u.StatusReason,
+		StatusChangedBy: u.StatusChangedBy,
+		StatusChangedAt: u.StatusChangedAt,
+		StatusUntil:     u.StatusUntil,
+		UserName:        u.UserName,
+		FirstName:       u.FirstName,
+		LastName:        u.LastName,
+		Email:           u.Email,
+		Mode:            u.Mode,
+	}
 }
 
 func AdminDeleteAllUsers(app *application.Application) http.HandlerFunc {
@@ -28,7 +59,8 @@ func AdminDeleteAllUsers(app *application.Application) http.HandlerFunc {
 	}
 }
 
-// AdminDeleteUserByID deletes a user from the database by their user ID and returns a success message.
+// AdminDeleteUserByID deletes a user by their user ID. The user can no longer log in
+// and is purged with all of their data once the deletion grace period is over.
 //
 // Parameters:
 // - app: A pointer to the application context containing repositories.
@@ -40,8 +72,8 @@ func AdminDeleteUserByID(app *application.Application) http.HandlerFunc {
 		// Extract user ID from the URL parameters
 		IDParam := chi.URLParam(r, "user_id")
 
-		// Delete the user by ID
-		err := app.Repository.DeleteUserByID(IDParam)
+		// Mark the user as deleted
+		purgeAt, err := app.DeleteAccount(IDParam)
 		if err != nil {
 			utils.ErrorJSON(w, err)
 			return
@@ -51,11 +83,221 @@ func AdminDeleteUserByID(app *application.Application) http.HandlerFunc {
 		response := utils.JSONResponse{
 			Error:   false,
 			Message: "successfully removed user",
+			Data:    map[string]time.Time{"purge_at": purgeAt},
+		}
+		_ = utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+// AdminRestoreUser restores a deleted user within the deletion grace period.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that restores a user.
+func AdminRestoreUser(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := app.RestoreAccount(chi.URLParam(r, "user_id"))
+		if restoreErrorJSON(w, err) {
+			return
+		}
+
+		response := utils.JSONResponse{Message: "user restored"}
+		_ = utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+// AdminSuspendUser suspends or locks a user, indefinitely or until the given time. The
+// user is logged out everywhere and can not log in until the block ends.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that suspends a user.
+func AdminSuspendUser(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestPayload struct {
+			Status string     `json:"status"`
+			Reason string     `json:"reason"`
+			Until  *time.Time `json:"until"`
+		}
+		if err := utils.ReadJSON(w, r, &requestPayload); err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+
+		claims, ok := verifier.FromContext(r.Context())
+		if !ok {
+			utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		err := app.SuspendAccount(chi.URLParam(r, "user_id"), claims.Subject, requestPayload.Status, requestPayload.Reason, requestPayload.Until)
+		switch {
+		case errors.Is(err, application.ErrInvalidStatus), errors.Is(err, application.ErrSuspendSelf):
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		case err != nil:
+			utils.ErrorJSON(w, err, http.StatusNotFound)
+			return
+		}
+
+		response := utils.JSONResponse{Message: "user suspended"}
+		_ = utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+// AdminReactivateUser lifts the suspension or lock of a user.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that reactivates a user.
+func AdminReactivateUser(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := verifier.FromContext(r.Context())
+		if !ok {
+			utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		err := app.ReactivateAccount(chi.URLParam(r, "user_id"), claims.Subject)
+		switch {
+		case errors.Is(err, repositories.ErrUserNotSuspended):
+			utils.ErrorJSON(w, err, http.StatusConflict)
+			return
+		case err != nil:
+			utils.ErrorJSON(w, err, http.StatusNotFound)
+			return
 		}
+
+		response := utils.JSONResponse{Message: "user reactivated"}
 		_ = utils.WriteJSON(w, http.StatusOK, response)
 	}
 }
 
+// AdminGetPendingUsers lists the users waiting for approval, oldest first. The query
+// parameters confirmed (true or false), email (part of the address), registered_after
+// and registered_before (RFC 3339 times) narrow down the list.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that lists the approval queue.
+func AdminGetPendingUsers(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := repositories.PendingUserFilter{Email: query.Get("email")}
+
+		if value := query.Get("confirmed"); value != "" {
+			confirmed, err := strconv.ParseBool(value)
+			if err != nil {
+				utils.ErrorJSON(w, errors.New("confirmed must be true or false"), http.StatusBadRequest)
+				return
+			}
+			filter.Confirmed = &confirmed
+		}
+		for name, target := range map[string]*time.Time{
+			"registered_after":  &filter.RegisteredAfter,
+			"registered_before": &filter.RegisteredBefore,
+		} {
+			if value := query.Get(name); value != "" {
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					utils.ErrorJSON(w, fmt.Errorf("%s must be an RFC 3339 time", name), http.StatusBadRequest)
+					return
+				}
+				*target = t
+			}
+		}
+
+		users, err := app.Repository.GetPendingApprovalUsers(filter)
+		if err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+
+		usersInResponse := []UserDataAsAdmin{}
+		for _, u := range users {
+			usersInResponse = append(usersInResponse, userDataAsAdmin(u))
+		}
+		_ = utils.WriteJSON(w, http.StatusOK, utils.JSONResponse{Data: usersInResponse})
+	}
+}
+
+// AdminApproveUser approves a user waiting for approval, who is emailed about it.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that approves a user.
+func AdminApproveUser(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := verifier.FromContext(r.Context())
+		if !ok {
+			utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		err := app.ApproveUser(chi.URLParam(r, "user_id"), claims.Subject)
+		if approvalErrorJSON(w, err) {
+			return
+		}
+
+		_ = utils.WriteJSON(w, http.StatusOK, utils.JSONResponse{Message: "user approved"})
+	}
+}
+
+// AdminRejectUser rejects a user waiting for approval, who is emailed the reason.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that rejects a user.
+func AdminRejectUser(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestPayload struct {
+			Reason string `json:"reason"`
+		}
+		if err := utils.ReadJSON(w, r, &requestPayload); err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+
+		claims, ok := verifier.FromContext(r.Context())
+		if !ok {
+			utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		err := app.RejectUser(chi.URLParam(r, "user_id"), claims.Subject, requestPayload.Reason)
+		if approvalErrorJSON(w, err) {
+			return
+		}
+
+		_ = utils.WriteJSON(w, http.StatusOK, utils.JSONResponse{Message: "user rejected"})
+	}
+}
+
+// approvalErrorJSON answers a failed approval or rejection.
+func approvalErrorJSON(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, repositories.ErrUserNotPending), errors.Is(err, application.ErrEmailNotConfirmed):
+		utils.ErrorJSON(w, err, http.StatusConflict)
+	default:
+		utils.ErrorJSON(w, err, http.StatusNotFound)
+	}
+	return true
+}
+
 func AdminCreateUser(app *application.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -80,18 +322,7 @@ func GetAllUsers(app *application.Application) http.HandlerFunc {
 		var usersInResponse []UserDataAsAdmin
 
 		for _, u := range users {
-			userInResponse := UserDataAsAdmin{
-				ID:        u.ID,
-				CreatedAt: u.CreatedAt,
-				DeletedAt: u.DeletedAt,
-				UserName:  u.UserName,
-				FirstName: u.FirstName,
-				LastName:  u.LastName,
-				Email:     u.Email,
-				Mode:      u.Mode,
-			}
-
-			usersInResponse = append(usersInResponse, userInResponse)
+			usersInResponse = append(usersInResponse, userDataAsAdmin(u))
 		}
 
 		response := utils.JSONResponse{
@@ -101,3 +332,62 @@ func GetAllUsers(app *application.Application) http.HandlerFunc {
 		_ = utils.WriteJSON(w, http.StatusOK, response)
 	}
 }
+
+// maxImportBytes limits the size of an uploaded user export.
+const maxImportBytes = 64 << 20
+
+// AdminImportUsers imports the users of a Firebase, Auth0, Django (CSV) or generic JSON
+// export, keeping their password hashes. The export is the request body, the format is
+// chosen with the format query parameter, Firebase exports also need the project the
+// hash parameters are configured under. With dry_run=true the export is only checked.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that imports users.
+func AdminImportUsers(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+
+		body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+		records, err := userimport.Parse(query.Get("format"), query.Get("project"), body)
+		if err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+
+		report := app.ImportUsers(records, dryRun)
+		response := utils.JSONResponse{
+			Message: fmt.Sprintf("imported %d of %d users", report.Imported, report.Total),
+			Data:    report,
+		}
+		_ = utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+// AdminUnlockUser lifts the login lockout of a user.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that unlocks a user.
+func AdminUnlockUser(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.Repository.GetUserByID(chi.URLParam(r, "user_id"))
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusNotFound)
+			return
+		}
+
+		if err := app.UnlockAccount(user.Email); err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+
+		response := utils.JSONResponse{Message: "user unlocked"}
+		_ = utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,231 @@
+package handlers_test
+
+import (
+	"TriceraPass/internal/passwords"
+	"TriceraPass/internal/testenv"
+	"TriceraPass/pkg/client"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test importing users with foreign password hashes, which are upgraded on the first login
+func TestImportUsers(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	previous := passwords.Default()
+	t.Cleanup(func() { passwords.SetDefault(previous) })
+
+	// Hash parameters of the sample project in the Firebase scrypt documentation
+	hasher, err := passwords.FromConfig(passwords.Config{Firebase: map[string]passwords.FirebaseScryptParams{
+		"isla-sorna": {
+			SignerKey:     "jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==",
+			SaltSeparator: "Bw==",
+			Rounds:        8,
+			MemCost:       14,
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords.SetDefault(hasher)
+
+	env.Register(t, "hammond", "spared-no-expense")
+	admin := env.Login(t, "hammond", "spared-no-expense")
+
+	firebase := `{"users": [
+		{"localId": "1", "email": "ludlow@jurassic.park", "emailVerified": true, "displayName": "Peter Ludlow",
+		 "passwordHash": "lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==", "salt": "42xEC+ixf3L2lw=="},
+		{"localId": "2", "email": "hammond@jurassic.park", "passwordHash": "", "salt": ""}
+	]}`
+
+	// A dry run reports the existing user and imports nothing
+	report, err := admin.ImportUsers(ctx, "firebase", "isla-sorna", strings.NewReader(firebase), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Imported != 1 || len(report.Failed) != 1 || report.Failed[0].Row != 2 {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+	if _, err := env.App.Repository.GetUserByEmail("ludlow@jurassic.park"); err == nil {
+		t.Fatal("expected the dry run not to import anything")
+	}
+
+	if _, err := admin.ImportUsers(ctx, "firebase", "isla-sorna", strings.NewReader(firebase), false); err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash, _ := passwords.NewBcrypt(passwords.BcryptParams{Cost: 4}).Hash("raptor-fence")
+	auth0 := `{"email": "muldoon@jurassic.park", "email_verified": true, "nickname": "muldoon", "passwordHash": "` + bcryptHash + `"}` + "\n"
+	if _, err := admin.ImportUsers(ctx, "auth0", "", strings.NewReader(auth0), false); err != nil {
+		t.Fatal(err)
+	}
+
+	django := "username,email,first_name,last_name,password\n" +
+		"arnold,arnold@jurassic.park,Ray,Arnold,pbkdf2_sha256$10000$seasalt$CWWFdHOWwPnki7HvkcqN9iA2T3KLW1cf2uZ5kvArtVY=\n" +
+		"gennaro,gennaro@jurassic.park,Donald,Gennaro,sha1$unsupported$hash\n"
+	report, err = admin.ImportUsers(ctx, "csv", "", strings.NewReader(django), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 1 || len(report.Failed) != 1 || report.Failed[0].Email != "gennaro@jurassic.park" {
+		t.Fatalf("expected the unsupported hash to be skipped, got %+v", report)
+	}
+
+	// Every imported password works and is upgraded to the native hash
+	for email, password := range map[string]string{
+		"ludlow@jurassic.park":  "user1password",
+		"muldoon@jurassic.park": "raptor-fence",
+		"arnold@jurassic.park":  "lètmein",
+	} {
+		if _, err := client.New(env.Server.URL).Login(ctx, email, password); err != nil {
+			t.Errorf("%s: expected the imported password to work, got %v", email, err)
+			continue
+		}
+		user, err := env.App.Repository.GetUserByEmail(email)
+		if err != nil || !strings.HasPrefix(user.Password, "$argon2id$") {
+			t.Errorf("%s: expected the hash to be upgraded, got %q, error: %v", email, user.Password, err)
+		}
+	}
+
+	user, _ := env.App.Repository.GetUserByEmail("ludlow@jurassic.park")
+	if user.UserName != "ludlow" || user.FirstName != "Peter" || user.Mode.Name != "default" {
+		t.Errorf("unexpected imported user %+v", user)
+	}
+	if confirmation, err := env.App.Repository.GetLastConfirmation(user.ID); err != nil || !confirmation.Confirmed {
+		t.Errorf("expected the verified email to be confirmed, got %+v, error: %v", confirmation, err)
+	}
+}
+
+// Test suspending and reactivating users: outstanding tokens, logins and expiring locks
+func TestAccountSuspension(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	hammondID := env.Register(t, "hammond", "spared-no-expense")
+	wuID := env.Register(t, "wu", "frog-dna")
+	env.Mail.WaitFor(t, 2)
+	admin := env.Login(t, "hammond", "spared-no-expense")
+	c := env.Login(t, "wu", "frog-dna")
+
+	if err := admin.SuspendUser(ctx, hammondID, client.SuspendRequest{}); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected admins to be unable to suspend themselves, got %v", err)
+	}
+	if err := admin.SuspendUser(ctx, wuID, client.SuspendRequest{Status: "pending_deletion"}); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected an invalid status to be rejected, got %v", err)
+	}
+	if err := admin.ReactivateUser(ctx, wuID); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected an active user to be rejected, got %v", err)
+	}
+
+	if err := admin.SuspendUser(ctx, wuID, client.SuspendRequest{Reason: "unauthorized cloning"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetUserByID(ctx, wuID); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected the suspension to reject outstanding tokens, got %v", err)
+	}
+	if _, err := client.New(env.Server.URL).Login(ctx, "wu@jurassic.park", "frog-dna"); !errors.Is(err, client.ErrForbidden) || !strings.Contains(err.Error(), "suspended") {
+		t.Errorf("expected the suspended user to be unable to log in, got %v", err)
+	}
+
+	users, err := admin.ListUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range users {
+		if user.ID == wuID && (user.Status != "suspended" || user.StatusReason != "unauthorized cloning") {
+			t.Errorf("expected the suspension in the user list, got %q (%q)", user.Status, user.StatusReason)
+		}
+	}
+
+	if err := admin.ReactivateUser(ctx, wuID); err != nil {
+		t.Fatal(err)
+	}
+	c = env.Login(t, "wu", "frog-dna")
+
+	// Locks with an end lift themselves
+	until := time.Now().Add(1500 * time.Millisecond)
+	if err := admin.SuspendUser(ctx, wuID, client.SuspendRequest{Status: "locked", Until: &until}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Refresh(ctx); !errors.Is(err, client.ErrForbidden) && !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected the lock to reject the refresh token, got %v", err)
+	}
+	if _, err := client.New(env.Server.URL).Login(ctx, "wu@jurassic.park", "frog-dna"); !errors.Is(err, client.ErrForbidden) || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("expected the locked user to be unable to log in, got %v", err)
+	}
+	time.Sleep(time.Until(until) + 100*time.Millisecond)
+	env.Login(t, "wu", "frog-dna")
+}
+
+// Test the approval queue: pending users cannot log in until an admin approved them
+func TestRegistrationApproval(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	env.App.Config.Security.Registration.RequireApproval = true
+
+	// The first user needs no approval
+	env.Register(t, "hammond", "spared-no-expense")
+	grantID := env.Register(t, "grant", "clever-girl")
+	nedryID := env.Register(t, "nedry", "ah-ah-ah")
+	sent := env.Mail.WaitFor(t, 3)
+	admin := env.Login(t, "hammond", "spared-no-expense")
+
+	_, err := client.New(env.Server.URL).Login(ctx, "grant@jurassic.park", "clever-girl")
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || apiErr.Code() != "pending_approval" {
+		t.Errorf("expected the pending user to be refused with its own code, got %v", err)
+	}
+
+	pending, err := admin.ListPendingUsers(ctx, client.PendingUsersFilter{})
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("expected two pending users, got %d, error: %v", len(pending), err)
+	}
+	if pending, err := admin.ListPendingUsers(ctx, client.PendingUsersFilter{Email: "GRANT"}); err != nil || len(pending) != 1 || pending[0].ID != grantID {
+		t.Errorf("expected the email filter to find grant, got %+v, error: %v", pending, err)
+	}
+
+	if err := admin.ApproveUser(ctx, grantID); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected an unconfirmed user to be unable to be approved, got %v", err)
+	}
+	if err := client.New(env.Server.URL).ConfirmEmail(ctx, testenv.ConfirmationToken(t, sent, "grant@jurassic.park")); err != nil {
+		t.Fatal(err)
+	}
+	confirmed := true
+	if pending, err := admin.ListPendingUsers(ctx, client.PendingUsersFilter{Confirmed: &confirmed}); err != nil || len(pending) != 1 || pending[0].ID != grantID {
+		t.Errorf("expected the confirmed filter to find grant, got %+v, error: %v", pending, err)
+	}
+	if pending, err := admin.ListPendingUsers(ctx, client.PendingUsersFilter{RegisteredAfter: time.Now().Add(time.Hour)}); err != nil || len(pending) != 0 {
+		t.Errorf("expected no users registered in the future, got %+v, error: %v", pending, err)
+	}
+
+	if err := admin.ApproveUser(ctx, grantID); err != nil {
+		t.Fatal(err)
+	}
+	sent = env.Mail.WaitFor(t, 4)
+	if approved := sent[len(sent)-1]; approved.To != "grant@jurassic.park" || !strings.HasPrefix(approved.Subject, "Your Account Was Approved") {
+		t.Errorf("expected the approval email, got %+v", approved)
+	}
+	env.Login(t, "grant", "clever-girl")
+	if err := admin.ApproveUser(ctx, grantID); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected an approved user to be rejected, got %v", err)
+	}
+
+	if err := admin.RejectUser(ctx, nedryID, "security concerns"); err != nil {
+		t.Fatal(err)
+	}
+	sent = env.Mail.WaitFor(t, 5)
+	if rejected := sent[len(sent)-1]; rejected.To != "nedry@jurassic.park" || !strings.Contains(rejected.HTML, "security concerns") {
+		t.Errorf("expected the rejection email with the reason, got %+v", rejected)
+	}
+	_, err = client.New(env.Server.URL).Login(ctx, "nedry@jurassic.park", "ah-ah-ah")
+	if !errors.As(err, &apiErr) || apiErr.Code() != "rejected" {
+		t.Errorf("expected the rejected user to be refused with its own code, got %v", err)
+	}
+	if err := admin.ApproveUser(ctx, "missing"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected an unknown user to be not found, got %v", err)
+	}
+}
@@ -10,13 +10,18 @@ import (
 	"TriceraPass/cmd/api/controllers"
 	"TriceraPass/cmd/api/utils"
 	"TriceraPass/internal/models"
+	"TriceraPass/internal/repositories"
+	"TriceraPass/pkg/verifier"
+	"bytes"
 	"errors"
 	"fmt"
+	"html/template"
+	"log"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
@@ -69,42 +74,260 @@ func Authenticate(app *application.Application) http.HandlerFunc {
 			return
 		}
 
-		// Fetch user by email
+		// Slow down or block repeated failures before looking at the credentials
+		clientIP := app.ClientIP(r)
+		if retryAfter, blocked := app.LoginBlocked(requestPayload.Email, clientIP); blocked {
+			tooManyAttemptsJSON(w, retryAfter)
+			return
+		}
+
+		// Fetch user by email, unknown emails get the same answer as wrong passwords
 		user, err := app.Repository.GetUserByEmail(requestPayload.Email)
 		if err != nil {
-			utils.ErrorJSON(w, errors.New("invalid credentials"), http.StatusUnauthorized)
+			app.RecordFailedLogin(requestPayload.Email, clientIP, nil)
+			utils.ErrorJSON(w, errors.New("invalid email or password"), http.StatusUnauthorized)
 			return
 		}
 
 		// Check if the provided password matches the stored password hash
 		valid, err := user.PasswordMatches(requestPayload.Password)
+		if hashingBusyJSON(w, err) {
+			return
+		}
 		if err != nil || !valid {
+			app.RecordFailedLogin(requestPayload.Email, clientIP, user)
 			utils.ErrorJSON(w, errors.New("invalid email or password"), http.StatusUnauthorized)
 			return
 		}
+		app.RecordSuccessfulLogin(requestPayload.Email)
+
+		// Deleted accounts have to be restored, suspended ones reactivated and new ones approved before they can log in
+		if err := app.AccountBlocked(user); err != nil {
+			accountBlockedJSON(w, user, err)
+			return
+		}
+
+		// Unconfirmed users may only log in if the confirmation policy allows it
+		if err := app.LoginAllowed(user, app.EmailConfirmed(user)); err != nil {
+			utils.ErrorJSON(w, err, http.StatusForbidden)
+			return
+		}
+
+		// Store the upgraded hash if the password was hashed with outdated settings
+		if user.PasswordRehashed() {
+			if err := app.Repository.UpdatePasswordHash(user.ID, user.Password); err != nil {
+				log.Printf("could not store the rehashed password of user %s: %v", user.ID, err)
+			}
+		}
 
-		// Create a JWT user and generate token pairs
-		u := auth.JwtUser{
-			ID:        user.ID,
-			UserName:  user.UserName,
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
+		// Flag accounts whose current password turned up in a breach so they must rotate it
+		if app.Config != nil && app.Config.Security.BreachedPasswords.CheckOnLogin &&
+			!user.PasswordRotationRequired && app.PasswordBreached(requestPayload.Password) {
+			if err := app.Repository.RequirePasswordRotation(user.ID); err != nil {
+				log.Printf("could not flag user %s for password rotation: %v", user.ID, err)
+			} else {
+				user.PasswordRotationRequired = true
+			}
 		}
 
-		tokens, err := app.Auth.GenerateTokenPair(&u)
+		// Generate token pairs, restricted to a password change if the password expired
+		tokens, err := issueTokens(app, user)
 		if err != nil {
 			utils.ErrorJSON(w, err)
 			return
 		}
 
-		// Set refresh token in a cookie
+		// Set refresh token in a cookie, and the access token for browser navigations
 		refreshCookie := app.Auth.GetRefreshCookie(tokens.RefreshToken)
 		http.SetCookie(w, refreshCookie)
+		http.SetCookie(w, app.Auth.GetAccessCookie(tokens.Token))
 
 		utils.WriteJSON(w, http.StatusAccepted, tokens)
 	}
 }
 
+// UnlockPage renders the page of the unlock link. Opening the link changes nothing, so
+// link scanners of mail providers can not use it up: the page posts the token to
+// UnlockAccount once the user confirms.
+//
+// Parameters:
+// - app: A pointer to the application context containing configuration.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function for the unlock link.
+func UnlockPage(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl, err := template.ParseFiles("./template/unlock.html")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error parsing template: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			Config *application.Config
+			Token  string
+		}{
+			Config: app.Config,
+			Token:  r.URL.Query().Get("token"),
+		}
+
+		var page bytes.Buffer
+		if err := tmpl.Execute(&page, data); err != nil {
+			http.Error(w, "Error rendering template", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = page.WriteTo(w)
+	}
+}
+
+// UnlockAccount lifts an account lockout with the token from the unlock email.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that unlocks an account.
+func UnlockAccount(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestPayload struct {
+			Token string `json:"token"`
+		}
+		if err := utils.ReadJSON(w, r, &requestPayload); err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := app.UnlockAccountWithToken(requestPayload.Token); err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+
+		response := utils.JSONResponse{Message: "your account was unlocked, you can log in again"}
+		_ = utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+// RestoreAccount restores a deleted account within the deletion grace period. The
+// caller proves the credentials like on the login and logs in afterwards.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function for the restore route.
+func RestoreAccount(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestPayload struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+		if err := utils.ReadJSON(w, r, &requestPayload); err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+
+		// Guessing passwords here is throttled like on the login
+		clientIP := app.ClientIP(r)
+		if retryAfter, blocked := app.LoginBlocked(requestPayload.Email, clientIP); blocked {
+			tooManyAttemptsJSON(w, retryAfter)
+			return
+		}
+
+		user, err := app.Repository.GetUserByEmail(requestPayload.Email)
+		if err != nil {
+			app.RecordFailedLogin(requestPayload.Email, clientIP, nil)
+			utils.ErrorJSON(w, errors.New("invalid email or password"), http.StatusUnauthorized)
+			return
+		}
+
+		valid, err := user.PasswordMatches(requestPayload.Password)
+		if hashingBusyJSON(w, err) {
+			return
+		}
+		if err != nil || !valid {
+			app.RecordFailedLogin(requestPayload.Email, clientIP, user)
+			utils.ErrorJSON(w, errors.New("invalid email or password"), http.StatusUnauthorized)
+			return
+		}
+		app.RecordSuccessfulLogin(requestPayload.Email)
+
+		if restoreErrorJSON(w, app.RestoreAccount(user.ID)) {
+			return
+		}
+
+		response := utils.JSONResponse{Message: "your account was restored, you can log in again"}
+		_ = utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+// restoreErrorJSON answers a failed account restore.
+//
+// Returns:
+// - bool: True if err was not nil and an error response was written.
+func restoreErrorJSON(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, repositories.ErrUserNotDeleted):
+		utils.ErrorJSON(w, err, http.StatusConflict)
+	case errors.Is(err, application.ErrRestoreWindowOver):
+		utils.ErrorJSON(w, err, http.StatusGone)
+	default:
+		utils.ErrorJSON(w, err)
+	}
+	return true
+}
+
+// accountBlockedJSON answers a login of an account that can not log in with 403. The
+// data holds the account status as a code, e.g. pending_approval, so clients can tell
+// the cases apart without parsing the message.
+//
+// Parameters:
+// - w: The HTTP response writer.
+// - user: The user logging in.
+// - err: The error of AccountBlocked.
+func accountBlockedJSON(w http.ResponseWriter, user *models.User, err error) {
+	response := utils.JSONResponse{
+		Error:   true,
+		Message: err.Error(),
+		Data:    map[string]string{"code": user.CurrentStatus()},
+	}
+	_ = utils.WriteJSON(w, http.StatusForbidden, response)
+}
+
+// registrationErrorJSON answers a registration rejected by the registration mode.
+//
+// Parameters:
+// - w: The HTTP response writer.
+// - err: The error of AdmitRegistration.
+//
+// Returns:
+// - bool: True if a response was written.
+func registrationErrorJSON(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, application.ErrRegistrationClosed), errors.Is(err, application.ErrInviteRequired),
+		errors.Is(err, application.ErrEmailDomainNotAllowed), errors.Is(err, repositories.ErrInvalidInvite):
+		utils.ErrorJSON(w, err, http.StatusForbidden)
+	default:
+		utils.ErrorJSON(w, err)
+	}
+	return true
+}
+
+// tooManyAttemptsJSON writes 429 with a Retry-After header for a throttled login.
+//
+// Parameters:
+// - w: The HTTP response writer.
+// - retryAfter: How long the client has to wait.
+func tooManyAttemptsJSON(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	utils.ErrorJSON(w, errors.New("too many failed login attempts, try again later"), http.StatusTooManyRequests)
+}
+
 // RefreshToken handles the process of refreshing a user's JWT tokens using the refresh token.
 // It reads the refresh token from cookies, verifies it, and generates a new token pair.
 //
@@ -117,13 +340,8 @@ func RefreshToken(app *application.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		for _, cookie := range r.Cookies() {
 			if cookie.Name == app.Auth.CookieName {
-				claims := &auth.Claims{}
-				refreshToken := cookie.Value
-
-				// Parse and verify the refresh token
-				_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-					return []byte(app.JWTSecret), nil
-				})
+				// Parse and verify the refresh token, access tokens are rejected
+				claims, err := app.Auth.VerifyRefreshToken(cookie.Value)
 				if err != nil {
 					utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
 					return
@@ -137,21 +355,34 @@ func RefreshToken(app *application.Application) http.HandlerFunc {
 					return
 				}
 
-				// Generate new token pairs
-				u := auth.JwtUser{
-					ID:        user.ID,
-					UserName:  user.UserName,
-					FirstName: user.FirstName,
-					LastName:  user.LastName,
+				// Refresh tokens issued before the sessions were revoked are no longer valid
+				if claims.IssuedAt == nil || user.TokenRevoked(claims.IssuedAt.Time) {
+					utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+					return
+				}
+
+				// Deleted, suspended and locked accounts can not renew their sessions
+				if err := app.AccountBlocked(user); err != nil {
+					accountBlockedJSON(w, user, err)
+					return
+				}
+
+				// The confirmation policy may have ended the grace period since the login
+				if err := app.LoginAllowed(user, app.EmailConfirmed(user)); err != nil {
+					utils.ErrorJSON(w, err, http.StatusForbidden)
+					return
 				}
-				tokenPairs, err := app.Auth.GenerateTokenPair(&u)
+
+				// Generate new token pairs
+				tokenPairs, err := issueTokens(app, user)
 				if err != nil {
 					utils.ErrorJSON(w, errors.New("error generating tokens"), http.StatusUnauthorized)
 					return
 				}
 
-				// Set new refresh token in the cookie
+				// Set new refresh and access tokens in the cookies
 				http.SetCookie(w, app.Auth.GetRefreshCookie(tokenPairs.RefreshToken))
+				http.SetCookie(w, app.Auth.GetAccessCookie(tokenPairs.Token))
 
 				utils.WriteJSON(w, http.StatusOK, tokenPairs)
 			}
@@ -159,7 +390,45 @@ func RefreshToken(app *application.Application) http.HandlerFunc {
 	}
 }
 
-// Logout handles user logout by expiring the refresh token cookie and returning a success response.
+// issueTokens generates the token pair for a user. Users who have to change their
+// password, because it expired or was found in a breach list, get tokens that are
+// restricted to the change password route. The email_verified claim tells services
+// whether the user confirmed the email address.
+//
+// Parameters:
+// - app: A pointer to the application context.
+// - user: The authenticated user, with the mode loaded.
+//
+// Returns:
+// - auth.TokenPairs: The signed tokens.
+// - error: An error if the tokens fail to be generated.
+func issueTokens(app *application.Application, user *models.User) (auth.TokenPairs, error) {
+	u := auth.JwtUser{
+		ID:        user.ID,
+		UserName:  user.UserName,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Mode:      user.Mode.Name,
+		Scopes:    app.ScopesForMode(user.Mode.Name),
+		// Services decide themselves what unconfirmed users may do
+		EmailVerified: app.EmailConfirmed(user),
+	}
+
+	changeRequired := app.PasswordChangeRequired(user)
+	if changeRequired {
+		u.Scopes = nil
+		u.Restriction = verifier.RestrictionPasswordChange
+	}
+
+	tokens, err := app.Auth.GenerateTokenPair(&u)
+	if err != nil {
+		return auth.TokenPairs{}, err
+	}
+	tokens.PasswordRotationRequired = changeRequired
+	return tokens, nil
+}
+
+// Logout handles user logout by expiring the refresh and access token cookies and returning a success response.
 //
 // Parameters:
 // - app: A pointer to the application context containing authentication logic.
@@ -169,6 +438,7 @@ func RefreshToken(app *application.Application) http.HandlerFunc {
 func Logout(app *application.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		http.SetCookie(w, app.Auth.GetExpiredRefreshCookie())
+		http.SetCookie(w, app.Auth.GetExpiredAccessCookie())
 		w.WriteHeader(http.StatusAccepted)
 		response := utils.JSONResponse{
 			Message: "successfully logged out",
@@ -178,8 +448,9 @@ func Logout(app *application.Application) http.HandlerFunc {
 }
 
 // RegisterNewUser handles the process of registering a new user.
-// It reads the user data from the request body, hashes the user's password,
-// saves the user to the database, and sends a confirmation email.
+// It checks the registration mode, reads the user data from the request body, hashes
+// the user's password, saves the user to the database, and sends a confirmation email.
+// An invite_code in the body uses up an invite, which may assign the user's mode.
 //
 // Parameters:
 // - app: A pointer to the application context containing repositories and services.
@@ -190,36 +461,73 @@ func RegisterNewUser(app *application.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		// Load environment variables
-		err := godotenv.Load()
+		_ = godotenv.Load()
+
+		// Parse the request body into a new User model
+		payload := struct {
+			models.User
+			InviteCode string `json:"invite_code"`
+		}{User: models.User{ID: uuid.NewString(), CreatedAt: time.Now()}}
+		err := utils.ReadJSON(w, r, &payload)
 		if err != nil {
 			utils.ErrorJSON(w, err)
 			return
 		}
+		newUser := payload.User
 
-		// Parse the request body into a new User model
-		newUser := models.User{ID: uuid.NewString(), CreatedAt: time.Now()}
-		err = utils.ReadJSON(w, r, &newUser)
+		// Check the registration mode, an invite is used up until the registration fails
+		invite, err := app.AdmitRegistration(newUser.Email, payload.InviteCode)
+		if registrationErrorJSON(w, err) {
+			return
+		}
+		registered := false
+		defer func() {
+			if !registered {
+				app.ReleaseInvite(invite)
+			}
+		}()
+
+		// Check the password against the password policy and the breach list
+		err = app.ValidatePassword(newUser.Password, newUser.UserName, newUser.Email)
 		if err != nil {
-			utils.ErrorJSON(w, err)
+			passwordErrorJSON(w, err)
 			return
 		}
 
 		// Hash the user's password
 		hashedPassword, err := controllers.HashAPassword(newUser.Password)
 		if err != nil {
-			utils.ErrorJSON(w, err)
+			passwordErrorJSON(w, err)
 			return
 		}
 
 		newUser.Password = hashedPassword
 		newUser.CreatedAt = time.Now()
+		newUser.PasswordChangedAt = newUser.CreatedAt
+		newUser.PasswordRotationRequired = false
+		newUser.PasswordExpiryWarnedAt = nil
+		newUser.DeletedAt = time.Time{}
+		newUser.Status, err = app.RegistrationStatus(invite)
+		if err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+		newUser.StatusReason = ""
+		newUser.StatusChangedBy = ""
+		newUser.StatusChangedAt = nil
+		newUser.StatusUntil = nil
 
 		// Save the user to the database
 		userID, err := app.Repository.CreateUser(&newUser)
+		if errors.Is(err, repositories.ErrUserExists) || errors.Is(err, repositories.ErrUserNameTaken) {
+			utils.ErrorJSON(w, err, http.StatusConflict)
+			return
+		}
 		if err != nil {
 			utils.ErrorJSON(w, err)
 			return
 		}
+		registered = true
 
 		filename, profilePath, err := controllers.UploadDefaultProfile(app.Root, userID)
 		if err != nil {
@@ -235,32 +543,6 @@ func RegisterNewUser(app *application.Application) http.HandlerFunc {
 			return
 		}
 
-		// Return success response with user ID
-		response := utils.JSONResponse{
-			Error:   false,
-			Message: "user successfully created",
-			Data:    userID,
-		}
-		_ = utils.WriteJSON(w, http.StatusCreated, response)
-
-		// Create a new user confirmation record
-		newConfirmation := models.UserConfirmation{
-			ID:        uuid.NewString(),
-			CreatedAt: time.Now(),
-			Confirmed: false,
-			ExpiredAt: time.Now().UTC().Add(30 * time.Minute).Unix(),
-			UserID:    userID,
-		}
-
-		fmt.Println("Is Expired: ", newConfirmation.IsExpired())
-
-		// Insert the confirmation into the database
-		_, err = app.Repository.InsertConfirmation(&newConfirmation)
-		if err != nil {
-			utils.ErrorJSON(w, err)
-			return
-		}
-
 		users, err := app.Repository.GetAllUsers()
 		if err != nil {
 			utils.ErrorJSON(w, err)
@@ -275,6 +557,9 @@ func RegisterNewUser(app *application.Application) http.HandlerFunc {
 		} else {
 			modeName = "admin"
 		}
+		if invite != nil && invite.ModeName != "" {
+			modeName = invite.ModeName
+		}
 
 		// Create a mode record for the user
 		userMode := models.Mode{
@@ -288,14 +573,21 @@ func RegisterNewUser(app *application.Application) http.HandlerFunc {
 			return
 		}
 
-		// Send confirmation email via Mailgun
-		apiKey := os.Getenv("MAIL_SERVER_API_KEY")
-		domain := os.Getenv("MAIL_SERVER_DOMAIN")
+		// Return success response with user ID
+		message := "user successfully created"
+		if newUser.Status == models.UserStatusPendingApproval {
+			message = "user successfully created, the account can be used once an admin approved it"
+		}
+		response := utils.JSONResponse{
+			Error:   false,
+			Message: message,
+			Data:    userID,
+		}
+		_ = utils.WriteJSON(w, http.StatusCreated, response)
 
-		_, err = controllers.SendEmail(domain, apiKey, newUser.Email, newUser.UserName, userID, "confirmation", 1)
-		if err != nil {
-			utils.ErrorJSON(w, err)
-			return
+		// Send the confirmation link via Mailgun
+		if err := app.SendConfirmation(&newUser); err != nil {
+			log.Printf("error sending the confirmation email to user %s: %v", userID, err)
 		}
 	}
 }
@@ -0,0 +1,389 @@
+package handlers_test
+
+import (
+	"TriceraPass/internal/passwords"
+	"TriceraPass/internal/ratelimit"
+	"TriceraPass/internal/store"
+	"TriceraPass/internal/testenv"
+	"TriceraPass/pkg/client"
+	"TriceraPass/pkg/verifier"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Test that outdated password hashes are upgraded on login
+func TestRehashOnLogin(t *testing.T) {
+	env := testenv.New(t)
+	previous := passwords.Default()
+	t.Cleanup(func() { passwords.SetDefault(previous) })
+
+	bcryptHasher, _ := passwords.FromConfig(passwords.Config{Algorithm: "bcrypt", Bcrypt: passwords.BcryptParams{Cost: 4}})
+	passwords.SetDefault(bcryptHasher)
+	userID := env.Register(t, "wu", "dino-dna-1993")
+
+	argonHasher, _ := passwords.FromConfig(passwords.Config{Algorithm: "argon2id"})
+	passwords.SetDefault(argonHasher)
+	env.Login(t, "wu", "dino-dna-1993")
+
+	hash, err := env.App.Repository.GetUserPasswordByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Fatalf("expected the hash to be upgraded to argon2id, got %q", hash)
+	}
+
+	// The upgraded hash keeps working
+	env.Login(t, "wu", "dino-dna-1993")
+}
+
+// Test the backoff and lockout after failed logins and both ways to unlock, with the
+// state in memory and in Redis
+func TestAccountLockout(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testAccountLockout(t, testenv.New(t))
+	})
+	t.Run("redis", func(t *testing.T) {
+		env := testenv.New(t)
+		rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		t.Cleanup(func() { _ = rdb.Close() })
+		env.App.Store = store.NewRedis(rdb, "test:")
+		testAccountLockout(t, env)
+	})
+}
+
+func testAccountLockout(t *testing.T, env *testenv.Env) {
+	ctx := context.Background()
+
+	lockout := &env.App.Config.Security.Lockout
+	lockout.Enabled = true
+	lockout.FreeAttempts = 1
+	lockout.BaseDelay = time.Hour
+	lockout.MaxAttempts = 3
+	lockout.IPMaxAttempts = 100
+	lockout.LockoutDuration = time.Hour
+
+	env.Register(t, "hammond", "spared-no-expense")
+	userID := env.Register(t, "nedry", "ah-ah-ah-magic-word")
+	admin := env.Login(t, "hammond", "spared-no-expense")
+	c := client.New(env.Server.URL)
+
+	// Real and unknown accounts answer the same way, first with 401 then with the backoff
+	for _, email := range []string{"nedry@jurassic.park", "nobody@jurassic.park"} {
+		for i := 0; i < 2; i++ {
+			if _, err := c.Login(ctx, email, "wrong-password"); !errors.Is(err, client.ErrUnauthorized) {
+				t.Fatalf("%s: expected attempt %d to be rejected with 401, got %v", email, i+1, err)
+			}
+		}
+		_, err := c.Login(ctx, email, "wrong-password")
+		var apiErr *client.APIError
+		if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrTooManyRequests) || apiErr.RetryAfter <= 0 {
+			t.Fatalf("%s: expected the backoff to answer 429 with Retry-After, got %v", email, err)
+		}
+	}
+
+	// The next failure locks both, even the right password is rejected then
+	lockout.BaseDelay = 0
+	for _, email := range []string{"nedry@jurassic.park", "nobody@jurassic.park"} {
+		if _, err := c.Login(ctx, email, "wrong-password"); !errors.Is(err, client.ErrUnauthorized) {
+			t.Fatalf("%s: expected the third failure to be rejected with 401, got %v", email, err)
+		}
+		if _, err := c.Login(ctx, email, "wrong-password"); !errors.Is(err, client.ErrTooManyRequests) {
+			t.Fatalf("%s: expected the lockout, got %v", email, err)
+		}
+	}
+	if _, err := c.Login(ctx, "nedry@jurassic.park", "ah-ah-ah-magic-word"); !errors.Is(err, client.ErrTooManyRequests) {
+		t.Fatalf("expected the locked account to reject the right password, got %v", err)
+	}
+	if _, err := c.Login(ctx, " Nedry@Jurassic.Park. ", "ah-ah-ah-magic-word"); !errors.Is(err, client.ErrTooManyRequests) {
+		t.Fatalf("expected another spelling of the address to share the lockout, got %v", err)
+	}
+
+	// Only the real account gets an unlock email
+	var token string
+	deadline := time.Now().Add(10 * time.Second)
+	for token == "" && time.Now().Before(deadline) {
+		for _, email := range env.Mail.Sent() {
+			if strings.Contains(email.To, "nobody") {
+				t.Fatal("expected no email to an unknown address")
+			}
+			if strings.HasPrefix(email.Subject, "Your Account Was Locked") {
+				if !strings.Contains(email.HTML, "http://localhost:1993/auth/api/unlock?token=") {
+					t.Errorf("expected the default unlock link, got %s", email.HTML)
+				}
+				token = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(email.HTML)[1]
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if token == "" {
+		t.Fatal("expected an unlock email")
+	}
+
+	// Opening the link only shows the page, the unlock needs the POST
+	resp, err := http.Get(env.Server.URL + "/auth/api/unlock?token=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), token) {
+		t.Errorf("expected the unlock page with the token, got %d", resp.StatusCode)
+	}
+	if _, err := c.Login(ctx, "nedry@jurassic.park", "ah-ah-ah-magic-word"); !errors.Is(err, client.ErrTooManyRequests) {
+		t.Fatalf("expected opening the link to keep the account locked, got %v", err)
+	}
+
+	if err := c.UnlockAccount(ctx, token); err != nil {
+		t.Fatalf("Error unlocking: %v", err)
+	}
+	if err := c.UnlockAccount(ctx, token); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected the unlock token to work once, got %v", err)
+	}
+	env.Login(t, "nedry", "ah-ah-ah-magic-word")
+
+	// Admins can unlock as well
+	for i := 0; i < 4; i++ {
+		_, _ = c.Login(ctx, "nedry@jurassic.park", "wrong-password")
+	}
+	if _, err := c.Login(ctx, "nedry@jurassic.park", "ah-ah-ah-magic-word"); !errors.Is(err, client.ErrTooManyRequests) {
+		t.Fatalf("expected the account to be locked again, got %v", err)
+	}
+	if err := admin.UnlockUser(ctx, userID); err != nil {
+		t.Fatalf("Error unlocking as admin: %v", err)
+	}
+	env.Login(t, "nedry", "ah-ah-ah-magic-word")
+
+	// Too many failures from one IP address block every account
+	lockout.IPMaxAttempts = 1
+	_, _ = c.Login(ctx, "grant@jurassic.park", "wrong-password")
+	if _, err := c.Login(ctx, "hammond@jurassic.park", "spared-no-expense"); !errors.Is(err, client.ErrTooManyRequests) {
+		t.Errorf("expected the IP address to be locked, got %v", err)
+	}
+}
+
+// Test the default and the stricter route limits, keyed by IP address and by user
+func TestRateLimit(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	env.Register(t, "hammond", "spared-no-expense")
+	env.Register(t, "nedry", "ah-ah-ah-magic-word")
+	admin := env.Login(t, "hammond", "spared-no-expense")
+	nedry := env.Login(t, "nedry", "ah-ah-ah-magic-word")
+
+	limits := &env.App.Config.API.RateLimit
+	limits.Routes = map[string]ratelimit.Rule{"login": {RequestsPerMinute: 1, Burst: 2}}
+
+	login := func(forwardedFor string) *http.Response {
+		req, _ := http.NewRequest("POST", env.Server.URL+"/auth/api/login",
+			strings.NewReader(`{"email":"hammond@jurassic.park","password":"wrong-password"}`))
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		resp := login("")
+		if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("RateLimit-Remaining") != strconv.Itoa(1-i) {
+			t.Fatalf("attempt %d: expected 401 with %d remaining, got %d %q", i+1, 1-i, resp.StatusCode, resp.Header.Get("RateLimit-Remaining"))
+		}
+	}
+	resp := login("")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "60" || resp.Header.Get("RateLimit-Limit") != "2" {
+		t.Fatalf("expected 429 with Retry-After 60, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// Only the login is limited, and X-Forwarded-For counts only behind a trusted proxy
+	if _, err := admin.ListUsers(ctx); err != nil {
+		t.Errorf("expected other routes to pass, got %v", err)
+	}
+	if resp := login("198.51.100.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected X-Forwarded-For of an untrusted client to be ignored, got %d", resp.StatusCode)
+	}
+	limits.TrustedProxies = []string{"127.0.0.1"}
+	if resp := login("198.51.100.1"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the forwarded address to have its own bucket, got %d", resp.StatusCode)
+	}
+
+	// The default limit keyed by user gives every user a bucket
+	limits.RequestsPerMinute = 1
+	limits.Burst = 1
+	limits.KeyBy = ratelimit.KeyByUser
+	if _, err := admin.ListUsers(ctx); err != nil {
+		t.Fatalf("expected the first request to pass, got %v", err)
+	}
+	_, err := admin.ListUsers(ctx)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrTooManyRequests) || apiErr.RetryAfter != time.Minute {
+		t.Fatalf("expected the second request to be limited, got %v", err)
+	}
+	if _, err := nedry.ListUsers(ctx); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected another user to pass the limit, got %v", err)
+	}
+}
+
+// Test the email confirmation policies, the email_verified claim and the resend endpoint
+func TestEmailConfirmationPolicy(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	c := client.New(env.Server.URL)
+	confirmation := &env.App.Config.Security.EmailConfirmation
+
+	env.Register(t, "hammond", "spared-no-expense")
+	env.Register(t, "nedry", "ah-ah-ah-magic-word")
+	if err := c.ConfirmEmail(ctx, testenv.ConfirmationToken(t, env.Mail.WaitFor(t, 2), "hammond@jurassic.park")); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := verifier.New(verifier.Config{Secret: "test-secret", Issuer: "test-issuer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailVerified := func(name, password string) bool {
+		t.Helper()
+		claims, err := v.Verify(env.Login(t, name, password).Tokens().Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims.EmailVerified
+	}
+
+	// By default everyone logs in and the claim tells who is confirmed
+	if !emailVerified("hammond", "spared-no-expense") || emailVerified("nedry", "ah-ah-ah-magic-word") {
+		t.Fatal("expected only hammond to be verified")
+	}
+
+	confirmation.Policy = "block"
+	_, err = c.Login(ctx, "nedry@jurassic.park", "ah-ah-ah-magic-word")
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("expected the unconfirmed login to be blocked, got %v", err)
+	}
+	if _, err := c.Login(ctx, "nedry@jurassic.park", "wrong-password"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected a wrong password to be rejected first, got %v", err)
+	}
+	env.Login(t, "hammond", "spared-no-expense")
+
+	confirmation.Policy = "grace"
+	confirmation.GracePeriod = time.Hour
+	env.Login(t, "nedry", "ah-ah-ah-magic-word")
+	confirmation.GracePeriod = time.Nanosecond
+	if _, err := c.Login(ctx, "nedry@jurassic.park", "ah-ah-ah-magic-word"); !errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("expected the login after the grace period to be blocked, got %v", err)
+	}
+
+	// The registration email is too recent for a resend
+	confirmation.ResendInterval = time.Hour
+	for _, email := range []string{"nedry@jurassic.park", "hammond@jurassic.park", "nobody@jurassic.park"} {
+		if err := c.ResendConfirmation(ctx, email); err != nil {
+			t.Fatalf("Error resending to %s: %v", email, err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if sent := env.Mail.Sent(); len(sent) != 2 {
+		t.Fatalf("expected no new emails, got %d", len(sent)-2)
+	}
+
+	confirmation.ResendInterval = time.Millisecond
+	for _, email := range []string{"nedry@jurassic.park", "hammond@jurassic.park", "nobody@jurassic.park"} {
+		if err := c.ResendConfirmation(ctx, email); err != nil {
+			t.Fatalf("Error resending to %s: %v", email, err)
+		}
+	}
+	sent := env.Mail.WaitFor(t, 3)
+	time.Sleep(200 * time.Millisecond)
+	if sent = env.Mail.Sent(); len(sent) != 3 || sent[2].To != "nedry@jurassic.park" {
+		t.Fatalf("expected one new confirmation email to nedry, got %+v", sent[2:])
+	}
+
+	if err := c.ConfirmEmail(ctx, testenv.ConfirmationToken(t, sent[2:], "nedry@jurassic.park")); err != nil {
+		t.Fatal(err)
+	}
+	if !emailVerified("nedry", "ah-ah-ah-magic-word") {
+		t.Error("expected nedry to be verified and let in after the confirmation")
+	}
+}
+
+// Test that email addresses and usernames are unique regardless of the case, also for concurrent registrations
+func TestUniqueEmailsAndUserNames(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	register := func(name, email string) (string, error) {
+		return client.New(env.Server.URL).Register(ctx, client.RegisterRequest{
+			UserName: name, FirstName: name, LastName: "Test", Email: email, Password: "life-finds-a-way",
+		})
+	}
+
+	grantID, err := register("Grant", " Grant@Jurassic.Park ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := env.App.Repository.GetUserByID(grantID); err != nil || user.Email != "grant@jurassic.park" {
+		t.Errorf("expected the email address to be stored normalized, got %+v, error: %v", user, err)
+	}
+	c := client.New(env.Server.URL)
+	if _, err := c.Login(ctx, "GRANT@jurassic.park", "life-finds-a-way"); err != nil {
+		t.Errorf("expected the login to ignore the case of the email address, got %v", err)
+	}
+
+	if _, err := register("alan", "grant@JURASSIC.park"); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a taken email address to be a conflict, got %v", err)
+	}
+	if _, err := register("gRANT", "alan@jurassic.park"); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a taken username to be a conflict, got %v", err)
+	}
+
+	// Only one of several concurrent registrations of an address succeeds
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = register(fmt.Sprintf("malcolm%d", i), "Malcolm@jurassic.park")
+		}(i)
+	}
+	wg.Wait()
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, client.ErrConflict):
+			t.Errorf("expected concurrent registrations to be conflicts, got %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("expected exactly one concurrent registration to succeed, got %d", created)
+	}
+
+	malcolm, err := env.App.Repository.GetUserByEmail("malcolm@jurassic.park")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateUser(ctx, grantID, client.UpdateUserRequest{UserName: strings.ToUpper(malcolm.UserName)}); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a taken username to be a conflict on update, got %v", err)
+	}
+	if err := c.UpdateUser(ctx, grantID, client.UpdateUserRequest{UserName: "Alan"}); err != nil {
+		t.Errorf("expected a free username to be accepted, got %v", err)
+	}
+}
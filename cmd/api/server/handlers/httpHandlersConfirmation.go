@@ -3,7 +3,11 @@ package handlers
 import (
 	"TriceraPass/cmd/api/application"
 	"TriceraPass/cmd/api/utils"
+	"bytes"
+	"errors"
 	"fmt"
+	"html/template"
+	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -57,48 +61,91 @@ func GetMostRecentConfirmation(app *application.Application) http.HandlerFunc {
 	}
 }
 
-// ConfirmUser confirms a user's account if the confirmation is valid and has not expired.
+// ConfirmEmail confirms an email address with the token from the confirmation email.
 //
 // Parameters:
 // - app: A pointer to the application context containing repositories.
 //
 // Returns:
-// - http.HandlerFunc: An HTTP handler function that confirms a user's account.
-func ConfirmUser(app *application.Application) http.HandlerFunc {
+// - http.HandlerFunc: An HTTP handler function that confirms a user's email address.
+func ConfirmEmail(app *application.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID := chi.URLParam(r, "user_id")
+		var requestPayload struct {
+			Token string `json:"token"`
+		}
+		if err := utils.ReadJSON(w, r, &requestPayload); err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
 
-		// Fetch the most recent confirmation for the user
-		confirmation, err := app.Repository.GetLastConfirmation(userID)
+		state, err := app.ConfirmEmail(requestPayload.Token)
 		if err != nil {
-			utils.ErrorJSON(w, fmt.Errorf("error 1 - %v", err))
+			utils.ErrorJSON(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		// Check if the confirmation is valid and not expired
-		fmt.Println(confirmation.IsExpired())
-		if confirmation != nil && !confirmation.IsExpired() {
-			if confirmation.Confirmed {
-				utils.ErrorJSON(w, fmt.Errorf("user is already confirmed"))
-				return
-			}
+		switch state {
+		case application.ConfirmationConfirmed:
+			response := utils.JSONResponse{Message: "email address confirmed"}
+			_ = utils.WriteJSON(w, http.StatusOK, response)
+		case application.ConfirmationAlreadyConfirmed:
+			utils.ErrorJSON(w, errors.New("email address is already confirmed"), http.StatusConflict)
+		case application.ConfirmationExpired:
+			utils.ErrorJSON(w, errors.New("confirmation link expired, request a new confirmation email"), http.StatusGone)
+		default:
+			utils.ErrorJSON(w, errors.New("invalid confirmation link"), http.StatusBadRequest)
+		}
+	}
+}
 
-			// Mark the user as confirmed and expire the confirmation
-			confirmation.Confirmed = true
-			confirmation.SetExpire()
+// ConfirmationPage confirms an email address with the token of the link in the
+// confirmation email and renders the outcome as an HTML page.
+//
+// Parameters:
+// - app: A pointer to the application context containing configuration and repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function for the confirmation link.
+func ConfirmationPage(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := app.ConfirmEmail(r.URL.Query().Get("token"))
+		if err != nil {
+			log.Printf("error confirming an email address: %v", err)
+			http.Error(w, "Error confirming the email address", http.StatusInternalServerError)
+			return
+		}
 
-			err = app.Repository.ConfirmUser(confirmation.ID, confirmation)
-			if err != nil {
-				utils.ErrorJSON(w, fmt.Errorf("error 2 - %v", err))
-				return
-			}
+		tmpl, err := template.ParseFiles("./template/confirmation.html")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error parsing template: %v", err), http.StatusInternalServerError)
+			return
+		}
 
-			fmt.Println(confirmation.IsExpired())
+		status := http.StatusOK
+		switch state {
+		case application.ConfirmationExpired:
+			status = http.StatusGone
+		case application.ConfirmationInvalid:
+			status = http.StatusBadRequest
+		}
 
-			// Return a success message
-			response := utils.JSONResponse{Message: "user confirmed successfully"}
-			_ = utils.WriteJSON(w, http.StatusOK, response)
+		data := struct {
+			Config *application.Config
+			State  string
+		}{
+			Config: app.Config,
+			State:  state,
+		}
+
+		var page bytes.Buffer
+		if err := tmpl.Execute(&page, data); err != nil {
+			http.Error(w, "Error rendering template", http.StatusInternalServerError)
+			return
 		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = page.WriteTo(w)
 	}
 }
 
@@ -125,3 +172,34 @@ func GetLastConfirmation(app *application.Application) http.HandlerFunc {
 		_ = utils.WriteJSON(w, http.StatusOK, response)
 	}
 }
+
+// ResendConfirmation sends a new confirmation email to an unconfirmed account. The
+// answer is the same whether or not the account exists, and the email is sent at most
+// once per resend interval.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function for the resend confirmation route.
+func ResendConfirmation(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestPayload struct {
+			Email string `json:"email"`
+		}
+		if err := utils.ReadJSON(w, r, &requestPayload); err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+
+		// Send in the background so the response time does not reveal the account
+		go func() {
+			if err := app.ResendConfirmation(requestPayload.Email); err != nil {
+				log.Printf("error resending the confirmation email: %v", err)
+			}
+		}()
+
+		response := utils.JSONResponse{Message: "if the account exists and is not confirmed, a new confirmation email is on its way"}
+		_ = utils.WriteJSON(w, http.StatusAccepted, response)
+	}
+}
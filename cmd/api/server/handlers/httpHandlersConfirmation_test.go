@@ -0,0 +1,73 @@
+package handlers_test
+
+import (
+	"TriceraPass/internal/testenv"
+	"TriceraPass/pkg/client"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the confirmation link: the landing page states, expired and reused tokens
+func TestConfirmationLink(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	c := client.New(env.Server.URL)
+
+	userID := env.Register(t, "sattler", "life-finds-a-way")
+	token := testenv.ConfirmationToken(t, env.Mail.WaitFor(t, 1), "sattler@jurassic.park")
+
+	page := func(token string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(env.Server.URL + "/auth/api/confirmation?token=" + token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := page("not-a-token"); status != http.StatusBadRequest || !strings.Contains(body, `id="invalid"`) {
+		t.Errorf("expected the invalid page, got %d", status)
+	}
+
+	// The stored hash does not confirm either
+	confirmation, err := env.App.Repository.GetLastConfirmation(userID)
+	if err != nil || confirmation.TokenHash == "" || confirmation.TokenHash == token {
+		t.Fatalf("expected only the hash of the token to be stored, got %+v, error: %v", confirmation, err)
+	}
+	if err := c.ConfirmEmail(ctx, confirmation.TokenHash); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected the hash to be rejected, got %v", err)
+	}
+
+	// Expired links offer a new one
+	env.App.Repository.DB.Model(confirmation).Update("expired_at", time.Now().Add(-time.Minute).Unix())
+	if status, body := page(token); status != http.StatusGone || !strings.Contains(body, `id="expired"`) || !strings.Contains(body, "resendForm") {
+		t.Errorf("expected the expired page with a resend form, got %d", status)
+	}
+	var apiErr *client.APIError
+	if err := c.ConfirmEmail(ctx, token); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusGone {
+		t.Errorf("expected an expired token to be rejected with 410, got %v", err)
+	}
+
+	env.App.Config.Security.EmailConfirmation.ResendInterval = time.Millisecond
+	if err := c.ResendConfirmation(ctx, "sattler@jurassic.park"); err != nil {
+		t.Fatal(err)
+	}
+	token = testenv.ConfirmationToken(t, env.Mail.WaitFor(t, 2), "sattler@jurassic.park")
+
+	if status, body := page(token); status != http.StatusOK || !strings.Contains(body, `id="confirmed"`) {
+		t.Errorf("expected the success page, got %d", status)
+	}
+	if status, body := page(token); status != http.StatusOK || !strings.Contains(body, `id="already_confirmed"`) {
+		t.Errorf("expected the already confirmed page, got %d", status)
+	}
+	if err := c.ConfirmEmail(ctx, token); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a used token to be rejected with 409, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"TriceraPass/cmd/api/application"
+	"TriceraPass/cmd/api/utils"
+	"TriceraPass/pkg/verifier"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// RequestDataExport starts building an archive of the caller's data. The download link
+// is emailed once it is ready.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that requests a data export.
+func RequestDataExport(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := verifier.FromContext(r.Context())
+		if !ok {
+			utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		user, err := app.Repository.GetUserByID(claims.Subject)
+		if err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+
+		exportID, err := app.RequestDataExport(user)
+		if errors.Is(err, application.ErrExportPending) {
+			utils.ErrorJSON(w, err, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		resp := utils.JSONResponse{
+			Message: "data export requested, the download link will be emailed once it is ready",
+			Data:    map[string]string{"export_id": exportID},
+		}
+		_ = utils.WriteJSON(w, http.StatusAccepted, resp)
+	}
+}
+
+// DownloadDataExport serves the archive of the emailed download link.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that serves a data export.
+func DownloadDataExport(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		export, err := app.DataExportFile(r.URL.Query().Get("token"))
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusGone)
+			return
+		}
+
+		file, err := os.Open(export.FilePath)
+		if err != nil {
+			utils.ErrorJSON(w, errors.New("the data export is no longer available"), http.StatusGone)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "data-export-"+export.CreatedAt.UTC().Format("2006-01-02")+".zip"))
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, "", info.ModTime(), file)
+	}
+}
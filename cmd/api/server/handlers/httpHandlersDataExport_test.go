@@ -0,0 +1,99 @@
+package handlers_test
+
+import (
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/testenv"
+	"TriceraPass/pkg/client"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the data export: the archive entries, the pending conflict and the link expiry
+func TestDataExport(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	env.Register(t, "malcolm", "chaos-theory")
+	env.Mail.WaitFor(t, 1)
+	c := env.Login(t, "malcolm", "chaos-theory")
+
+	if _, err := c.RequestDataExport(ctx); err != nil {
+		t.Fatal(err)
+	}
+	token := testenv.MailToken(t, env.Mail.WaitFor(t, 2), "malcolm@jurassic.park", "Your Data Export Is Ready")
+
+	data, err := c.DownloadDataExport(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]*zip.File{}
+	for _, file := range archive.File {
+		entries[file.Name] = file
+	}
+	for _, name := range []string{"profile.json", "mode.json", "confirmations.json", "password_reset_tokens.json", "sessions.json", "audit_events.json"} {
+		if entries[name] == nil {
+			t.Errorf("expected %s in the archive", name)
+		}
+	}
+	hasImage := false
+	for name := range entries {
+		hasImage = hasImage || strings.HasPrefix(name, "profile_image/")
+	}
+	if !hasImage {
+		t.Error("expected the profile image in the archive")
+	}
+
+	profile, err := entries["profile.json"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.NewDecoder(profile).Decode(&fields); err != nil {
+		t.Fatal(err)
+	}
+	profile.Close()
+	if fields["email"] != "malcolm@jurassic.park" {
+		t.Errorf("expected the email in the profile, got %v", fields["email"])
+	}
+	if _, ok := fields["password"]; ok {
+		t.Error("expected no password hash in the profile")
+	}
+
+	// A second export can be requested once the first one is built, not while it is pending
+	exportID, err := c.RequestDataExport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Mail.WaitFor(t, 3)
+	if err := env.App.Repository.DB.Model(&models.DataExport{}).Where("id = ?", exportID).
+		Update("status", models.DataExportPending).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RequestDataExport(ctx); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a pending export to be rejected, got %v", err)
+	}
+
+	// Unknown and expired links are rejected
+	if _, err := c.DownloadDataExport(ctx, "unknown"); err == nil {
+		t.Error("expected an unknown token to be rejected")
+	}
+	if err := env.App.Repository.DB.Model(&models.DataExport{}).Where("status = ?", models.DataExportReady).
+		Update("expired_at", time.Now().Add(-time.Minute).Unix()).Error; err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *client.APIError
+	if _, err := c.DownloadDataExport(ctx, token); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusGone {
+		t.Errorf("expected an expired link to be rejected, got %v", err)
+	}
+}
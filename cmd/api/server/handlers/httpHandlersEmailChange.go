@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"TriceraPass/cmd/api/application"
+	"TriceraPass/cmd/api/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// ConfirmEmailChange swaps the email address with the token sent to the new address.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that confirms an email change.
+func ConfirmEmailChange(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestPayload struct {
+			Token string `json:"token"`
+		}
+		if err := utils.ReadJSON(w, r, &requestPayload); err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+
+		state, err := app.ConfirmEmailChange(requestPayload.Token)
+		writeEmailChangeState(w, state, err)
+	}
+}
+
+// RevertEmailChange restores the old email address with the token sent to it and logs
+// the user out everywhere.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that reverts an email change.
+func RevertEmailChange(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestPayload struct {
+			Token string `json:"token"`
+		}
+		if err := utils.ReadJSON(w, r, &requestPayload); err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+
+		state, err := app.RevertEmailChange(requestPayload.Token)
+		writeEmailChangeState(w, state, err)
+	}
+}
+
+// ConfirmEmailChangePage renders the page of the link sent to the new address. Opening
+// the link changes nothing, so link scanners of mail providers can not use it up: the
+// page posts the token to ConfirmEmailChange once the user confirms.
+//
+// Parameters:
+// - app: A pointer to the application context containing configuration.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function for the confirmation link.
+func ConfirmEmailChangePage(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderEmailChangePage(w, app, "confirm", r.URL.Query().Get("token"))
+	}
+}
+
+// RevertEmailChangePage renders the page of the link sent to the old address, which
+// posts the token to RevertEmailChange once the user confirms.
+//
+// Parameters:
+// - app: A pointer to the application context containing configuration.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function for the revert link.
+func RevertEmailChangePage(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderEmailChangePage(w, app, "revert", r.URL.Query().Get("token"))
+	}
+}
+
+// emailChangeStatus returns the status code and message of an email change outcome.
+func emailChangeStatus(state string) (int, string) {
+	switch state {
+	case application.EmailChangeConfirmed:
+		return http.StatusOK, "email address changed"
+	case application.EmailChangeReverted:
+		return http.StatusOK, "email address change reverted, every session was logged out"
+	case application.EmailChangeAlreadyConfirmed:
+		return http.StatusConflict, "email address change is already confirmed"
+	case application.EmailChangeAlreadyReverted:
+		return http.StatusConflict, "email address change was reverted or cancelled"
+	case application.EmailChangeTaken:
+		return http.StatusConflict, "email address is already in use"
+	case application.EmailChangeExpired:
+		return http.StatusGone, "email change link expired"
+	}
+	return http.StatusBadRequest, "invalid email change link"
+}
+
+// writeEmailChangeState answers an email change request with JSON.
+func writeEmailChangeState(w http.ResponseWriter, state string, err error) {
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	status, message := emailChangeStatus(state)
+	if status != http.StatusOK {
+		utils.ErrorJSON(w, errors.New(message), status)
+		return
+	}
+	_ = utils.WriteJSON(w, status, utils.JSONResponse{Message: message})
+}
+
+// renderEmailChangePage answers an email change link with the page asking to confirm the action.
+func renderEmailChangePage(w http.ResponseWriter, app *application.Application, action, token string) {
+	tmpl, err := template.ParseFiles("./template/emailChange.html")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing template: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Config *application.Config
+		Action string
+		Token  string
+	}{
+		Config: app.Config,
+		Action: action,
+		Token:  token,
+	}
+
+	var page bytes.Buffer
+	if err := tmpl.Execute(&page, data); err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = page.WriteTo(w)
+}
@@ -0,0 +1,149 @@
+package handlers_test
+
+import (
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/testenv"
+	"TriceraPass/pkg/client"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that email changes wait for the new address and can be reverted from the old one
+func TestEmailChange(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	env.Register(t, "grant", "clever-girl")
+	malcolmID := env.Register(t, "malcolm", "life-finds-a-way")
+	env.Mail.WaitFor(t, 2)
+	c := env.Login(t, "malcolm", "life-finds-a-way")
+
+	emailOf := func() string {
+		t.Helper()
+		user, err := env.App.Repository.GetUserByID(malcolmID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Email
+	}
+	change := func(email string) error {
+		return c.UpdateUser(ctx, malcolmID, client.UpdateUserRequest{UserName: "malcolm", Email: email})
+	}
+
+	if err := change("grant@jurassic.park"); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected an address in use to be rejected, got %v", err)
+	}
+	if err := change("not an address"); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected a malformed address to be rejected, got %v", err)
+	}
+	// A taken username rejects the whole update before any link is sent
+	err := c.UpdateUser(ctx, malcolmID, client.UpdateUserRequest{UserName: "GRANT", Email: "ian@chaos.theory"})
+	if !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a taken username to be rejected, got %v", err)
+	}
+	var pending int64
+	env.App.Repository.DB.Model(&models.EmailChange{}).Where("user_id = ?", malcolmID).Count(&pending)
+	if sent := len(env.Mail.Sent()); sent != 2 || pending != 0 {
+		t.Errorf("expected no email change for a rejected update, got %d emails and %d changes", sent, pending)
+	}
+	admin := env.Login(t, "grant", "clever-girl")
+	if err := admin.UpdateUser(ctx, malcolmID, client.UpdateUserRequest{UserName: "malcolm", Email: "grant@ingen.com"}); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected only the owner to change the address, got %v", err)
+	}
+
+	// Nothing changes before the new address is confirmed
+	if err := change("ian@chaos.theory"); err != nil {
+		t.Fatal(err)
+	}
+	sent := env.Mail.WaitFor(t, 4)
+	if emailOf() != "malcolm@jurassic.park" {
+		t.Fatalf("expected the address to wait for the confirmation, got %s", emailOf())
+	}
+	confirmToken := testenv.MailToken(t, sent, "ian@chaos.theory", "Confirm Your New Email Address")
+	revertToken := testenv.MailToken(t, sent, "malcolm@jurassic.park", "Your Email Address Is Being Changed")
+	env.Login(t, "malcolm", "life-finds-a-way")
+
+	resp, err := http.Get(env.Server.URL + "/auth/api/email_change/confirm?token=" + confirmToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `id="confirm"`) {
+		t.Fatalf("expected the confirmation page, got %d", resp.StatusCode)
+	}
+
+	// Opening the links only renders the pages, the change waits for the user to confirm it
+	resp, err = http.Get(env.Server.URL + "/auth/api/email_change/revert?token=" + revertToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `id="revert"`) {
+		t.Fatalf("expected the revert page, got %d", resp.StatusCode)
+	}
+	if emailOf() != "malcolm@jurassic.park" {
+		t.Fatalf("expected opening the links to change nothing, got %s", emailOf())
+	}
+
+	if err := client.New(env.Server.URL).ConfirmEmailChange(ctx, confirmToken); err != nil {
+		t.Fatal(err)
+	}
+	if emailOf() != "ian@chaos.theory" {
+		t.Fatalf("expected the new address, got %s", emailOf())
+	}
+	if _, err := client.New(env.Server.URL).Login(ctx, "malcolm@jurassic.park", "life-finds-a-way"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected the old address to stop working, got %v", err)
+	}
+	if _, err := client.New(env.Server.URL).Login(ctx, "ian@chaos.theory", "life-finds-a-way"); err != nil {
+		t.Errorf("expected the new address to log in, got %v", err)
+	}
+	if err := client.New(env.Server.URL).ConfirmEmailChange(ctx, confirmToken); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a used confirmation link to be rejected, got %v", err)
+	}
+
+	// Tokens are compared with second precision, the revert has to happen later
+	time.Sleep(1100 * time.Millisecond)
+	if err := client.New(env.Server.URL).RevertEmailChange(ctx, revertToken); err != nil {
+		t.Fatal(err)
+	}
+	if emailOf() != "malcolm@jurassic.park" {
+		t.Fatalf("expected the old address to be restored, got %s", emailOf())
+	}
+	if _, err := c.GetUserByID(ctx, malcolmID); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected the revert to log out every session, got %v", err)
+	}
+	if err := client.New(env.Server.URL).RevertEmailChange(ctx, revertToken); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a used revert link to be rejected, got %v", err)
+	}
+
+	// A newer request replaces the pending one, expired links are rejected
+	c = env.Login(t, "malcolm", "life-finds-a-way")
+	if err := change("ian@chaos.theory"); err != nil {
+		t.Fatal(err)
+	}
+	first := testenv.MailToken(t, env.Mail.WaitFor(t, 6), "ian@chaos.theory", "Confirm Your New Email Address")
+	if err := change("hammond@jurassic.park"); err != nil {
+		t.Fatal(err)
+	}
+	second := testenv.MailToken(t, env.Mail.WaitFor(t, 8), "hammond@jurassic.park", "Confirm Your New Email Address")
+	var apiErr *client.APIError
+	if err := c.ConfirmEmailChange(ctx, first); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusGone {
+		t.Errorf("expected the replaced link to be expired, got %v", err)
+	}
+
+	// The address may be taken between the request and the confirmation
+	env.Register(t, "hammond", "spared-no-expense")
+	if err := c.ConfirmEmailChange(ctx, second); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected a taken address to be rejected, got %v", err)
+	}
+	if emailOf() != "malcolm@jurassic.park" {
+		t.Errorf("expected the address to stay, got %s", emailOf())
+	}
+}
@@ -129,3 +129,41 @@ func Home(app *application.Application) http.HandlerFunc {
 		}
 	}
 }
+
+// LoginPage serves the hosted login page used by the embedded reverse proxy.
+// After a successful login the browser is sent back to the local path given in the
+// redirect query parameter.
+//
+// Parameters:
+// - app: A pointer to the application context containing configuration and services.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function for the hosted login page.
+func LoginPage(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Only allow local redirects to avoid sending users to foreign sites
+		redirect := r.URL.Query().Get("redirect")
+		if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+			redirect = "/"
+		}
+
+		tmpl, err := template.ParseFiles("./template/login.html")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error parsing template: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			Config   *application.Config
+			Redirect string
+		}{
+			Config:   app.Config,
+			Redirect: redirect,
+		}
+
+		err = tmpl.Execute(w, data)
+		if err != nil {
+			http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		}
+	}
+}
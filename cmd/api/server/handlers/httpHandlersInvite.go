@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"TriceraPass/cmd/api/application"
+	"TriceraPass/cmd/api/utils"
+	"TriceraPass/internal/repositories"
+	"TriceraPass/pkg/verifier"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AdminCreateInvite creates an invite. The code is only part of this response, invites
+// for an email address are also emailed to it.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that creates an invite.
+func AdminCreateInvite(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestPayload := struct {
+			Email     string     `json:"email"`
+			Mode      string     `json:"mode"`
+			MaxUses   *int       `json:"max_uses"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}{}
+		if err := utils.ReadJSON(w, r, &requestPayload); err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+
+		claims, ok := verifier.FromContext(r.Context())
+		if !ok {
+			utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		// Invites are single-use unless stated otherwise
+		maxUses := 1
+		if requestPayload.MaxUses != nil {
+			maxUses = *requestPayload.MaxUses
+		}
+		if requestPayload.ExpiresAt != nil && !requestPayload.ExpiresAt.After(time.Now()) {
+			utils.ErrorJSON(w, errors.New("expires_at must be in the future"), http.StatusBadRequest)
+			return
+		}
+
+		invite, code, err := app.CreateInvite(claims.Subject, requestPayload.Email, requestPayload.Mode, maxUses, requestPayload.ExpiresAt)
+		if errors.Is(err, application.ErrInvalidEmail) {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		if err != nil && invite == nil {
+			utils.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			// The invite works, the admin can hand out the code another way
+			log.Printf("error sending invite %s: %v", invite.ID, err)
+		}
+
+		response := utils.JSONResponse{
+			Message: "invite created",
+			Data: map[string]interface{}{
+				"invite": invite,
+				"code":   code,
+				"url":    app.InviteURL(code),
+			},
+		}
+		_ = utils.WriteJSON(w, http.StatusCreated, response)
+	}
+}
+
+// AdminGetInvites lists every invite, newest first. The codes are not part of the list.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that lists the invites.
+func AdminGetInvites(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		invites, err := app.Repository.GetAllInvites()
+		if err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+
+		_ = utils.WriteJSON(w, http.StatusOK, utils.JSONResponse{Data: invites})
+	}
+}
+
+// AdminRevokeInvite stops an invite from being used. Users who registered with it keep their accounts.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that revokes an invite.
+func AdminRevokeInvite(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := app.Repository.RevokeInvite(chi.URLParam(r, "invite_id"))
+		if errors.Is(err, repositories.ErrInvalidInvite) {
+			utils.ErrorJSON(w, errors.New("invite not found or already revoked"), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+
+		_ = utils.WriteJSON(w, http.StatusOK, utils.JSONResponse{Message: "invite revoked"})
+	}
+}
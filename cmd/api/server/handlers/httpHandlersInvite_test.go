@@ -0,0 +1,149 @@
+package handlers_test
+
+import (
+	"TriceraPass/internal/testenv"
+	"TriceraPass/pkg/client"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the registration modes: closed, invite-only with single and multi-use invites, and domain lists
+func TestRegistrationModes(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	registration := &env.App.Config.Security.Registration
+
+	register := func(name, email, code string) (string, error) {
+		return client.New(env.Server.URL).Register(ctx, client.RegisterRequest{
+			UserName: name, FirstName: name, LastName: "Test", Email: email, Password: "hold-onto-your-butts", InviteCode: code,
+		})
+	}
+
+	// The first user can always register and becomes the admin
+	registration.Mode = "closed"
+	env.Register(t, "hammond", "spared-no-expense")
+	env.Mail.WaitFor(t, 1)
+	admin := env.Login(t, "hammond", "spared-no-expense")
+	if _, err := register("arnold", "arnold@jurassic.park", ""); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected closed registration to be rejected, got %v", err)
+	}
+
+	registration.Mode = "invite"
+	registration.InviteURL = "https://app.example.com/signup"
+	if _, err := register("arnold", "arnold@jurassic.park", ""); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected a registration without invite to be rejected, got %v", err)
+	}
+
+	// An invite for an address is emailed and assigns its mode
+	created, err := admin.CreateInvite(ctx, client.CreateInviteRequest{Email: "muldoon@jurassic.park", Mode: "ranger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := env.Mail.WaitFor(t, 2)
+	if invitation := sent[len(sent)-1]; invitation.To != "muldoon@jurassic.park" || !strings.Contains(invitation.HTML, "https://app.example.com/signup?invite="+created.Code) {
+		t.Errorf("expected the invitation with the sign-up link to be emailed, got %+v", invitation)
+	}
+	if created.URL != "https://app.example.com/signup?invite="+created.Code {
+		t.Errorf("expected the sign-up link in the response, got %q", created.URL)
+	}
+	if _, err := register("arnold", "arnold@jurassic.park", created.Code); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected the invite to be rejected for another address, got %v", err)
+	}
+	if _, err := register("muldoon", "muldoon@jurassic.park", "short"); err == nil {
+		t.Fatal("expected a weak password to be rejected")
+	}
+	muldoonID, err := register("muldoon", "muldoon@jurassic.park", created.Code)
+	if err != nil {
+		t.Fatalf("expected the failed registration to give back the invite, got %v", err)
+	}
+	if user, err := env.App.Repository.GetUserByID(muldoonID); err != nil || user.Mode.Name != "ranger" {
+		t.Errorf("expected the invite to assign the ranger mode, got %+v, error: %v", user, err)
+	}
+	if _, err := register("muldoon2", "muldoon@jurassic.park", created.Code); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected a used single-use invite to be rejected, got %v", err)
+	}
+
+	// Multi-use invites until they are used up or revoked
+	two := 2
+	shared, err := admin.CreateInvite(ctx, client.CreateInviteRequest{MaxUses: &two})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"gennaro", "harding"} {
+		if _, err := register(name, name+"@jurassic.park", shared.Code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := register("lex", "lex@jurassic.park", shared.Code); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected a used up invite to be rejected, got %v", err)
+	}
+
+	unlimited := 0
+	open, err := admin.CreateInvite(ctx, client.CreateInviteRequest{MaxUses: &unlimited})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.RevokeInvite(ctx, open.Invite.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.RevokeInvite(ctx, open.Invite.ID); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected a revoked invite to be unknown, got %v", err)
+	}
+	if _, err := register("lex", "lex@jurassic.park", open.Code); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected a revoked invite to be rejected, got %v", err)
+	}
+
+	invites, err := admin.ListInvites(ctx)
+	if err != nil || len(invites) != 3 {
+		t.Fatalf("expected three invites, got %d, error: %v", len(invites), err)
+	}
+	for _, invite := range invites {
+		if invite.ID == shared.Invite.ID && invite.Uses != 2 {
+			t.Errorf("expected the shared invite to be used twice, got %d", invite.Uses)
+		}
+	}
+
+	// Domain lists, subdomains included
+	registration.Mode = "domain"
+	registration.AllowedDomains = []string{"jurassic.park"}
+	registration.DeniedDomains = []string{"visitors.jurassic.park"}
+	if _, err := register("dodgson", "dodgson@biosyn.com", ""); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected a domain outside the allow list to be rejected, got %v", err)
+	}
+	if _, err := register("tim", "tim@visitors.jurassic.park", ""); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected a denied domain to be rejected, got %v", err)
+	}
+	if _, err := register("lex", "lex@jurassic.park", ""); err != nil {
+		t.Errorf("expected an allowed domain to register, got %v", err)
+	}
+	if _, err := register("wu", "wu@staff.jurassic.park", ""); err != nil {
+		t.Errorf("expected a subdomain of an allowed domain to register, got %v", err)
+	}
+
+	// Without a sign-up page the invitation only carries the code
+	registration.InviteURL = ""
+	outside, err := admin.CreateInvite(ctx, client.CreateInviteRequest{Email: "dodgson@biosyn.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outside.URL != "" {
+		t.Errorf("expected no sign-up link without an invite_url, got %q", outside.URL)
+	}
+	var invitation *testenv.Email
+	for deadline := time.Now().Add(30 * time.Second); invitation == nil && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		for _, email := range env.Mail.Sent() {
+			if email := email; email.To == "dodgson@biosyn.com" {
+				invitation = &email
+			}
+		}
+	}
+	if invitation == nil || !strings.Contains(invitation.HTML, outside.Code) || strings.Contains(invitation.HTML, "invite=") {
+		t.Errorf("expected the invitation to carry only the code, got %+v", invitation)
+	}
+	if _, err := register("dodgson", "dodgson@biosyn.com", outside.Code); err != nil {
+		t.Errorf("expected the invite to admit an address outside the allowed domains, got %v", err)
+	}
+}
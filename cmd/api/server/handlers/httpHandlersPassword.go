@@ -5,23 +5,26 @@ import (
 	"TriceraPass/cmd/api/controllers"
 	"TriceraPass/cmd/api/utils"
 	"TriceraPass/internal/models"
+	"TriceraPass/internal/passwords"
+	"TriceraPass/internal/repositories"
+	"TriceraPass/pkg/verifier"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
-	"time"
+	"strconv"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
-// PasswordResetPayload represents the payload for resetting a user's password.
-type PasswordResetPayload struct {
-	UserID      string `json:"user_id"`      // The ID of the user.
+// PasswordTokenResetPayload represents the payload for resetting a forgotten password.
+type PasswordTokenResetPayload struct {
+	Token       string `json:"token"`        // The reset token from the emailed link.
 	NewPassword string `json:"new_password"` // The new password for the user.
 }
 
-// SendPasswordResetEmail generates a password reset token and sends an email to the user containing the reset link.
+// SendPasswordResetEmail sends a password reset link to the logged-in user.
 //
 // Parameters:
 // - app: A pointer to the application context containing repositories.
@@ -43,23 +46,12 @@ func SendPasswordResetEmail(app *application.Application) http.HandlerFunc {
 			return
 		}
 
-		// Generate a password reset token
-		passwordToken := models.PasswordRestToken{
-			ID:        uuid.NewString(),
-			UserID:    payload.UserID,
-			ExpiredAt: 10,
-			CreatedAt: time.Now(),
-			TokenUsed: false,
-		}
-
-		tokenID, err := app.Repository.InsertPasswordToken(&passwordToken)
-		if err != nil {
-			utils.ErrorJSON(w, err)
+		// Users can only ask for reset links of their own account
+		if claims, ok := verifier.FromContext(r.Context()); ok && claims.Subject != payload.UserID {
+			utils.ErrorJSON(w, errors.New("forbidden"), http.StatusForbidden)
 			return
 		}
 
-		fmt.Printf("password reset token generated with id: %s", tokenID)
-
 		// Load environment variables
 		_ = godotenv.Load()
 
@@ -70,19 +62,13 @@ func SendPasswordResetEmail(app *application.Application) http.HandlerFunc {
 			return
 		}
 
-		// Send the password reset email using Mailgun
-		apiKey := os.Getenv("MAILGUN_API_KEY")
-		domain := os.Getenv("MAILGUN_DOMAIN")
-
-		_, err = controllers.SendEmail(domain, apiKey, user.Email, user.UserName, payload.UserID, "password", 1)
-		if err != nil {
+		// Store a new reset token and email the link
+		if err := app.SendPasswordResetLink(user); err != nil {
 			utils.ErrorJSON(w, fmt.Errorf("error sending email - %v", err))
 			return
 		}
 
-		// Respond with the token ID
 		response := utils.JSONResponse{
-			Data:    tokenID,
 			Message: "password reset link was sent to your email",
 		}
 
@@ -91,6 +77,7 @@ func SendPasswordResetEmail(app *application.Application) http.HandlerFunc {
 }
 
 // SendForgottenPasswordEmail handles the process of sending a password reset email to a user who forgot their password.
+// The response is the same whether or not an account uses the email address, so the route can not be used to find accounts.
 //
 // Parameters:
 // - app: A pointer to the application context containing repositories.
@@ -111,177 +98,329 @@ func SendForgottenPasswordEmail(app *application.Application) http.HandlerFunc {
 			return
 		}
 
-		// Fetch the user by their email address
-		user, err := app.Repository.GetUserByEmail(emailInPayload.Email)
-		if err != nil {
+		// Look up the user and send the link in the background, so neither the
+		// response nor its timing tells whether the address is registered
+		go func() {
+			_ = godotenv.Load()
+
+			user, err := app.Repository.GetUserByEmail(emailInPayload.Email)
+			if err != nil || user.IsDeleted() {
+				return
+			}
+
+			if err := app.SendPasswordResetLink(user); err != nil {
+				fmt.Println("error sending password reset email:", err)
+			}
+		}()
+
+		response := utils.JSONResponse{
+			Message: "if an account uses this email, a password reset link was sent to it",
+		}
+
+		_ = utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+// ResetPasswordWithToken sets a new password using the token from a password reset email.
+// The token is consumed, so the link only works once, and every existing session of the
+// user is revoked.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function for resetting a forgotten password.
+func ResetPasswordWithToken(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var payload *PasswordTokenResetPayload
+		if err := utils.ReadJSON(w, r, &payload); err != nil {
 			utils.ErrorJSON(w, err)
 			return
 		}
 
-		// Generate a password reset token
-		passwordToken := models.PasswordRestToken{
-			ID:        uuid.NewString(),
-			UserID:    user.ID,
-			ExpiredAt: 10,
-			CreatedAt: time.Now(),
-			TokenUsed: false,
+		// Look up the token without consuming it, so a rejected password can be retried
+		tokenHash := controllers.HashToken(payload.Token)
+		passwordToken, err := app.Repository.GetPasswordTokenByHash(tokenHash)
+		if err != nil || passwordToken.TokenUsed || passwordToken.IsTokenExpired() {
+			utils.ErrorJSON(w, repositories.ErrInvalidToken)
+			return
 		}
 
-		tokenID, err := app.Repository.InsertPasswordToken(&passwordToken)
+		user, err := app.Repository.GetUserByID(passwordToken.UserID)
 		if err != nil {
-			utils.ErrorJSON(w, err)
+			utils.ErrorJSON(w, repositories.ErrInvalidToken)
 			return
 		}
 
-		fmt.Printf("password reset token generated with id: %s", tokenID)
-
-		// Load environment variables
-		_ = godotenv.Load()
+		// Check the new password against the password policy and the breach list
+		if err := app.ValidatePassword(payload.NewPassword, user.UserName, user.Email); err != nil {
+			passwordErrorJSON(w, err)
+			return
+		}
 
-		// Send the password reset email using Mailgun
-		apiKey := os.Getenv("MAILGUN_API_KEY")
-		domain := os.Getenv("MAILGUN_DOMAIN")
+		if !checkPasswordReuse(app, w, user, payload.NewPassword) {
+			return
+		}
 
-		_, err = controllers.SendEmail(domain, apiKey, user.Email, user.UserName, user.ID, "password", 1)
+		// Hash before consuming the token, so a busy hashing pool does not burn the link
+		hashedPassword, err := controllers.HashAPassword(payload.NewPassword)
 		if err != nil {
-			utils.ErrorJSON(w, fmt.Errorf("error sending email - %v", err))
+			passwordErrorJSON(w, err)
 			return
 		}
 
-		// Respond with the token ID
-		response := utils.JSONResponse{
-			Data:    tokenID,
-			Message: "password reset link was sent to your email",
+		// Consume the token and log out every device that was using the old password,
+		// a concurrent request with the same token fails here
+		if _, err := app.Repository.ResetPasswordByToken(tokenHash, hashedPassword, app.PasswordPolicy().History); err != nil {
+			utils.ErrorJSON(w, err)
+			return
 		}
+		http.SetCookie(w, app.Auth.GetExpiredRefreshCookie())
+		http.SetCookie(w, app.Auth.GetExpiredAccessCookie())
 
+		sendPasswordChangedEmail(app, user.ID)
+
+		response := utils.JSONResponse{Message: "password was reset successfully, please log in again"}
 		_ = utils.WriteJSON(w, http.StatusOK, response)
 	}
 }
 
-// ChangePasswordByUserID handles the process of changing a user's password by their user ID.
+// ChangeOwnPasswordPayload represents the payload for a logged-in user changing their own password.
+type ChangeOwnPasswordPayload struct {
+	CurrentPassword      string `json:"current_password"`        // The password the user has now.
+	NewPassword          string `json:"new_password"`            // The new password for the user.
+	SignOutOtherSessions bool   `json:"sign_out_other_sessions"` // Revoke every other session of the user.
+}
+
+// ChangeOwnPassword changes the password of the logged-in user, who has to prove the
+// current password. The user is taken from the token, not from the payload. The caller
+// gets a new token pair, which stays valid when the other sessions are signed out.
 //
 // Parameters:
 // - app: A pointer to the application context containing repositories.
 //
 // Returns:
-// - http.HandlerFunc: An HTTP handler function for changing a user's password.
-func ChangePasswordByUserID(app *application.Application) http.HandlerFunc {
+// - http.HandlerFunc: An HTTP handler function for changing the own password.
+func ChangeOwnPassword(app *application.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		// Payload to read the new password and user ID from the request body
-		var payload *PasswordResetPayload
-		err := utils.ReadJSON(w, r, &payload)
-		if err != nil {
+		var payload *ChangeOwnPasswordPayload
+		if err := utils.ReadJSON(w, r, &payload); err != nil {
 			utils.ErrorJSON(w, err)
 			return
 		}
 
-		// Fetch the existing password
-		oldPassword, err := app.Repository.GetUserPasswordByID(payload.UserID)
+		claims, ok := verifier.FromContext(r.Context())
+		if !ok {
+			utils.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		user, err := app.Repository.GetUserByID(claims.Subject)
 		if err != nil {
 			utils.ErrorJSON(w, err)
 			return
 		}
 
-		// Verify that the new password is not the same as the old one
-		isDuplicate, _ := controllers.VerifyPasswordNonDuplicate(oldPassword, payload.NewPassword)
-		if isDuplicate {
-			utils.ErrorJSON(w, fmt.Errorf("the new password cannot be the same as your existing one"))
+		// Wrong current passwords count as failed logins, so a stolen token can not be used to guess the password
+		clientIP := app.ClientIP(r)
+		if retryAfter, blocked := app.LoginBlocked(user.Email, clientIP); blocked {
+			tooManyAttemptsJSON(w, retryAfter)
 			return
 		}
 
-		// Update the user's password in the database
-		err = app.Repository.ChangePasswordByUserID(payload.UserID, payload.NewPassword)
-		if err != nil {
-			utils.ErrorJSON(w, err)
+		// Verify the current password
+		valid, err := user.PasswordMatches(payload.CurrentPassword)
+		if hashingBusyJSON(w, err) {
 			return
 		}
+		if err != nil || !valid {
+			app.RecordFailedLogin(user.Email, clientIP, user)
+			utils.ErrorJSON(w, errors.New("the current password is incorrect"), http.StatusForbidden)
+			return
+		}
+		app.RecordSuccessfulLogin(user.Email)
 
-		// Send a password change notification email (async)
-		go func() {
-			_ = godotenv.Load()
-			apiKey := os.Getenv("MAILGUN_API_KEY")
-			domain := os.Getenv("MAILGUN_DOMAIN")
+		// Check the new password against the password policy and the breach list
+		if err := app.ValidatePassword(payload.NewPassword, user.UserName, user.Email); err != nil {
+			passwordErrorJSON(w, err)
+			return
+		}
 
-			user, err := app.Repository.GetUserByID(payload.UserID)
-			if err != nil {
-				fmt.Println("error getting user:", err)
-				return
-			}
+		if !checkPasswordReuse(app, w, user, payload.NewPassword) {
+			return
+		}
 
-			_, err = controllers.SendEmail(domain, apiKey, user.Email, user.UserName, user.ID, "passwordChange", 10)
-			if err != nil {
-				fmt.Println("error sending email:", err)
-				return
-			}
-		}()
+		err = app.Repository.ChangePasswordByUserID(user.ID, payload.NewPassword, app.PasswordPolicy().History, payload.SignOutOtherSessions)
+		if err != nil {
+			passwordErrorJSON(w, err)
+			return
+		}
 
-		// Respond with success
-		response := utils.JSONResponse{Message: "password changed successfully"}
+		// Issue new tokens for this session, no longer restricted if the password had expired
+		user, err = app.Repository.GetUserByID(user.ID)
+		if err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+		tokens, err := issueTokens(app, user)
+		if err != nil {
+			utils.ErrorJSON(w, err)
+			return
+		}
+		http.SetCookie(w, app.Auth.GetRefreshCookie(tokens.RefreshToken))
+		http.SetCookie(w, app.Auth.GetAccessCookie(tokens.Token))
+
+		sendPasswordChangedEmail(app, user.ID)
+
+		response := utils.JSONResponse{
+			Message: "password changed successfully",
+			Data:    tokens,
+		}
 		_ = utils.WriteJSON(w, http.StatusOK, response)
 	}
 }
 
-// SetTokenToUsed marks a password reset token as used and sets its expiration.
+// checkPasswordReuse rejects a new password that is the current one or one of the
+// recent ones kept in the password history.
 //
 // Parameters:
 // - app: A pointer to the application context containing repositories.
+// - w: The HTTP response writer, the rejection is written to it.
+// - user: The user changing the password.
+// - newPassword: The plain-text new password.
 //
 // Returns:
-// - http.HandlerFunc: An HTTP handler function for marking a password reset token as used.
-func SetTokenToUsed(app *application.Application) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		userID := chi.URLParam(r, "user_id")
+// - bool: True if the password may be used, false if a response was written.
+func checkPasswordReuse(app *application.Application, w http.ResponseWriter, user *models.User, newPassword string) bool {
+	// Verify that the new password is not the same as the old one
+	isDuplicate, err := controllers.VerifyPasswordNonDuplicate(user.Password, newPassword)
+	if hashingBusyJSON(w, err) {
+		return false
+	}
+	if isDuplicate {
+		utils.ErrorJSON(w, fmt.Errorf("the new password cannot be the same as your existing one"))
+		return false
+	}
 
-		// Fetch the last password reset token for the user
-		passwordToken, err := app.Repository.GetLastPasswordTokenByUserID(userID)
+	// Verify that the new password is not one of the recent ones
+	historySize := app.PasswordPolicy().History
+	history, err := app.Repository.GetPasswordHistory(user.ID, historySize)
+	if err != nil {
+		utils.ErrorJSON(w, err)
+		return false
+	}
+	for _, previous := range history {
+		reused, err := controllers.VerifyPasswordNonDuplicate(previous.Password, newPassword)
+		if hashingBusyJSON(w, err) {
+			return false
+		}
+		if reused {
+			utils.ErrorJSON(w, fmt.Errorf("the new password cannot be one of your last %d passwords", historySize))
+			return false
+		}
+	}
+	return true
+}
+
+// sendPasswordChangedEmail notifies the user about a password change in the background.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+// - userID: The ID of the user whose password changed.
+func sendPasswordChangedEmail(app *application.Application, userID string) {
+	go func() {
+		_ = godotenv.Load()
+		apiKey := os.Getenv("MAIL_SERVER_API_KEY")
+		domain := os.Getenv("MAIL_SERVER_DOMAIN")
+
+		user, err := app.Repository.GetUserByID(userID)
 		if err != nil {
-			utils.ErrorJSON(w, err)
+			fmt.Println("error getting user:", err)
 			return
 		}
 
-		// Check if the token has already been used or expired
-		if passwordToken != nil && passwordToken.IsTokenExpired() {
-			if passwordToken.TokenUsed {
-				utils.ErrorJSON(w, fmt.Errorf("this password reset token is already used"))
-				return
-			}
-
-			// Mark the token as used and expire it
-			passwordToken.TokenUsed = true
-			passwordToken.SetTokenExpire()
+		_, err = controllers.SendEmail(domain, apiKey, user.Email, user.UserName, user.ID, "passwordChange", 10)
+		if err != nil {
+			fmt.Println("error sending email:", err)
+			return
+		}
+	}()
+}
 
-			// Update the token in the database
-			err = app.Repository.SetTokenToUsed(passwordToken.ID, passwordToken)
-			if err != nil {
-				utils.ErrorJSON(w, err)
-				return
-			}
+// GetPasswordPolicy returns the active password policy so that UIs can show the rules
+// and validate passwords before submitting them.
+//
+// Parameters:
+// - app: A pointer to the application context containing the configuration.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function for fetching the password policy.
+func GetPasswordPolicy(app *application.Application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := utils.JSONResponse{
+			Data: app.PasswordPolicy(),
+		}
+		_ = utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
 
-			// Respond with success
-			response := utils.JSONResponse{Message: "password reset token was successfully used"}
-			_ = utils.WriteJSON(w, http.StatusOK, response)
+// passwordErrorJSON writes a password policy error with the violated rules in the data field
+// and a saturated hashing pool as 503. Any other error is written as a regular error response.
+//
+// Parameters:
+// - w: The HTTP response writer.
+// - err: The error returned by the password policy or hashing.
+func passwordErrorJSON(w http.ResponseWriter, err error) {
+	var policyErr *controllers.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		response := utils.JSONResponse{
+			Error:   true,
+			Message: policyErr.Error(),
+			Data:    policyErr.Violations,
 		}
+		_ = utils.WriteJSON(w, http.StatusBadRequest, response)
+		return
 	}
+	if hashingBusyJSON(w, err) {
+		return
+	}
+	utils.ErrorJSON(w, err)
 }
 
-// FetchPasswordTokenByUserID retrieves the most recent password reset token for a user and returns it as a JSON response.
+// hashingBusyJSON writes 503 with a Retry-After header when the password hashing pool
+// rejected the work.
 //
 // Parameters:
-// - app: A pointer to the application context containing repositories.
+// - w: The HTTP response writer.
+// - err: The error returned by hashing or verifying a password.
+//
+// Returns:
+// - bool: True if the response was written.
+func hashingBusyJSON(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, passwords.ErrBusy) {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(passwords.DefaultPool().RetryAfter().Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	utils.ErrorJSON(w, err, http.StatusServiceUnavailable)
+	return true
+}
+
+// GetHashingMetrics returns the metrics of the password hashing pool, used to size
+// the concurrency limit and the queue depth.
+//
+// Parameters:
+// - app: A pointer to the application context.
 //
 // Returns:
-// - http.HandlerFunc: An HTTP handler function for fetching a password reset token by user ID.
-func FetchPasswordTokenByUserID(app *application.Application) http.HandlerFunc {
+// - http.HandlerFunc: An HTTP handler function for fetching the hashing metrics.
+func GetHashingMetrics(app *application.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID := chi.URLParam(r, "user_id")
-		passwordToken, err := app.Repository.GetLastPasswordTokenByUserID(userID)
-		if err != nil {
-			utils.ErrorJSON(w, err)
-			return
-		}
 		response := utils.JSONResponse{
-			Data: passwordToken,
+			Data: passwords.DefaultPool().Stats(),
 		}
 		_ = utils.WriteJSON(w, http.StatusOK, response)
 	}
@@ -0,0 +1,386 @@
+package handlers_test
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/passwords"
+	"TriceraPass/internal/testenv"
+	"TriceraPass/pkg/client"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that registration enforces the password policy and reports the violated rules
+func TestPasswordPolicy(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	c := client.New(env.Server.URL)
+
+	policy, err := c.GetPasswordPolicy(ctx)
+	if err != nil || policy.MinLength != 8 {
+		t.Fatalf("expected the default minimum length, got %+v, error: %v", policy, err)
+	}
+
+	_, err = c.Register(ctx, client.RegisterRequest{UserName: "nedry", Email: "nedry@jurassic.park", Password: ""})
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an empty password to be rejected, got %v", err)
+	}
+	violations := apiErr.PasswordViolations()
+	if len(violations) != 1 || violations[0].Rule != "min_length" {
+		t.Errorf("expected a min_length violation, got %+v", violations)
+	}
+}
+
+// Test breached password screening on registration and the rotation flag on login
+func TestBreachedPasswords(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	c := client.New(env.Server.URL)
+
+	filter := controllers.NewBloomFilter(10, 0.001)
+	filter.AddHash(sha1.Sum([]byte("password1234")))
+	env.App.Breaches = filter
+
+	_, err := c.Register(ctx, client.RegisterRequest{UserName: "nedry", Email: "nedry@jurassic.park", Password: "password1234"})
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a breached password to be rejected, got %v", err)
+	}
+	violations := apiErr.PasswordViolations()
+	if len(violations) != 1 || violations[0].Rule != "breached" {
+		t.Errorf("expected a breached violation, got %+v", violations)
+	}
+
+	// A password that leaks after registration is flagged on the next login
+	env.Register(t, "hammond", "spared-no-expense")
+	filter.AddHash(sha1.Sum([]byte("spared-no-expense")))
+	env.App.Config.Security.BreachedPasswords.CheckOnLogin = true
+
+	tokens, err := c.Login(ctx, "hammond@jurassic.park", "spared-no-expense")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.PasswordRotationRequired {
+		t.Error("expected the login to require a password rotation")
+	}
+}
+
+// Test that recent passwords can not be reused and the history is trimmed
+func TestPasswordHistory(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	env.App.Config.Security.PasswordPolicy.History = 1
+
+	userID := env.Register(t, "muldoon", "shoot-her-now")
+	c := env.Login(t, "muldoon", "shoot-her-now")
+
+	if _, err := c.UpdatePassword(ctx, "shoot-her-now", "clever-girl-1", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdatePassword(ctx, "clever-girl-1", "shoot-her-now", false); !errors.Is(err, client.ErrBadRequest) {
+		t.Fatalf("expected a recent password to be rejected, got %v", err)
+	}
+
+	if _, err := c.UpdatePassword(ctx, "clever-girl-1", "clever-girl-2", false); err != nil {
+		t.Fatal(err)
+	}
+	history, err := env.App.Repository.GetPasswordHistory(userID, 10)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("expected the history to be trimmed to one entry, got %d, error: %v", len(history), err)
+	}
+
+	if err := env.App.Repository.DeleteUserByID(userID); err != nil {
+		t.Fatal(err)
+	}
+	history, _ = env.App.Repository.GetPasswordHistory(userID, 10)
+	if len(history) != 0 {
+		t.Errorf("expected the history to be deleted with the user, got %d entries", len(history))
+	}
+}
+
+// Test that a saturated hashing pool answers with 503 and shows up in the metrics
+func TestHashingPoolSaturation(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	previous := passwords.DefaultPool()
+	t.Cleanup(func() { passwords.SetDefaultPool(previous) })
+
+	env.Register(t, "hammond", "spared-no-expense")
+	admin := env.Login(t, "hammond", "spared-no-expense")
+
+	pool := passwords.NewPool(passwords.PoolConfig{Concurrency: 1, QueueDepth: 1, QueueTimeout: time.Millisecond, RetryAfter: 2 * time.Second})
+	passwords.SetDefaultPool(pool)
+
+	// Occupy the only slot
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = pool.Do(func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	resp, err := http.Post(env.Server.URL+"/auth/api/login", "application/json",
+		strings.NewReader(`{"email":"hammond@jurassic.park","password":"spared-no-expense"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "2" {
+		t.Fatalf("expected 503 with Retry-After 2, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	_, err = client.New(env.Server.URL).Login(ctx, "hammond@jurassic.park", "spared-no-expense")
+	if !errors.Is(err, client.ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable, got %v", err)
+	}
+	close(release)
+
+	metrics, err := admin.HashingMetrics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.Concurrency != 1 || metrics.Rejected != 2 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
+
+// Test the restricted token issued for an expired password
+func TestPasswordExpiry(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+	expiry := &env.App.Config.Security.PasswordExpiry
+	expiry.MaxAgeDays = map[string]int{"admin": 30}
+
+	userID := env.Register(t, "arnold", "hold-on-to-your-butts")
+	backdate := func(days int) {
+		err := env.App.Repository.DB.Model(&models.User{}).Where("id = ?", userID).
+			Update("password_changed_at", time.Now().AddDate(0, 0, -days)).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An expired password only gets a token for changing it
+	backdate(31)
+	c := client.New(env.Server.URL)
+	tokens, err := c.Login(ctx, "arnold@jurassic.park", "hold-on-to-your-butts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.PasswordRotationRequired {
+		t.Error("expected the login to require a password change")
+	}
+	if _, err := c.GetUserByID(ctx, userID); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected the restricted token to be forbidden, got %v", err)
+	}
+	if _, err := c.UpdatePassword(ctx, "wrong-password", "life-finds-a-way", false); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected the restricted token to only change the password with the current one, got %v", err)
+	}
+	if err := c.Logout(ctx); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected the restricted token to be forbidden on logout, got %v", err)
+	}
+	tokens, err = c.UpdatePassword(ctx, "hold-on-to-your-butts", "life-finds-a-way", false)
+	if err != nil || tokens.PasswordRotationRequired {
+		t.Fatalf("expected the restricted token to change the password, got %+v, error: %v", tokens, err)
+	}
+	if _, err := c.GetUserByID(ctx, userID); err != nil {
+		t.Errorf("expected the tokens issued by the change to work, got %v", err)
+	}
+
+	tokens, err = c.Login(ctx, "arnold@jurassic.park", "life-finds-a-way")
+	if err != nil || tokens.PasswordRotationRequired {
+		t.Fatalf("expected a regular login after the change, got %+v, error: %v", tokens, err)
+	}
+	if _, err := c.GetUserByID(ctx, userID); err != nil {
+		t.Errorf("expected a regular token to work, got %v", err)
+	}
+}
+
+// Test the forgotten password flow with single-use emailed tokens
+func TestPasswordResetToken(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	userID := env.Register(t, "nedry", "ah-ah-ah-magic-word")
+	env.Mail.WaitFor(t, 1)
+	old := env.Login(t, "nedry", "ah-ah-ah-magic-word")
+
+	// Tokens are compared with second precision, the reset has to happen later
+	time.Sleep(1100 * time.Millisecond)
+
+	// Unknown addresses get the same answer and no email
+	c := client.New(env.Server.URL)
+	if err := c.SendForgottenPasswordEmail(ctx, "nobody@jurassic.park"); err != nil {
+		t.Fatalf("expected unknown addresses to be answered like known ones, got %v", err)
+	}
+	if err := c.SendForgottenPasswordEmail(ctx, "nedry@jurassic.park"); err != nil {
+		t.Fatal(err)
+	}
+	sent := env.Mail.WaitFor(t, 2)
+	for _, email := range sent {
+		if strings.Contains(email.To, "nobody") {
+			t.Errorf("expected no email to an unknown address")
+		}
+	}
+
+	token := testenv.ResetToken(t, sent)
+	stored, err := env.App.Repository.GetPasswordTokenByHash(controllers.HashToken(token))
+	if err != nil || stored.UserID != userID {
+		t.Fatalf("expected the token to be stored hashed, got %+v, error: %v", stored, err)
+	}
+
+	if err := c.ResetPassword(ctx, "not-a-token", "life-finds-a-way"); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected an unknown token to be rejected, got %v", err)
+	}
+	if err := c.ResetPassword(ctx, token, "ah-ah-ah-magic-word"); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected the current password to be rejected, got %v", err)
+	}
+
+	// A busy hashing pool does not use up the token
+	previous := passwords.DefaultPool()
+	pool := passwords.NewPool(passwords.PoolConfig{Concurrency: 1, QueueDepth: 1, QueueTimeout: time.Millisecond, RetryAfter: time.Second})
+	passwords.SetDefaultPool(pool)
+	release, started := make(chan struct{}), make(chan struct{})
+	go func() {
+		_ = pool.Do(func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+	err = c.ResetPassword(ctx, token, "life-finds-a-way")
+	close(release)
+	passwords.SetDefaultPool(previous)
+	if !errors.Is(err, client.ErrUnavailable) {
+		t.Errorf("expected a busy hashing pool to be reported, got %v", err)
+	}
+
+	if err := c.ResetPassword(ctx, token, "life-finds-a-way"); err != nil {
+		t.Fatalf("Error resetting password: %v", err)
+	}
+	if err := c.ResetPassword(ctx, token, "life-finds-a-way-2"); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected a used token to be rejected, got %v", err)
+	}
+
+	// Sessions from before the reset are revoked
+	if _, err := old.Refresh(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected the old refresh token to be revoked, got %v", err)
+	}
+	if _, err := old.GetUserByID(ctx, userID); err == nil {
+		t.Errorf("expected the old access token to be revoked")
+	}
+	if _, err := c.Login(ctx, "nedry@jurassic.park", "ah-ah-ah-magic-word"); err == nil {
+		t.Errorf("expected the old password to be rejected")
+	}
+	fresh := env.Login(t, "nedry", "life-finds-a-way")
+	if _, err := fresh.GetUserByID(ctx, userID); err != nil {
+		t.Errorf("expected a new session to work, got %v", err)
+	}
+
+	// Expired tokens are rejected
+	if err := c.SendForgottenPasswordEmail(ctx, "nedry@jurassic.park"); err != nil {
+		t.Fatal(err)
+	}
+	sent = env.Mail.WaitFor(t, 3)
+	token = testenv.ResetToken(t, sent)
+	env.App.Repository.DB.Model(&models.PasswordRestToken{}).Where("token_hash = ?", controllers.HashToken(token)).
+		Update("expired_at", time.Now().Add(-time.Minute).Unix())
+	if err := c.ResetPassword(ctx, token, "hold-onto-your-butts"); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected an expired token to be rejected, got %v", err)
+	}
+}
+
+// Test that logged-in users prove the current password and can sign out other sessions
+func TestUpdatePassword(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	env.Register(t, "hammond", "spared-no-expense")
+	userID := env.Register(t, "grant", "raptors-are-smart")
+	current := env.Login(t, "grant", "raptors-are-smart")
+	other := env.Login(t, "grant", "raptors-are-smart")
+
+	// Tokens are compared with second precision, the sign out has to happen later
+	time.Sleep(1100 * time.Millisecond)
+
+	if _, err := current.UpdatePassword(ctx, "wrong-password", "clever-girl-99", false); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected a wrong current password to be rejected, got %v", err)
+	}
+	if _, err := current.UpdatePassword(ctx, "raptors-are-smart", "short", false); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected the password policy to be applied, got %v", err)
+	}
+
+	// The route changing a password by user ID without the current one is gone
+	admin := env.Login(t, "hammond", "spared-no-expense")
+	req, err := http.NewRequest(http.MethodPost, env.Server.URL+"/auth/api/logged_in/user/password_reset/"+userID,
+		strings.NewReader(`{"user_id": "`+userID+`", "new_password": "not-your-account"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+admin.Tokens().Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected the password change by user ID to be removed, got %d", resp.StatusCode)
+	}
+
+	tokens, err := current.UpdatePassword(ctx, "raptors-are-smart", "dig-up-dinosaurs", true)
+	if err != nil {
+		t.Fatalf("Error changing password: %v", err)
+	}
+	if tokens.Token == "" {
+		t.Error("expected new tokens for the current session")
+	}
+	if _, err := current.GetUserByID(ctx, userID); err != nil {
+		t.Errorf("expected the current session to stay signed in, got %v", err)
+	}
+	if _, err := other.GetUserByID(ctx, userID); err == nil {
+		t.Error("expected the other session to be signed out")
+	}
+	env.Login(t, "grant", "dig-up-dinosaurs")
+}
+
+// Test that wrong current passwords are throttled like failed logins
+func TestUpdatePasswordThrottle(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	lockout := &env.App.Config.Security.Lockout
+	lockout.Enabled = true
+	lockout.FreeAttempts = 1
+	lockout.BaseDelay = time.Hour
+	lockout.MaxAttempts = 10
+	lockout.IPMaxAttempts = 100
+	lockout.LockoutDuration = time.Hour
+
+	env.Register(t, "nedry", "ah-ah-ah-magic-word")
+	c := env.Login(t, "nedry", "ah-ah-ah-magic-word")
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.UpdatePassword(ctx, "wrong-password", "see-nobody-cares", false); !errors.Is(err, client.ErrForbidden) {
+			t.Fatalf("expected attempt %d to be rejected with 403, got %v", i+1, err)
+		}
+	}
+	_, err := c.UpdatePassword(ctx, "ah-ah-ah-magic-word", "see-nobody-cares", false)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrTooManyRequests) || apiErr.RetryAfter <= 0 {
+		t.Fatalf("expected the backoff to answer 429 with Retry-After, got %v", err)
+	}
+
+	// The failures count for the login too
+	if _, err := client.New(env.Server.URL).Login(ctx, "nedry@jurassic.park", "ah-ah-ah-magic-word"); !errors.Is(err, client.ErrTooManyRequests) {
+		t.Errorf("expected the login to be throttled as well, got %v", err)
+	}
+}
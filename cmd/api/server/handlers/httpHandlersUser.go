@@ -2,12 +2,12 @@ package handlers
 
 import (
 	"TriceraPass/cmd/api/application"
-	"TriceraPass/cmd/api/controllers"
 	"TriceraPass/cmd/api/utils"
 	"TriceraPass/internal/models"
+	"TriceraPass/internal/repositories"
+	"TriceraPass/pkg/verifier"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
@@ -162,6 +162,9 @@ func AddMissingCreationDate(app *application.Application) http.HandlerFunc {
 }
 
 // UpdateUser updates a user's information in the database based on the provided user ID and request payload.
+// A changed email address is not written directly, the change has to be confirmed through
+// the link sent to the new address. The links are only sent once the rest of the update
+// was stored.
 //
 // Parameters:
 // - app: A pointer to the application context containing repositories.
@@ -186,21 +189,72 @@ func Updateuser(app *application.Application) http.HandlerFunc {
 			return
 		}
 
+		// Check the new address before anything is stored, so a rejected request changes nothing
+		changeEmail := payload.Email != "" && models.NormalizeEmail(payload.Email) != user.Email
+		if changeEmail {
+			// Users can only change the address of their own account
+			if claims, ok := verifier.FromContext(r.Context()); !ok || claims.Subject != user.ID {
+				utils.ErrorJSON(w, errors.New("forbidden"), http.StatusForbidden)
+				return
+			}
+			if _, err := app.ValidateEmailChange(user, payload.Email); err != nil {
+				emailChangeErrorJSON(w, err)
+				return
+			}
+		}
+
 		// Update user information
 		user.UserName = payload.UserName
-		user.Email = payload.Email
 
 		_, err = app.Repository.UpdateUser(userID, user)
+		if errors.Is(err, repositories.ErrUserNameTaken) || errors.Is(err, repositories.ErrUserExists) {
+			utils.ErrorJSON(w, err, http.StatusConflict)
+			return
+		}
 		if err != nil {
 			utils.ErrorJSON(w, err)
 			return
 		}
 
-		response := utils.JSONResponse{Data: userID, Message: "user successfully updated"}
+		// A new email address is only used once it is confirmed through the emailed link
+		message := "user successfully updated"
+		if changeEmail {
+			if err := app.RequestEmailChange(user, payload.Email); err != nil {
+				emailChangeErrorJSON(w, err)
+				return
+			}
+			message = "user successfully updated, the new email address is used once it is confirmed with the link sent to it"
+		}
+
+		response := utils.JSONResponse{Data: userID, Message: message}
 		_ = utils.WriteJSON(w, http.StatusOK, response)
 	}
 }
 
+// emailChangeErrorJSON writes the response for a rejected email change.
+//
+// Parameters:
+// - w: The HTTP response writer.
+// - err: The error returned by ValidateEmailChange or RequestEmailChange.
+func emailChangeErrorJSON(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, application.ErrInvalidEmail):
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+	case errors.Is(err, repositories.ErrUserExists):
+		utils.ErrorJSON(w, errors.New("email address is already in use"), http.StatusConflict)
+	default:
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+	}
+}
+
+// DeleteOwnUserData deletes the account of the logged-in user. The account can no longer
+// log in and is purged with all of its data once the deletion grace period is over.
+//
+// Parameters:
+// - app: A pointer to the application context containing repositories.
+//
+// Returns:
+// - http.HandlerFunc: An HTTP handler function that deletes the own account.
 func DeleteOwnUserData(app *application.Application) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get the identity from jwt claims
@@ -220,31 +274,16 @@ func DeleteOwnUserData(app *application.Application) http.HandlerFunc {
 			return
 		}
 
-		// Delete the user (this will also delete the associated Mode due to cascading)
-		err = app.Repository.DeleteUserByID(userID)
-		if err != nil {
-			utils.ErrorJSON(w, err)
-			return
-		}
-
-		// Delete the user's profile image as well from the database
-		err = app.Repository.DeleteProfileImageByUserID(userID)
+		// Mark the account as deleted, it is purged once the grace period is over
+		purgeAt, err := app.DeleteAccount(userID)
 		if err != nil {
-			log.Println("Deleting the user profile image from database")
 			utils.ErrorJSON(w, err)
 			return
 		}
 
-		// Delete the image file as well
-		err = controllers.DeleteProfileImageFile(app.Root, userID)
-		if err != nil {
-			log.Println("Deleting the user profile image file")
-			utils.ErrorJSON(w, fmt.Errorf("could not delete the image file: %v", err))
-			return
-		}
-
 		resp := utils.JSONResponse{
-			Data: "Successfully deleted the user and the associated mode",
+			Message: fmt.Sprintf("account deleted, it can be restored until %s", purgeAt.UTC().Format(time.RFC3339)),
+			Data:    map[string]time.Time{"purge_at": purgeAt},
 		}
 
 		_ = utils.WriteJSON(w, http.StatusAccepted, resp)
@@ -0,0 +1,63 @@
+package handlers_test
+
+import (
+	"TriceraPass/internal/testenv"
+	"TriceraPass/pkg/client"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Test that deleted accounts can be restored during the grace period, by the owner and by admins
+func TestAccountDeletion(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	env.Register(t, "hammond", "spared-no-expense")
+	nedryID := env.Register(t, "nedry", "ah-ah-ah-magic-word")
+	env.Mail.WaitFor(t, 2)
+	admin := env.Login(t, "hammond", "spared-no-expense")
+	c := env.Login(t, "nedry", "ah-ah-ah-magic-word")
+
+	if err := c.DeleteOwnAccount(ctx, nedryID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetUserByID(ctx, nedryID); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected the deletion to log out every session, got %v", err)
+	}
+	if _, err := client.New(env.Server.URL).Login(ctx, "nedry@jurassic.park", "ah-ah-ah-magic-word"); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected the deleted account to be unable to log in, got %v", err)
+	}
+
+	// The owner restores with the password, an admin without
+	if err := c.RestoreAccount(ctx, "nedry@jurassic.park", "wrong-password"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected a wrong password to be rejected, got %v", err)
+	}
+	if err := c.RestoreAccount(ctx, "nedry@jurassic.park", "ah-ah-ah-magic-word"); err != nil {
+		t.Fatal(err)
+	}
+	env.Login(t, "nedry", "ah-ah-ah-magic-word")
+	if err := admin.RestoreUser(ctx, nedryID); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected an active account to be rejected, got %v", err)
+	}
+	if err := admin.DeleteUser(ctx, nedryID); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.RestoreUser(ctx, nedryID); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.DeleteUser(ctx, nedryID); err != nil {
+		t.Fatal(err)
+	}
+
+	env.App.Config.Security.AccountDeletion.GracePeriod = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	var apiErr *client.APIError
+	if err := admin.RestoreUser(ctx, nedryID); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusGone {
+		t.Errorf("expected the restore after the grace period to be rejected, got %v", err)
+	}
+
+	env.Login(t, "hammond", "spared-no-expense")
+}
@@ -0,0 +1,144 @@
+package handlers_test
+
+import (
+	"TriceraPass/cmd/api/application"
+	"TriceraPass/cmd/api/proxy"
+	"TriceraPass/cmd/api/server"
+	"TriceraPass/internal/testenv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test that the forward-auth endpoint accepts the access token cookie set at login and
+// never a refresh token, neither in its own cookie nor in the access cookie
+func TestVerifyRequestAccessCookie(t *testing.T) {
+	env := testenv.New(t)
+	userID := env.Register(t, "muldoon", "clever-girl-1993")
+
+	resp, err := http.Post(env.Server.URL+"/auth/api/login", "application/json",
+		strings.NewReader(`{"email":"muldoon@jurassic.park","password":"clever-girl-1993"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range resp.Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	access, refresh := cookies["access_token"], cookies["refresh_token"]
+	if access == nil || refresh == nil {
+		t.Fatalf("expected the login to set the access and refresh cookies, got %v", resp.Cookies())
+	}
+	if !access.HttpOnly || !access.Secure {
+		t.Errorf("expected an HttpOnly and Secure access cookie, got %+v", access)
+	}
+
+	verify := func(cookies ...*http.Cookie) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, env.Server.URL+"/auth/api/verify", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := verify(&http.Cookie{Name: "access_token", Value: access.Value}); resp.StatusCode != http.StatusOK || resp.Header.Get("X-User-Id") != userID {
+		t.Errorf("expected the access cookie to authenticate, got %d with user %q", resp.StatusCode, resp.Header.Get("X-User-Id"))
+	}
+	if resp := verify(&http.Cookie{Name: "refresh_token", Value: refresh.Value}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the refresh cookie to be rejected, got %d", resp.StatusCode)
+	}
+	if resp := verify(&http.Cookie{Name: "access_token", Value: refresh.Value}); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a refresh token in the access cookie to be rejected, got %d", resp.StatusCode)
+	}
+
+	// Logging out expires the access cookie
+	req, _ := http.NewRequest(http.MethodPost, env.Server.URL+"/auth/api/logged_in/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+access.Value)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	expired := false
+	for _, cookie := range resp.Cookies() {
+		expired = expired || cookie.Name == "access_token" && cookie.MaxAge < 0
+	}
+	if !expired {
+		t.Errorf("expected the logout to expire the access cookie, got %v", resp.Cookies())
+	}
+}
+
+// Test the browser flow through the embedded proxy: the protected page redirects to the
+// hosted login page, and after logging in the access cookie opens the page
+func TestProxyBrowserLogin(t *testing.T) {
+	env := testenv.New(t)
+	env.Register(t, "arnold", "hold-onto-your-butts")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello " + r.Header.Get(proxy.HeaderUserEmail)))
+	}))
+	t.Cleanup(upstream.Close)
+
+	// The proxy routes are mounted when the routes are built
+	env.App.Config.Proxy.Enabled = true
+	env.App.Config.Proxy.LoginURL = "/auth/api/login"
+	env.App.Config.Proxy.IdentitySecret = "identity-secret"
+	env.App.Config.Proxy.Routes = []application.ProxyRoute{{Prefix: "/park", Upstream: upstream.URL}}
+	srv := httptest.NewServer(server.Routes(env.App))
+	t.Cleanup(srv.Close)
+
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	navigate := func(path string, cookies []*http.Cookie) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Accept", "text/html")
+		for _, cookie := range cookies {
+			req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+		}
+		resp, err := browser.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := navigate("/park/visitor-center", nil)
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusFound || !strings.HasPrefix(location, "/auth/api/login?redirect=") {
+		t.Fatalf("expected a redirect to the login page, got %d to %q", resp.StatusCode, location)
+	}
+
+	resp = navigate(location, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the login page, got %d", resp.StatusCode)
+	}
+
+	resp, err := http.Post(srv.URL+"/auth/api/login", "application/json",
+		strings.NewReader(`{"email":"arnold@jurassic.park","password":"hold-onto-your-butts"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected the login to succeed, got %d", resp.StatusCode)
+	}
+
+	resp = navigate("/park/visitor-center", resp.Cookies())
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello arnold@jurassic.park" {
+		t.Errorf("expected the upstream page after login, got %d %q", resp.StatusCode, body)
+	}
+}
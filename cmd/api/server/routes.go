@@ -5,8 +5,13 @@ package server
 
 import (
 	"TriceraPass/cmd/api/application"
+	"TriceraPass/cmd/api/proxy"
 	"TriceraPass/cmd/api/server/handlers"
+	"TriceraPass/internal/store"
+	"TriceraPass/pkg/verifier"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -23,68 +28,122 @@ import (
 func Routes(app *application.Application) http.Handler {
 	mux := chi.NewRouter()
 
+	if app.Store == nil {
+		app.Store = store.NewMemory()
+	}
+
 	// Middleware
 	mux.Use(middleware.Recoverer) // Recovers from panics in the middleware chain
 	mux.Use(middleware.Logger)    // Logs requests
 	mux.Use(app.EnableCORS)       // Custom CORS middleware
+	mux.Use(app.RateLimit(""))    // Default rate limit of every route
 
 	// Serve static assets for documentation
 	fs := http.FileServer(http.Dir("./template/docs/assets"))
 	mux.Handle("/assets/*", http.StripPrefix("/assets/", fs))
 
 	// Authentication routes
-	mux.Get("/auth/api/", handlers.Home(app))                     // Home page for the auth API
-	mux.Post("/auth/api/login", handlers.Authenticate(app))       // Login route
-	mux.Post("/auth/api/refresh", handlers.RefreshToken(app))     // Token refresh route
-	mux.Post("/auth/api/register", handlers.RegisterNewUser(app)) // User registration route
+	mux.Get("/auth/api/", handlers.Home(app))                                                        // Home page for the auth API
+	mux.With(app.RateLimit("login")).Post("/auth/api/login", handlers.Authenticate(app))             // Login route
+	mux.Get("/auth/api/login", handlers.LoginPage(app))                                              // Hosted login page
+	mux.Post("/auth/api/refresh", handlers.RefreshToken(app))                                        // Token refresh route
+	mux.With(app.RateLimit("register")).Post("/auth/api/register", handlers.RegisterNewUser(app))    // User registration route
+	mux.Get("/auth/api/unlock", handlers.UnlockPage(app))                                            // Unlock link from the account locked email, asks to confirm
+	mux.Post("/auth/api/unlock", handlers.UnlockAccount(app))                                        // Unlock with the token from the email
+	mux.With(app.RateLimit("login")).Post("/auth/api/account/restore", handlers.RestoreAccount(app)) // Restore a deleted account within the grace period
+
+	// Forward-auth route for reverse proxies (nginx auth_request / Traefik ForwardAuth)
+	mux.HandleFunc("/auth/api/verify", handlers.VerifyRequest(app)) // Verify the caller's token and return identity headers
 
 	// Email confirmation routes
-	mux.Post("/auth/api/confirmation/{user_id}", handlers.ConfirmUser(app))             // Confirm user by user ID
-	mux.Get("/auth/api/confirmation/user/{user_id}", handlers.GetLastConfirmation(app)) // Get last confirmation for a user by user ID
-	mux.Get("/auth/api/user/{user_email}", handlers.GetUserByEmail(app))                // Get user by email
+	mux.Get("/auth/api/confirmation", handlers.ConfirmationPage(app))                                                      // Confirmation link of the email, renders the outcome
+	mux.Post("/auth/api/confirmation", handlers.ConfirmEmail(app))                                                         // Confirm with the token from the email
+	mux.With(app.RateLimit("confirmation_resend")).Post("/auth/api/confirmation/resend", handlers.ResendConfirmation(app)) // Send the confirmation email again
+	mux.Get("/auth/api/confirmation/user/{user_id}", handlers.GetLastConfirmation(app))                                    // Get last confirmation for a user by user ID
+	mux.Get("/auth/api/user/{user_email}", handlers.GetUserByEmail(app))                                                   // Get user by email
+
+	// Email address change routes
+	mux.Get("/auth/api/email_change/confirm", handlers.ConfirmEmailChangePage(app)) // Link sent to the new address, asks to confirm
+	mux.Post("/auth/api/email_change/confirm", handlers.ConfirmEmailChange(app))    // Confirm the change with the token sent to the new address
+	mux.Get("/auth/api/email_change/revert", handlers.RevertEmailChangePage(app))   // Link sent to the old address, asks to confirm
+	mux.Post("/auth/api/email_change/revert", handlers.RevertEmailChange(app))      // Undo the change with the token sent to the old address
+
+	// Data export download link from the email
+	mux.Get("/auth/api/export/download", handlers.DownloadDataExport(app)) // Download the archive with the emailed token
 
 	// Password reset routes
-	mux.Post("/auth/api/send_password_email", handlers.SendForgottenPasswordEmail(app))                // Send password reset email
-	mux.Post("/auth/api/user/password_reset/{user_id}", handlers.ChangePasswordByUserID(app))          // Reset password by user ID
-	mux.Get("/auth/api/user/password_reset/token/{user_id}", handlers.FetchPasswordTokenByUserID(app)) // Fetch password reset token by user ID
-	mux.Post("/auth/api/user/password_reset/token/use/{user_id}", handlers.SetTokenToUsed(app))        // Set the token to used after password reset
+	mux.Get("/auth/api/password/policy", handlers.GetPasswordPolicy(app))                                                     // Get the password policy
+	mux.With(app.RateLimit("password_email")).Post("/auth/api/send_password_email", handlers.SendForgottenPasswordEmail(app)) // Send password reset email
+	mux.Post("/auth/api/password/reset", handlers.ResetPasswordWithToken(app))                                                // Reset password with the emailed token
 
 	// Protected routes (require authentication)
 	mux.Route("/auth/api/logged_in", func(mux chi.Router) {
-		mux.Use(app.AuthRequired) // Middleware to require authentication
+		// The only route open to users whose password has to be changed first
+		mux.With(app.AuthAllowing(verifier.RestrictionPasswordChange)).
+			Post("/password", handlers.ChangeOwnPassword(app)) // Change the own password, proving the current one
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.AuthRequired) // Middleware to require authentication
 
-		mux.Post("/logout", handlers.Logout(app)) // Logout route
+			mux.Post("/logout", handlers.Logout(app)) // Logout route
 
-		// User-related routes
-		mux.Get("/user/{user_email}", handlers.GetUserByEmail(app))                // Get user by email
-		mux.Get("/user/{user_id}", handlers.GetUserByID(app))                      // Get user by user ID
-		mux.Get("/user/profile/{filename}", handlers.ServeStaticProfileImage(app)) // Serve static profile image
+			// User-related routes
+			mux.Get("/user/{user_email}", handlers.GetUserByEmail(app))                // Get user by email
+			mux.Get("/user/{user_id}", handlers.GetUserByID(app))                      // Get user by user ID
+			mux.Get("/user/profile/{filename}", handlers.ServeStaticProfileImage(app)) // Serve static profile image
 
-		mux.Patch("/user/{user_id}", handlers.Updateuser(app))                                // Update user by user ID
-		mux.Post("/user/password_reset/{user_id}", handlers.ChangePasswordByUserID(app))      // Reset password by user ID
-		mux.Post("/user/send_password_email/{user_id}", handlers.SendPasswordResetEmail(app)) // Send password reset email to user by user ID
+			mux.Patch("/user/{user_id}", handlers.Updateuser(app))                                                                      // Update user by user ID
+			mux.With(app.RateLimit("password_email")).Post("/user/send_password_email/{user_id}", handlers.SendPasswordResetEmail(app)) // Send password reset email to user by user ID
 
-		// Profile image upload
-		mux.Post("/upload/profile", handlers.UploadProfileImage(app)) // Upload user profile image
+			// Profile image upload
+			mux.Post("/upload/profile", handlers.UploadProfileImage(app)) // Upload user profile image
 
-		mux.Delete("/user/{user_id}", handlers.DeleteOwnUserData(app))
+			mux.With(app.RateLimit("data_export")).Post("/export", handlers.RequestDataExport(app)) // Build an archive of the own data, the link is emailed
+
+			mux.Delete("/user/{user_id}", handlers.DeleteOwnUserData(app))
+		})
 	})
 
 	mux.Route("/auth/api/admin", func(mux chi.Router) {
 		mux.Use(app.AdminRequired) // Middleware to require admin user level
 
-		mux.Get("/user/modes", handlers.GetAllUserModes(app)) // Get all the user modes
-		mux.Get("/users", handlers.GetAllUsers(app))          // Get all the users data
-
-		mux.Post("/user/mode", handlers.CreateUserMode(app)) // Create a user mode via admin
+		mux.Get("/user/modes", handlers.GetAllUserModes(app))        // Get all the user modes
+		mux.Get("/users", handlers.GetAllUsers(app))                 // Get all the users data
+		mux.Get("/metrics/hashing", handlers.GetHashingMetrics(app)) // Password hashing pool metrics
+		mux.Get("/invites", handlers.AdminGetInvites(app))           // List the registration invites
+		mux.Get("/approvals", handlers.AdminGetPendingUsers(app))    // List the users waiting for approval
+
+		mux.Post("/user/mode", handlers.CreateUserMode(app))                      // Create a user mode via admin
+		mux.Post("/users/import", handlers.AdminImportUsers(app))                 // Import users with their password hashes
+		mux.Post("/user/{user_id}/unlock", handlers.AdminUnlockUser(app))         // Lift the login lockout of a user
+		mux.Post("/user/{user_id}/restore", handlers.AdminRestoreUser(app))       // Restore a deleted user within the grace period
+		mux.Post("/user/{user_id}/suspend", handlers.AdminSuspendUser(app))       // Suspend or lock a user, logs out every session
+		mux.Post("/user/{user_id}/reactivate", handlers.AdminReactivateUser(app)) // Lift the suspension or lock of a user
+		mux.Post("/invites", handlers.AdminCreateInvite(app))                     // Create a registration invite, emailed if it is for an address
+		mux.Post("/user/{user_id}/approve", handlers.AdminApproveUser(app))       // Approve a user waiting for approval
+		mux.Post("/user/{user_id}/reject", handlers.AdminRejectUser(app))         // Reject a user waiting for approval
 
 		mux.Patch("/user/mode/{mode_id}", handlers.UpdateUserMode(app)) // Update the user mode
 
-		mux.Delete("/users", handlers.AdminDeleteAllUsers(app))          // Delete all the users
-		mux.Delete("/user/{user_id}", handlers.AdminDeleteUserByID(app)) // Logout route
-		mux.Delete("/user/mode/{mode_id}", handlers.DeleteUserMode(app)) // Delete a user mode via admin
+		mux.Delete("/users", handlers.AdminDeleteAllUsers(app))             // Delete all the users
+		mux.Delete("/user/{user_id}", handlers.AdminDeleteUserByID(app))    // Delete a user, purged after the grace period
+		mux.Delete("/user/mode/{mode_id}", handlers.DeleteUserMode(app))    // Delete a user mode via admin
+		mux.Delete("/invites/{invite_id}", handlers.AdminRevokeInvite(app)) // Revoke a registration invite
 
 	})
 
+	// Embedded authenticating reverse proxy routes
+	if app.Config != nil && app.Config.Proxy.Enabled {
+		for _, route := range app.Config.Proxy.Routes {
+			handler, err := proxy.New(app, route)
+			if err != nil {
+				log.Fatal(err)
+			}
+			prefix := strings.TrimSuffix(route.Prefix, "/")
+			mux.Handle(prefix, handler)
+			mux.Handle(prefix+"/*", handler)
+		}
+	}
+
 	return mux
 }
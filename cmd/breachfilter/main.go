@@ -0,0 +1,92 @@
+// Command breachfilter builds the compact bloom filter used for offline breached
+// password screening from a Have I Been Pwned SHA-1 file of "HASH:COUNT" lines.
+//
+//	go run ./cmd/breachfilter -in pwned-passwords-sha1-ordered-by-hash.txt -out breached.bloom -min-count 10
+//
+// Point security.breached_passwords.path at the output file and set its format to "bloom".
+package main
+
+import (
+	"TriceraPass/cmd/api/controllers"
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	var in, out string
+	var minCount int
+	var falsePositiveRate float64
+
+	flag.StringVar(&in, "in", "", "HIBP SHA-1 file with HASH:COUNT lines")
+	flag.StringVar(&out, "out", "breached.bloom", "Bloom filter file to write")
+	flag.IntVar(&minCount, "min-count", 1, "Only include hashes seen at least this many times")
+	flag.Float64Var(&falsePositiveRate, "fp-rate", 0.001, "Accepted false positive rate")
+	flag.Parse()
+
+	if in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// First pass sizes the filter for the hashes that pass the threshold
+	var expected uint64
+	err := eachHash(in, minCount, func([20]byte) { expected++ })
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	filter := controllers.NewBloomFilter(expected, falsePositiveRate)
+	err = eachHash(in, minCount, filter.AddHash)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	writer := bufio.NewWriter(file)
+	size, err := filter.WriteTo(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("wrote %d hashes to %s (%d bytes)\n", expected, out, size)
+}
+
+// eachHash calls fn for every hash in the file seen at least minCount times.
+func eachHash(path string, minCount int, fn func([20]byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		sum, count, err := controllers.ParseHashLine(text)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if count >= minCount {
+			fn(sum)
+		}
+	}
+	return scanner.Err()
+}
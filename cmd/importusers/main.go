@@ -0,0 +1,68 @@
+// Command importusers uploads a user export from Firebase Auth, Auth0 or Django to a
+// running TriceraPass server, keeping the users' password hashes.
+//
+//	go run ./cmd/importusers -url http://localhost:8080 -email admin@example.com -format firebase -project my-app -file users.json
+//
+// The admin password is read from the TRICERAPASS_ADMIN_PASSWORD environment variable.
+// Firebase projects need their hash parameters under security.password_hashing.firebase
+// in the server's settings.yml. Run with -dry-run first to see which users would be skipped.
+package main
+
+import (
+	"TriceraPass/pkg/client"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	var baseURL, email, format, project, file string
+	var dryRun bool
+
+	flag.StringVar(&baseURL, "url", "http://localhost:8080", "TriceraPass server URL")
+	flag.StringVar(&email, "email", "", "Email of an admin user")
+	flag.StringVar(&format, "format", "", "Export format: firebase, auth0, csv or json")
+	flag.StringVar(&project, "project", "", "Firebase project the hash parameters are configured under")
+	flag.StringVar(&file, "file", "", "Export file to import")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only check the export, import nothing")
+	flag.Parse()
+
+	password := os.Getenv("TRICERAPASS_ADMIN_PASSWORD")
+	if email == "" || password == "" || format == "" || file == "" {
+		fmt.Fprintln(os.Stderr, "-email, -format, -file and TRICERAPASS_ADMIN_PASSWORD are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	export, err := os.Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer export.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	c := client.New(baseURL, client.WithHTTPClient(&http.Client{Timeout: 30 * time.Minute}))
+	if _, err := c.Login(ctx, email, password); err != nil {
+		log.Fatalf("Error logging in: %v", err)
+	}
+
+	report, err := c.ImportUsers(ctx, format, project, export, dryRun)
+	if err != nil {
+		log.Fatalf("Error importing users: %v", err)
+	}
+
+	for _, failure := range report.Failed {
+		fmt.Printf("row %d (%s): %s\n", failure.Row, failure.Email, failure.Reason)
+	}
+	verb := "imported"
+	if report.DryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d of %d users, %d skipped\n", verb, report.Imported, report.Total, len(report.Failed))
+}
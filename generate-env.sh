@@ -59,6 +59,7 @@ POSTGRES_DB=$(yq e '.database.dbname' $SETTINGS_FILE)
 MAIL_SERVER_NAME=$(yq e '.email_server.server_name' $SETTINGS_FILE)
 MAIL_SERVER_API_KEY=$(yq e '.email_server.api_key' $SETTINGS_FILE)
 MAIL_SERVER_DOMAIN=$(yq e '.email_server.domain' $SETTINGS_FILE)
+MAIL_SERVER_API_BASE=$(yq e '.email_server.api_base' $SETTINGS_FILE)
 
 # Extract the server port from settings.yml
 AUTH_SERVICE_PORT=$(yq e '.server.port' $SETTINGS_FILE)
@@ -83,6 +84,7 @@ AUTH_SERVICE_PORT=$AUTH_SERVICE_PORT
 MAIL_SERVER_NAME=$MAIL_SERVER_NAME
 MAIL_SERVER_API_KEY=$MAIL_SERVER_API_KEY
 MAIL_SERVER_DOMAIN=$MAIL_SERVER_DOMAIN
+MAIL_SERVER_API_BASE=$MAIL_SERVER_API_BASE
 CORS=$CORS
 LOGGING_LEVEL=$LOGGING_LEVEL
 EOL
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// States of a data export.
+const (
+	DataExportPending = "pending" // The archive is being built
+	DataExportReady   = "ready"   // The archive can be downloaded with the emailed link
+	DataExportFailed  = "failed"  // The archive could not be built
+)
+
+// DataExport is a ZIP archive of a user's data, built in the background and downloaded
+// with a time-limited link.
+type DataExport struct {
+	ID          string     `gorm:"type:uuid;primary_key" json:"id"`
+	UserID      string     `gorm:"index" json:"user_id"`
+	Status      string     `json:"status"`
+	FilePath    string     `json:"-"`
+	TokenHash   string     `gorm:"index" json:"-"` // SHA-256 of the token in the download link, set once the archive is ready
+	ExpiredAt   int64      `json:"expired_at"`     // Unix time after which the archive is removed
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// IsExpired reports whether the download link of a ready export expired.
+func (de *DataExport) IsExpired() bool {
+	return de.Status == DataExportReady && time.Now().Unix() > de.ExpiredAt
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Invite lets people register while registration is invite-only. Only a hash of the
+// code is stored, the code itself is shown once to the admin who created the invite.
+type Invite struct {
+	ID        string     `gorm:"type:uuid;primary_key" json:"id"`
+	CodeHash  string     `gorm:"uniqueIndex" json:"-"` // SHA-256 of the invite code
+	Email     string     `json:"email,omitempty"`      // Only this address can use the invite, any address if empty
+	ModeName  string     `json:"mode_name,omitempty"`  // Mode of the users registering with the invite, default if empty
+	MaxUses   int        `json:"max_uses"`             // How many users can register with the invite, 0 for no limit
+	Uses      int        `json:"uses"`
+	ExpiredAt int64      `json:"expired_at"` // Unix time after which the invite is rejected, 0 if it does not expire
+	CreatedBy string     `json:"created_by"` // ID of the admin who created the invite
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Usable reports whether someone can still register with the invite.
+func (i *Invite) Usable() bool {
+	if i.RevokedAt != nil || (i.MaxUses > 0 && i.Uses >= i.MaxUses) {
+		return false
+	}
+	return i.ExpiredAt == 0 || time.Now().Unix() <= i.ExpiredAt
+}
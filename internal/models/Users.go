@@ -1,23 +1,79 @@
 package models
 
 import (
-	"errors"
+	"TriceraPass/internal/passwords"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
 	"gorm.io/gorm"
 )
 
+// Account states of a user.
+const (
+	UserStatusActive          = "active"
+	UserStatusSuspended       = "suspended"        // Blocked by an admin, e.g. for violating the terms of use
+	UserStatusLocked          = "locked"           // Blocked by an admin for security reasons, e.g. a compromised account
+	UserStatusPendingDeletion = "pending_deletion" // Deleted, waits for its purge
+	UserStatusPendingApproval = "pending_approval" // Registered, waits for an admin to approve it
+	UserStatusRejected        = "rejected"         // Registration rejected by an admin
+)
+
 type User struct {
 	ID        string    `gorm:"type:uuid;primary_key"`
 	CreatedAt time.Time `json:"created_at"`
-	DeletedAt time.Time `json:"deleted_at"`
+	DeletedAt time.Time `json:"deleted_at"` // Set while the account waits for its purge, zero for active accounts
 	UserName  string    `json:"username"`
 	FirstName string    `json:"first_name"`
 	LastName  string    `json:"last_name"`
 	Email     string    `json:"email"`
 	Password  string    `json:"password"`
 	Mode      Mode      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;" json:"mode,omitempty"`
+	// PasswordRotationRequired is set when the current password was found in a breach list on login.
+	PasswordRotationRequired bool `json:"password_rotation_required"`
+	// PasswordChangedAt is when the current password was set, used for password expiry.
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+	// PasswordExpiryWarnedAt is when the user was last warned that the password expires soon.
+	PasswordExpiryWarnedAt *time.Time `json:"password_expiry_warned_at,omitempty"`
+	// SessionsRevokedAt invalidates every token issued before it, e.g. after a password reset.
+	SessionsRevokedAt *time.Time `json:"-"`
+
+	// Status is active, suspended or locked as set by admins, or pending_approval and rejected
+	// while registrations need an approval. Deleting an account leaves it as it is, so a
+	// restored account keeps its suspension, use CurrentStatus for the effective state.
+	Status          string     `gorm:"default:active" json:"status"`
+	StatusReason    string     `json:"status_reason,omitempty"`
+	StatusChangedBy string     `json:"status_changed_by,omitempty"` // ID of the admin who set the status
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+	StatusUntil     *time.Time `json:"status_until,omitempty"` // When a suspension or lock ends by itself, nil if it does not
+
+	rehashed bool // Set by PasswordMatches when the password hash was upgraded
+}
+
+// NormalizeEmail returns the form email addresses are stored and looked up in: trimmed,
+// in Unicode normalization form C, lowercased and without a trailing dot on the domain,
+// so Alice@Example.com and alice@example.com are the same account.
+//
+// Parameters:
+// - email: The address as entered.
+//
+// Returns:
+// - string: The normalized address.
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(norm.NFC.String(strings.TrimSpace(email)))
+	return strings.TrimSuffix(email, ".")
+}
+
+// NormalizeUserName returns the form usernames are stored in. The case is kept for
+// display, usernames are unique regardless of it.
+//
+// Parameters:
+// - name: The username as entered.
+//
+// Returns:
+// - string: The normalized username.
+func NormalizeUserName(name string) string {
+	return norm.NFC.String(strings.TrimSpace(name))
 }
 
 type Mode struct {
@@ -29,7 +85,8 @@ type Mode struct {
 type UserConfirmation struct {
 	ID        string    `gorm:"type:uuid;primary_key" json:"id"`
 	UserID    string    `json:"user_id"`
-	ExpiredAt int64     `json:"expired_at"`
+	TokenHash string    `gorm:"index" json:"-"` // SHA-256 of the token in the confirmation link, the token itself is never stored
+	ExpiredAt int64     `json:"expired_at"`     // Unix time after which the token is rejected
 	CreatedAt time.Time `json:"created_at"`
 	Confirmed bool      `json:"confirmed"`
 }
@@ -37,11 +94,48 @@ type UserConfirmation struct {
 type PasswordRestToken struct {
 	ID        string    `gorm:"type:uuid;primary_key" json:"id"`
 	UserID    string    `json:"user_id"`
-	ExpiredAt int64     `json:"expired_at"`
+	TokenHash string    `gorm:"uniqueIndex" json:"-"` // SHA-256 of the token sent by email, the token itself is never stored
+	ExpiredAt int64     `json:"expired_at"`           // Unix time after which the token is rejected
 	CreatedAt time.Time `json:"created_at"`
 	TokenUsed bool      `json:"token_used"`
 }
 
+// EmailChange is a requested change of a user's email address. The address is only
+// swapped once the link sent to the new address is confirmed, and the link sent to the
+// old address undoes the change until RevertExpiredAt.
+type EmailChange struct {
+	ID              string     `gorm:"type:uuid;primary_key" json:"id"`
+	UserID          string     `gorm:"index" json:"user_id"`
+	OldEmail        string     `json:"old_email"`
+	NewEmail        string     `json:"new_email"`
+	TokenHash       string     `gorm:"uniqueIndex" json:"-"` // SHA-256 of the token sent to the new address
+	RevertTokenHash string     `gorm:"uniqueIndex" json:"-"` // SHA-256 of the token sent to the old address
+	ExpiredAt       int64      `json:"expired_at"`           // Unix time after which the change can no longer be confirmed
+	RevertExpiredAt int64      `json:"revert_expired_at"`    // Unix time after which the change can no longer be reverted
+	CreatedAt       time.Time  `json:"created_at"`
+	ConfirmedAt     *time.Time `json:"confirmed_at,omitempty"`
+	RevertedAt      *time.Time `json:"reverted_at,omitempty"`
+}
+
+// IsExpired reports whether the change can no longer be confirmed.
+func (ec *EmailChange) IsExpired() bool {
+	return time.Now().Unix() > ec.ExpiredAt
+}
+
+// IsRevertExpired reports whether the change can no longer be reverted.
+func (ec *EmailChange) IsRevertExpired() bool {
+	return time.Now().Unix() > ec.RevertExpiredAt
+}
+
+// PasswordHistory keeps a previous password hash of a user to prevent its reuse.
+type PasswordHistory struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"index" json:"user_id"`
+	Password  string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IsTokenExpired reports whether the reset token is past its expiry time.
 func (pwToken *PasswordRestToken) IsTokenExpired() bool {
 	// Convert the Unix timestamp to a time.Time object
 	expirationTime := time.Unix(pwToken.ExpiredAt, 0).UTC()
@@ -53,12 +147,6 @@ func (pwToken *PasswordRestToken) IsTokenExpired() bool {
 	return currentTime.After(expirationTime)
 }
 
-func (pwToken *PasswordRestToken) SetTokenExpire() {
-	if !pwToken.IsTokenExpired() {
-		pwToken.ExpiredAt = time.Now().UTC().UnixNano()
-	}
-}
-
 // Define a function to check if a given time has expired
 func (uc *UserConfirmation) IsExpired() bool {
 	// Convert the Unix timestamp to a time.Time object
@@ -71,22 +159,61 @@ func (uc *UserConfirmation) IsExpired() bool {
 	return currentTime.After(expirationTime)
 }
 
-func (uc *UserConfirmation) SetExpire() {
-	if !uc.IsExpired() {
-		uc.ExpiredAt = time.Now().UTC().UnixNano()
+// PasswordMatches checks a plain-text password against the stored hash. When the
+// password matches but the hash uses an outdated algorithm or parameters, the hash
+// is replaced in memory with a fresh one and PasswordRehashed reports true, so the
+// caller can persist it.
+func (u *User) PasswordMatches(plainText string) (bool, error) {
+	valid, err := passwords.Verify(u.Password, plainText)
+	if err != nil || !valid {
+		return false, err
 	}
-}
 
-func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
+	if passwords.NeedsRehash(u.Password) {
+		if hash, err := passwords.Hash(plainText); err == nil {
+			u.Password = hash
+			u.rehashed = true
 		}
 	}
 	return true, nil
 }
+
+// PasswordRehashed reports whether PasswordMatches replaced an outdated hash.
+func (u *User) PasswordRehashed() bool {
+	return u.rehashed
+}
+
+// TokenRevoked reports whether a token issued at the given time was revoked.
+func (u *User) TokenRevoked(issuedAt time.Time) bool {
+	return u.SessionsRevokedAt != nil && issuedAt.Unix() < u.SessionsRevokedAt.Unix()
+}
+
+// IsDeleted reports whether the account was deleted and waits for its purge.
+func (u *User) IsDeleted() bool {
+	return !u.DeletedAt.IsZero()
+}
+
+// CurrentStatus returns the effective status of the account: pending_deletion while a
+// deleted account waits for its purge, and active once a suspension or lock is over.
+func (u *User) CurrentStatus() string {
+	switch {
+	case u.IsDeleted():
+		return UserStatusPendingDeletion
+	case u.Status == "":
+		return UserStatusActive
+	case u.Status == UserStatusSuspended || u.Status == UserStatusLocked:
+		if u.StatusUntil != nil && !time.Now().Before(*u.StatusUntil) {
+			return UserStatusActive
+		}
+	}
+	return u.Status
+}
+
+// PasswordSetAt returns when the current password was set, falling back to the
+// account creation for users created before the change was tracked.
+func (u *User) PasswordSetAt() time.Time {
+	if u.PasswordChangedAt.IsZero() {
+		return u.CreatedAt
+	}
+	return u.PasswordChangedAt
+}
@@ -0,0 +1,131 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Default argon2id parameters, following the OWASP recommendation for servers
+// with little memory to spare.
+const (
+	DefaultArgon2idMemory      = 64 * 1024 // KiB
+	DefaultArgon2idIterations  = 3
+	DefaultArgon2idParallelism = 2
+	DefaultArgon2idSaltLength  = 16
+	DefaultArgon2idKeyLength   = 32
+)
+
+// errInvalidArgon2id is returned for malformed argon2id PHC strings.
+var errInvalidArgon2id = errors.New("invalid argon2id hash")
+
+// Argon2idParams are the cost parameters of argon2id.
+type Argon2idParams struct {
+	Memory      uint32 `yaml:"memory"`      // Memory in KiB
+	Iterations  uint32 `yaml:"iterations"`  // Number of passes over the memory
+	Parallelism uint8  `yaml:"parallelism"` // Number of lanes
+	SaltLength  uint32 `yaml:"salt_length"` // Salt length in bytes
+	KeyLength   uint32 `yaml:"key_length"`  // Hash length in bytes
+}
+
+// Argon2id hashes passwords with argon2id into PHC strings such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+type Argon2id struct {
+	params Argon2idParams
+}
+
+// NewArgon2id creates the argon2id algorithm, unset parameters fall back to the defaults.
+func NewArgon2id(params Argon2idParams) *Argon2id {
+	if params.Memory == 0 {
+		params.Memory = DefaultArgon2idMemory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = DefaultArgon2idIterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = DefaultArgon2idParallelism
+	}
+	if params.SaltLength == 0 {
+		params.SaltLength = DefaultArgon2idSaltLength
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = DefaultArgon2idKeyLength
+	}
+	return &Argon2id{params: params}
+}
+
+// Hash implements Algorithm.
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.params.Memory, a.params.Iterations, a.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify implements Algorithm.
+func (a *Argon2id) Verify(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+// Identifies implements Algorithm.
+func (a *Argon2id) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// Outdated implements Algorithm.
+func (a *Argon2id) Outdated(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != a.params.Memory ||
+		params.Iterations != a.params.Iterations ||
+		params.Parallelism != a.params.Parallelism ||
+		uint32(len(salt)) != a.params.SaltLength ||
+		uint32(len(key)) != a.params.KeyLength
+}
+
+// decodeArgon2id splits a PHC string into its parameters, salt and key.
+func decodeArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errInvalidArgon2id
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidArgon2id
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, errInvalidArgon2id
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errInvalidArgon2id
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidArgon2id
+	}
+	return params, salt, key, nil
+}
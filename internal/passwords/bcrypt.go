@@ -0,0 +1,56 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultBcryptCost is used when no bcrypt cost is configured.
+const DefaultBcryptCost = 12
+
+// BcryptParams are the cost parameters of bcrypt.
+type BcryptParams struct {
+	Cost int `yaml:"cost"` // Logarithmic work factor between 4 and 31
+}
+
+// Bcrypt hashes passwords with bcrypt into modular crypt strings such as $2a$12$<salt+hash>.
+type Bcrypt struct {
+	cost int
+}
+
+// NewBcrypt creates the bcrypt algorithm, an unset or invalid cost falls back to the default.
+func NewBcrypt(params BcryptParams) *Bcrypt {
+	cost := params.Cost
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = DefaultBcryptCost
+	}
+	return &Bcrypt{cost: cost}
+}
+
+// Hash implements Algorithm.
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	return string(hash), err
+}
+
+// Verify implements Algorithm.
+func (b *Bcrypt) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Identifies implements Algorithm.
+func (b *Bcrypt) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// Outdated implements Algorithm.
+func (b *Bcrypt) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
@@ -0,0 +1,96 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// DefaultDjangoIterations is the PBKDF2 iteration count of Django 4.2.
+const DefaultDjangoIterations = 600000
+
+// errInvalidDjango is returned for malformed Django PBKDF2 hashes.
+var errInvalidDjango = errors.New("invalid django pbkdf2_sha256 hash")
+
+// DjangoParams are the cost parameters of Django's PBKDF2 hasher.
+type DjangoParams struct {
+	Iterations int `yaml:"iterations"` // PBKDF2-SHA256 iterations
+}
+
+// DjangoPBKDF2 verifies the default password hashes of Django, which look like
+// pbkdf2_sha256$<iterations>$<salt>$<base64 hash>. It exists so users imported from
+// Django keep their passwords until they are upgraded on the next login.
+type DjangoPBKDF2 struct {
+	iterations int
+}
+
+// NewDjangoPBKDF2 creates the Django PBKDF2 algorithm, an unset iteration count falls back to the default.
+func NewDjangoPBKDF2(params DjangoParams) *DjangoPBKDF2 {
+	if params.Iterations <= 0 {
+		params.Iterations = DefaultDjangoIterations
+	}
+	return &DjangoPBKDF2{iterations: params.Iterations}
+}
+
+// Hash implements Algorithm.
+func (d *DjangoPBKDF2) Hash(password string) (string, error) {
+	// Django salts are 22 alphanumeric characters
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	buf := make([]byte, 22)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = alphabet[int(b)%len(alphabet)]
+	}
+	salt := string(buf)
+	key := pbkdf2.Key([]byte(password), []byte(salt), d.iterations, sha256.Size, sha256.New)
+	return fmt.Sprintf("pbkdf2_sha256$%d$%s$%s", d.iterations, salt, base64.StdEncoding.EncodeToString(key)), nil
+}
+
+// Verify implements Algorithm.
+func (d *DjangoPBKDF2) Verify(encoded, password string) (bool, error) {
+	iterations, salt, hash, err := decodeDjango(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := pbkdf2.Key([]byte(password), []byte(salt), iterations, len(hash), sha256.New)
+	return subtle.ConstantTimeCompare(key, hash) == 1, nil
+}
+
+// Identifies implements Algorithm.
+func (d *DjangoPBKDF2) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "pbkdf2_sha256$")
+}
+
+// Outdated implements Algorithm.
+func (d *DjangoPBKDF2) Outdated(encoded string) bool {
+	iterations, _, _, err := decodeDjango(encoded)
+	return err != nil || iterations != d.iterations
+}
+
+// decodeDjango splits a Django PBKDF2 hash into its iterations, salt and hash.
+func decodeDjango(encoded string) (int, string, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2_sha256" {
+		return 0, "", nil, errInvalidDjango
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return 0, "", nil, errInvalidDjango
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(hash) == 0 {
+		return 0, "", nil, errInvalidDjango
+	}
+	return iterations, parts[2], hash, nil
+}
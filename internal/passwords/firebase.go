@@ -0,0 +1,150 @@
+package passwords
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// firebasePrefix starts every imported Firebase hash, followed by project=<name>$<salt>$<hash>.
+const firebasePrefix = "$firebase-scrypt$"
+
+// errInvalidFirebase is returned for malformed Firebase scrypt hashes.
+var errInvalidFirebase = errors.New("invalid firebase scrypt hash")
+
+// FirebaseScryptParams are the hash parameters of a Firebase project, shown in the
+// Firebase console under Authentication > Users > Password hash parameters.
+type FirebaseScryptParams struct {
+	SignerKey     string `yaml:"signer_key"`     // Base64 base64_signer_key
+	SaltSeparator string `yaml:"salt_separator"` // Base64 base64_salt_separator
+	Rounds        int    `yaml:"rounds"`         // rounds
+	MemCost       int    `yaml:"mem_cost"`       // mem_cost
+}
+
+// FirebaseScrypt verifies the modified scrypt hashes of a Firebase Auth project. New
+// passwords are never hashed with it, imported users are upgraded on their next login.
+type FirebaseScrypt struct {
+	project       string
+	signerKey     []byte
+	saltSeparator []byte
+	rounds        int
+	memCost       int
+}
+
+// NewFirebaseScrypt creates the Firebase scrypt algorithm of one project.
+//
+// Parameters:
+// - project: The name the project's hashes are imported under.
+// - params: The project's hash parameters.
+//
+// Returns:
+// - *FirebaseScrypt: The algorithm.
+// - error: An error if the keys are not valid base64 or the costs are missing.
+func NewFirebaseScrypt(project string, params FirebaseScryptParams) (*FirebaseScrypt, error) {
+	signerKey, err := base64.StdEncoding.DecodeString(params.SignerKey)
+	if err != nil || len(signerKey) == 0 {
+		return nil, fmt.Errorf("firebase project %q: invalid signer_key", project)
+	}
+	saltSeparator, err := base64.StdEncoding.DecodeString(params.SaltSeparator)
+	if err != nil {
+		return nil, fmt.Errorf("firebase project %q: invalid salt_separator", project)
+	}
+	if params.Rounds <= 0 || params.MemCost <= 0 || params.MemCost > 30 {
+		return nil, fmt.Errorf("firebase project %q: rounds and mem_cost are required", project)
+	}
+	return &FirebaseScrypt{
+		project:       project,
+		signerKey:     signerKey,
+		saltSeparator: saltSeparator,
+		rounds:        params.Rounds,
+		memCost:       params.MemCost,
+	}, nil
+}
+
+// FirebaseHash encodes the salt and hash of a Firebase export so FirebaseScrypt can verify it.
+//
+// Parameters:
+// - project: The name of the project in security.password_hashing.firebase.
+// - salt: The user's base64 salt from the export.
+// - hash: The user's base64 passwordHash from the export.
+//
+// Returns:
+// - string: The encoded hash to store.
+func FirebaseHash(project, salt, hash string) string {
+	return fmt.Sprintf("%sproject=%s$%s$%s", firebasePrefix, project, salt, hash)
+}
+
+// Hash implements Algorithm. Firebase hashes can only be verified.
+func (f *FirebaseScrypt) Hash(string) (string, error) {
+	return "", errors.New("firebase scrypt hashes can only be verified")
+}
+
+// Verify implements Algorithm.
+func (f *FirebaseScrypt) Verify(encoded, password string) (bool, error) {
+	salt, hash, err := f.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := f.key(password, salt)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, hash) == 1, nil
+}
+
+// Identifies implements Algorithm.
+func (f *FirebaseScrypt) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, firebasePrefix+"project="+f.project+"$")
+}
+
+// Outdated implements Algorithm.
+func (f *FirebaseScrypt) Outdated(string) bool {
+	return true
+}
+
+// key derives the hash Firebase stores: the signer key encrypted with AES-256-CTR under
+// an scrypt key of the password and the salt followed by the salt separator.
+func (f *FirebaseScrypt) key(password string, salt []byte) ([]byte, error) {
+	derived, err := scrypt.Key([]byte(password), append(append([]byte{}, salt...), f.saltSeparator...), 1<<f.memCost, f.rounds, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(f.signerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(out, f.signerKey)
+	return out, nil
+}
+
+// decode returns the salt and hash of an encoded Firebase hash.
+func (f *FirebaseScrypt) decode(encoded string) ([]byte, []byte, error) {
+	if !f.Identifies(encoded) {
+		return nil, nil, errInvalidFirebase
+	}
+
+	parts := strings.Split(strings.TrimPrefix(encoded, firebasePrefix+"project="+f.project+"$"), "$")
+	if len(parts) != 2 {
+		return nil, nil, errInvalidFirebase
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, errInvalidFirebase
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(hash) == 0 {
+		return nil, nil, errInvalidFirebase
+	}
+	return salt, hash, nil
+}
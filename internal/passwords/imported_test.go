@@ -0,0 +1,67 @@
+package passwords
+
+import "testing"
+
+// Parameters and user of the sample project in the Firebase scrypt documentation
+var testFirebaseProject = FirebaseScryptParams{
+	SignerKey:     "jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==",
+	SaltSeparator: "Bw==",
+	Rounds:        8,
+	MemCost:       14,
+}
+
+const (
+	testFirebaseSalt = "42xEC+ixf3L2lw=="
+	testFirebaseHash = "lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ=="
+)
+
+// Test that imported hashes verify and are always upgraded
+func TestImportedHashes(t *testing.T) {
+	hasher, err := FromConfig(Config{Firebase: map[string]FirebaseScryptParams{"isla-nublar": testFirebaseProject}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash, _ := NewBcrypt(BcryptParams{Cost: 4}).Hash("user1password")
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"django", "pbkdf2_sha256$10000$seasalt$CWWFdHOWwPnki7HvkcqN9iA2T3KLW1cf2uZ5kvArtVY="},
+		{"firebase", FirebaseHash("isla-nublar", testFirebaseSalt, testFirebaseHash)},
+		{"auth0 bcrypt", bcryptHash},
+	}
+	passwords := map[string]string{"django": "lètmein", "firebase": "user1password", "auth0 bcrypt": "user1password"}
+
+	for _, tt := range tests {
+		if !hasher.Recognizes(tt.hash) {
+			t.Errorf("%s: expected the hash to be recognized", tt.name)
+		}
+		if valid, err := hasher.Verify(tt.hash, passwords[tt.name]); err != nil || !valid {
+			t.Errorf("%s: expected the password to match, got %v, error: %v", tt.name, valid, err)
+		}
+		if valid, err := hasher.Verify(tt.hash, "wrong-password"); err != nil || valid {
+			t.Errorf("%s: expected a wrong password not to match, got %v, error: %v", tt.name, valid, err)
+		}
+		if !hasher.NeedsRehash(tt.hash) {
+			t.Errorf("%s: expected the hash to need a rehash", tt.name)
+		}
+	}
+
+	// Hashes of unconfigured projects are not recognized
+	if hasher.Recognizes(FirebaseHash("other-project", testFirebaseSalt, testFirebaseHash)) {
+		t.Error("expected a hash of an unknown project not to be recognized")
+	}
+}
+
+// Test that misconfigured Firebase projects are reported
+func TestFirebaseConfig(t *testing.T) {
+	broken := testFirebaseProject
+	broken.SignerKey = "not base64!"
+	if _, err := FromConfig(Config{Firebase: map[string]FirebaseScryptParams{"isla-nublar": broken}}); err == nil {
+		t.Error("expected an invalid signer key to be rejected")
+	}
+	if _, err := FromConfig(Config{Firebase: map[string]FirebaseScryptParams{"isla$nublar": testFirebaseProject}}); err == nil {
+		t.Error("expected an invalid project name to be rejected")
+	}
+}
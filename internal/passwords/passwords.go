@@ -0,0 +1,211 @@
+// Package passwords hashes and verifies user passwords. Hashes are self-describing
+// (PHC strings for argon2id, modular crypt strings for bcrypt), so the algorithm and
+// its parameters can change while existing hashes keep verifying and are upgraded
+// on the next successful login.
+package passwords
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownHash is returned when a stored hash is in a format no algorithm recognizes.
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Algorithm is a single password hashing scheme.
+type Algorithm interface {
+	// Hash hashes a plain-text password with the algorithm's current parameters.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches an encoded hash of this algorithm.
+	Verify(encoded, password string) (bool, error)
+	// Identifies reports whether the encoded hash was produced by this algorithm.
+	Identifies(encoded string) bool
+	// Outdated reports whether the encoded hash uses different parameters than Hash would.
+	Outdated(encoded string) bool
+}
+
+// Hasher hashes new passwords with a preferred algorithm and verifies hashes of
+// every algorithm it knows.
+type Hasher struct {
+	preferred Algorithm
+	known     []Algorithm
+}
+
+// NewHasher creates a hasher.
+//
+// Parameters:
+// - preferred: The algorithm used for new hashes.
+// - others: Further algorithms whose hashes are still accepted.
+//
+// Returns:
+// - *Hasher: The hasher.
+func NewHasher(preferred Algorithm, others ...Algorithm) *Hasher {
+	return &Hasher{preferred: preferred, known: append([]Algorithm{preferred}, others...)}
+}
+
+// Hash hashes a plain-text password with the preferred algorithm.
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+// Verify reports whether a plain-text password matches an encoded hash.
+//
+// Parameters:
+// - encoded: The stored hash.
+// - password: The plain-text password.
+//
+// Returns:
+// - bool: True if the password matches.
+// - error: ErrUnknownHash if no algorithm recognizes the hash, or a decoding error.
+func (h *Hasher) Verify(encoded, password string) (bool, error) {
+	algorithm := h.algorithmFor(encoded)
+	if algorithm == nil {
+		return false, ErrUnknownHash
+	}
+	return algorithm.Verify(encoded, password)
+}
+
+// Recognizes reports whether one of the hasher's algorithms can verify the encoded hash.
+func (h *Hasher) Recognizes(encoded string) bool {
+	return h.algorithmFor(encoded) != nil
+}
+
+// NeedsRehash reports whether an encoded hash was made with another algorithm or
+// other parameters than the preferred ones.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	return !h.preferred.Identifies(encoded) || h.preferred.Outdated(encoded)
+}
+
+// algorithmFor returns the algorithm that produced the hash, nil if none did.
+func (h *Hasher) algorithmFor(encoded string) Algorithm {
+	for _, algorithm := range h.known {
+		if algorithm.Identifies(encoded) {
+			return algorithm
+		}
+	}
+	return nil
+}
+
+// Config selects the algorithm and its parameters. It is configured under
+// security.password_hashing in settings.yml.
+type Config struct {
+	Algorithm string         `yaml:"algorithm"` // "argon2id" or "bcrypt"
+	Argon2id  Argon2idParams `yaml:"argon2id"`  // Parameters for argon2id
+	Bcrypt    BcryptParams   `yaml:"bcrypt"`    // Parameters for bcrypt
+	Pool      PoolConfig     `yaml:"pool"`      // Limits for concurrent hashing
+
+	// Imported hash formats, only verified and upgraded on the next login
+	Django   DjangoParams                    `yaml:"django"`   // Parameters for Django pbkdf2_sha256 hashes
+	Firebase map[string]FirebaseScryptParams `yaml:"firebase"` // Firebase scrypt parameters per imported project
+}
+
+// FromConfig creates a hasher that hashes with the configured algorithm and
+// accepts hashes of all supported algorithms, including the imported formats.
+//
+// Parameters:
+// - config: The hashing settings, unset parameters fall back to the defaults.
+//
+// Returns:
+// - *Hasher: The hasher.
+// - error: An error if the algorithm is unknown or a Firebase project is misconfigured.
+func FromConfig(config Config) (*Hasher, error) {
+	argon := NewArgon2id(config.Argon2id)
+	bcryptAlgorithm := NewBcrypt(config.Bcrypt)
+
+	imported := []Algorithm{NewDjangoPBKDF2(config.Django)}
+	for project, params := range config.Firebase {
+		if project == "" || strings.ContainsAny(project, "$=") {
+			return nil, fmt.Errorf("invalid firebase project name %q", project)
+		}
+		firebase, err := NewFirebaseScrypt(project, params)
+		if err != nil {
+			return nil, err
+		}
+		imported = append(imported, firebase)
+	}
+
+	switch config.Algorithm {
+	case "", "argon2id":
+		return NewHasher(argon, append([]Algorithm{bcryptAlgorithm}, imported...)...), nil
+	case "bcrypt":
+		return NewHasher(bcryptAlgorithm, append([]Algorithm{argon}, imported...)...), nil
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm %q", config.Algorithm)
+	}
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultHasher = mustFromConfig(Config{})
+	defaultPool   = NewPool(PoolConfig{})
+)
+
+// SetDefault replaces the hasher used by the package level functions.
+func SetDefault(h *Hasher) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultHasher = h
+}
+
+// Default returns the hasher used by the package level functions.
+func Default() *Hasher {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultHasher
+}
+
+// SetDefaultPool replaces the pool that bounds the package level functions.
+func SetDefaultPool(p *Pool) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultPool = p
+}
+
+// DefaultPool returns the pool that bounds the package level functions.
+func DefaultPool() *Pool {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultPool
+}
+
+// Hash hashes a plain-text password with the default hasher inside the default pool.
+// It returns ErrBusy when the pool is saturated.
+func Hash(password string) (string, error) {
+	var hash string
+	var err error
+	if poolErr := DefaultPool().Do(func() { hash, err = Default().Hash(password) }); poolErr != nil {
+		return "", poolErr
+	}
+	return hash, err
+}
+
+// Verify checks a plain-text password against an encoded hash with the default hasher
+// inside the default pool. It returns ErrBusy when the pool is saturated.
+func Verify(encoded, password string) (bool, error) {
+	var valid bool
+	var err error
+	if poolErr := DefaultPool().Do(func() { valid, err = Default().Verify(encoded, password) }); poolErr != nil {
+		return false, poolErr
+	}
+	return valid, err
+}
+
+// Recognizes reports whether the default hasher can verify the encoded hash.
+func Recognizes(encoded string) bool {
+	return Default().Recognizes(encoded)
+}
+
+// NeedsRehash reports whether the default hasher would produce a different kind of hash.
+func NeedsRehash(encoded string) bool {
+	return Default().NeedsRehash(encoded)
+}
+
+func mustFromConfig(config Config) *Hasher {
+	h, err := FromConfig(config)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
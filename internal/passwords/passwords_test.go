@@ -0,0 +1,62 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test hashing and verifying with both algorithms
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{"argon2id", "bcrypt"} {
+		hasher, err := FromConfig(Config{Algorithm: algorithm, Argon2id: Argon2idParams{Memory: 1024, Iterations: 1}, Bcrypt: BcryptParams{Cost: 4}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := hasher.Hash("clever-girl")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if valid, err := hasher.Verify(hash, "clever-girl"); err != nil || !valid {
+			t.Errorf("%s: expected the password to match, got %v, error: %v", algorithm, valid, err)
+		}
+		if valid, err := hasher.Verify(hash, "Clever-girl"); err != nil || valid {
+			t.Errorf("%s: expected a wrong password not to match, got %v, error: %v", algorithm, valid, err)
+		}
+		if hasher.NeedsRehash(hash) {
+			t.Errorf("%s: expected a fresh hash not to need a rehash", algorithm)
+		}
+	}
+}
+
+// Test the argon2id PHC string format
+func TestArgon2idFormat(t *testing.T) {
+	hash, err := NewArgon2id(Argon2idParams{Memory: 1024, Iterations: 2, Parallelism: 1}).Hash("clever-girl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=2,p=1$") {
+		t.Errorf("unexpected PHC string %q", hash)
+	}
+}
+
+// Test that hashes with another algorithm or other parameters need a rehash but still verify
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, _ := NewBcrypt(BcryptParams{Cost: 4}).Hash("clever-girl")
+	argonHash, _ := NewArgon2id(Argon2idParams{Memory: 1024, Iterations: 1}).Hash("clever-girl")
+
+	hasher, _ := FromConfig(Config{Argon2id: Argon2idParams{Memory: 2048, Iterations: 1}})
+	for _, hash := range []string{bcryptHash, argonHash} {
+		if !hasher.NeedsRehash(hash) {
+			t.Errorf("expected %q to need a rehash", hash)
+		}
+		if valid, err := hasher.Verify(hash, "clever-girl"); err != nil || !valid {
+			t.Errorf("expected %q to verify, got %v, error: %v", hash, valid, err)
+		}
+	}
+
+	if _, err := hasher.Verify("plain-text", "plain-text"); err != ErrUnknownHash {
+		t.Errorf("expected ErrUnknownHash, got %v", err)
+	}
+}
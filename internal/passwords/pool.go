@@ -0,0 +1,145 @@
+package passwords
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBusy is returned when the hashing pool is saturated and the work was not run.
+var ErrBusy = errors.New("password hashing is busy, try again later")
+
+// PoolConfig bounds how much CPU password hashing may take. It is configured under
+// security.password_hashing.pool in settings.yml.
+type PoolConfig struct {
+	Concurrency  int           `yaml:"concurrency"`   // Hashes computed at the same time, the number of CPUs by default
+	QueueDepth   int           `yaml:"queue_depth"`   // Requests waiting for a free slot, 4 per slot by default
+	QueueTimeout time.Duration `yaml:"queue_timeout"` // Longest wait for a free slot, 5s by default
+	RetryAfter   time.Duration `yaml:"retry_after"`   // Retry-After sent to rejected clients, 1s by default
+}
+
+// WithDefaults fills in the settings that are not configured.
+func (c PoolConfig) WithDefaults() PoolConfig {
+	if c.Concurrency <= 0 {
+		c.Concurrency = runtime.NumCPU()
+	}
+	if c.QueueDepth <= 0 {
+		c.QueueDepth = 4 * c.Concurrency
+	}
+	if c.QueueTimeout <= 0 {
+		c.QueueTimeout = 5 * time.Second
+	}
+	if c.RetryAfter <= 0 {
+		c.RetryAfter = time.Second
+	}
+	return c
+}
+
+// Pool runs password hashing with a bounded concurrency and a bounded queue, so a
+// flood of logins can not starve the rest of the server.
+type Pool struct {
+	config PoolConfig
+	slots  chan struct{}
+
+	queued    int64
+	completed int64
+	rejected  int64
+	waitNanos int64
+	workNanos int64
+}
+
+// PoolStats is a snapshot of the pool's metrics.
+type PoolStats struct {
+	Concurrency  int     `json:"concurrency"`    // Configured concurrency limit
+	QueueDepth   int     `json:"queue_depth"`    // Configured queue depth
+	InFlight     int     `json:"in_flight"`      // Hashes being computed right now
+	Queued       int64   `json:"queued"`         // Requests waiting for a slot right now
+	Completed    int64   `json:"completed"`      // Hashes computed since start
+	Rejected     int64   `json:"rejected"`       // Requests rejected with ErrBusy since start
+	AvgWaitMs    float64 `json:"avg_wait_ms"`    // Average time spent waiting for a slot
+	AvgHashMs    float64 `json:"avg_hash_ms"`    // Average time spent hashing
+	RetryAfterMs int64   `json:"retry_after_ms"` // Retry-After sent to rejected clients
+}
+
+// NewPool creates a hashing pool.
+//
+// Parameters:
+// - config: The pool settings, unset values fall back to the defaults.
+//
+// Returns:
+// - *Pool: The pool.
+func NewPool(config PoolConfig) *Pool {
+	config = config.WithDefaults()
+	return &Pool{config: config, slots: make(chan struct{}, config.Concurrency)}
+}
+
+// Do runs fn once a slot is free.
+//
+// Parameters:
+// - fn: The hashing work.
+//
+// Returns:
+// - error: ErrBusy if the queue is full or no slot became free in time.
+func (p *Pool) Do(fn func()) error {
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		if err := p.wait(); err != nil {
+			return err
+		}
+	}
+	defer func() { <-p.slots }()
+
+	began := time.Now()
+	atomic.AddInt64(&p.waitNanos, int64(began.Sub(start)))
+	fn()
+	atomic.AddInt64(&p.workNanos, int64(time.Since(began)))
+	atomic.AddInt64(&p.completed, 1)
+	return nil
+}
+
+// RetryAfter returns how long rejected clients should wait before retrying.
+func (p *Pool) RetryAfter() time.Duration {
+	return p.config.RetryAfter
+}
+
+// Stats returns a snapshot of the pool's metrics.
+func (p *Pool) Stats() PoolStats {
+	stats := PoolStats{
+		Concurrency:  p.config.Concurrency,
+		QueueDepth:   p.config.QueueDepth,
+		InFlight:     len(p.slots),
+		Queued:       atomic.LoadInt64(&p.queued),
+		Completed:    atomic.LoadInt64(&p.completed),
+		Rejected:     atomic.LoadInt64(&p.rejected),
+		RetryAfterMs: p.config.RetryAfter.Milliseconds(),
+	}
+	if stats.Completed > 0 {
+		stats.AvgWaitMs = float64(atomic.LoadInt64(&p.waitNanos)) / float64(stats.Completed) / float64(time.Millisecond)
+		stats.AvgHashMs = float64(atomic.LoadInt64(&p.workNanos)) / float64(stats.Completed) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// wait queues for a slot, giving up when the queue is full or the timeout passes.
+func (p *Pool) wait() error {
+	if atomic.AddInt64(&p.queued, 1) > int64(p.config.QueueDepth) {
+		atomic.AddInt64(&p.queued, -1)
+		atomic.AddInt64(&p.rejected, 1)
+		return ErrBusy
+	}
+	defer atomic.AddInt64(&p.queued, -1)
+
+	timer := time.NewTimer(p.config.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		atomic.AddInt64(&p.rejected, 1)
+		return ErrBusy
+	}
+}
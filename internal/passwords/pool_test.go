@@ -0,0 +1,61 @@
+package passwords
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that the pool queues up to its depth and rejects beyond it
+func TestPoolSaturation(t *testing.T) {
+	pool := NewPool(PoolConfig{Concurrency: 1, QueueDepth: 1, QueueTimeout: time.Second})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = pool.Do(func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	queued := make(chan error)
+	go func() { queued <- pool.Do(func() {}) }()
+	for pool.Stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := pool.Do(func() {}); err != ErrBusy {
+		t.Fatalf("expected ErrBusy with a full queue, got %v", err)
+	}
+
+	close(release)
+	if err := <-queued; err != nil {
+		t.Fatalf("expected the queued work to run, got %v", err)
+	}
+
+	stats := pool.Stats()
+	if stats.Completed != 2 || stats.Rejected != 1 || stats.InFlight != 0 || stats.Queued != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// Test that queued work gives up after the queue timeout
+func TestPoolQueueTimeout(t *testing.T) {
+	pool := NewPool(PoolConfig{Concurrency: 1, QueueDepth: 1, QueueTimeout: 10 * time.Millisecond})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = pool.Do(func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+	defer close(release)
+
+	if err := pool.Do(func() {}); err != ErrBusy {
+		t.Fatalf("expected ErrBusy after the queue timeout, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+// Package ratelimit limits how often clients may call the API with token buckets.
+// Every bucket holds up to Burst tokens and is refilled with RequestsPerMinute
+// tokens a minute, every request takes one token.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ways to tell clients apart.
+const (
+	KeyByIP    = "ip"    // Every client IP address has its own bucket
+	KeyByUser  = "user"  // Every logged in user has its own bucket, anonymous requests fall back to the IP address
+	KeyByRoute = "route" // All clients share one bucket per route group
+)
+
+// Rule is the limit of a route group.
+type Rule struct {
+	RequestsPerMinute int    `yaml:"requests_per_minute"` // Tokens added a minute, 0 disables the limit
+	Burst             int    `yaml:"burst"`               // Size of the bucket, RequestsPerMinute by default
+	KeyBy             string `yaml:"key_by"`              // ip, user or route, ip by default
+}
+
+// Config is the rate limiting configured under api.rate_limiting in settings.yml.
+type Config struct {
+	RequestsPerMinute int             `yaml:"requests_per_minute"` // Tokens added a minute for every route, 0 disables the default limit
+	Burst             int             `yaml:"burst"`               // Size of the default bucket
+	KeyBy             string          `yaml:"key_by"`              // ip, user or route for the default limit
+	TrustedProxies    []string        `yaml:"trusted_proxies"`     // IP addresses or CIDR ranges whose X-Forwarded-For is believed
+	Routes            map[string]Rule `yaml:"routes"`              // Stricter limits of route groups, e.g. login, register and password_email
+}
+
+// Default returns the limit applied to every route.
+func (c Config) Default() Rule {
+	return Rule{RequestsPerMinute: c.RequestsPerMinute, Burst: c.Burst, KeyBy: c.KeyBy}
+}
+
+// Enabled reports whether the rule limits anything.
+func (r Rule) Enabled() bool {
+	return r.RequestsPerMinute > 0
+}
+
+// Capacity returns the size of the bucket.
+func (r Rule) Capacity() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return r.RequestsPerMinute
+}
+
+// Refill returns the tokens added a second.
+func (r Rule) Refill() float64 {
+	return float64(r.RequestsPerMinute) / 60
+}
+
+// Result describes a bucket that was left with the given tokens after a request.
+//
+// Parameters:
+// - allowed: Whether the request took a token.
+// - tokens: The tokens left in the bucket.
+//
+// Returns:
+// - Result: The limit, remaining tokens and waiting times.
+func (r Rule) Result(allowed bool, tokens float64) Result {
+	result := Result{Allowed: allowed, Limit: r.Capacity(), Remaining: int(tokens)}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / r.Refill())
+	}
+	result.Reset = seconds((float64(r.Capacity()) - tokens) / r.Refill())
+	return result
+}
+
+// Result is the state of a bucket after a request took a token or was rejected.
+type Result struct {
+	Allowed    bool          // The request may pass
+	Limit      int           // Size of the bucket
+	Remaining  int           // Tokens left
+	Reset      time.Duration // Time until the bucket is full again
+	RetryAfter time.Duration // Time until the next token, 0 if the request was allowed
+}
+
+// Store keeps the buckets.
+type Store interface {
+	// Take takes a token from the bucket of the key.
+	Take(ctx context.Context, key string, rule Rule, now time.Time) (Result, error)
+}
+
+// bucket is a token bucket at the time of its last update.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket up to now and takes a token if there is one.
+func (b *bucket) take(rule Rule, now time.Time) Result {
+	capacity := float64(rule.Capacity())
+	if b.updated.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rule.Refill())
+	}
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return rule.Result(allowed, b.tokens)
+}
+
+// MemoryStore keeps the buckets in memory, so every server instance limits on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+// Take implements Store.
+func (s *MemoryStore) Take(_ context.Context, key string, rule Rule, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget idle buckets once a minute, they would be full again anyway
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, b := range s.buckets {
+			if now.Sub(b.updated) > time.Hour {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{}
+		s.buckets[key] = b
+	}
+	return b.take(rule, now), nil
+}
+
+// ClientIP returns the IP address of the client. X-Forwarded-For is only believed
+// when the request comes from a trusted proxy, and then read from the right, skipping
+// the trusted proxies, so clients can not pass a made up address.
+//
+// Parameters:
+// - r: The request.
+// - trustedProxies: IP addresses or CIDR ranges of the proxies in front of the server.
+//
+// Returns:
+// - string: The IP address without port.
+func ClientIP(r *http.Request, trustedProxies []string) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	trusted := parseProxies(trustedProxies)
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			break
+		}
+		ip = hops[i]
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// parseProxies reads IP addresses and CIDR ranges, skipping invalid entries.
+func parseProxies(proxies []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+// isTrusted reports whether the IP address is one of the trusted proxies.
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range trusted {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// seconds converts fractional seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test that a bucket allows its burst, then refills at the configured rate
+func TestMemoryStoreTake(t *testing.T) {
+	store := NewMemoryStore()
+	rule := Rule{RequestsPerMinute: 60, Burst: 3}
+	now := time.Now()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		result, _ := store.Take(ctx, "alan", rule, now)
+		if !result.Allowed || result.Limit != 3 || result.Remaining != 2-i {
+			t.Fatalf("request %d: unexpected result %+v", i+1, result)
+		}
+	}
+
+	result, _ := store.Take(ctx, "alan", rule, now)
+	if result.Allowed || result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+		t.Fatalf("expected the empty bucket to reject, got %+v", result)
+	}
+
+	if result, _ := store.Take(ctx, "ellie", rule, now); !result.Allowed {
+		t.Errorf("expected every key to have its own bucket, got %+v", result)
+	}
+
+	if result, _ := store.Take(ctx, "alan", rule, now.Add(time.Second)); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected one token after a second, got %+v", result)
+	}
+	if result, _ := store.Take(ctx, "alan", rule, now.Add(time.Hour)); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("expected the bucket to be full again, got %+v", result)
+	}
+}
+
+// Test that the burst defaults to the requests per minute
+func TestRuleCapacity(t *testing.T) {
+	if got := (Rule{RequestsPerMinute: 10}).Capacity(); got != 10 {
+		t.Errorf("expected a capacity of 10, got %d", got)
+	}
+	if (Rule{Burst: 5}).Enabled() {
+		t.Error("expected a rule without requests per minute to be disabled")
+	}
+}
+
+// Test that X-Forwarded-For is only believed from trusted proxies
+func TestClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "192.168.1.1"}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct", "203.0.113.7:4000", "", "203.0.113.7"},
+		{"spoofed header from an untrusted client", "203.0.113.7:4000", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:4000", "198.51.100.1", "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:4000", "198.51.100.1, 192.168.1.1, 10.0.0.5", "198.51.100.1"},
+		{"made up address before the real one", "10.1.2.3:4000", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"garbage in the header", "10.1.2.3:4000", "not-an-ip", "10.1.2.3"},
+		{"only trusted hops", "10.1.2.3:4000", "10.0.0.9", "10.0.0.9"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			if test.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+			if got := ClientIP(r, trusted); got != test.want {
+				t.Errorf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
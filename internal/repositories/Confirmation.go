@@ -32,26 +32,26 @@ func (r *GORMRepo) GetConfirmationsByUserID(userID string) ([]*models.UserConfir
 	return userConfirmations, nil
 }
 
-func (r *GORMRepo) ConfirmUser(confirmationID string, confirmation *models.UserConfirmation) error {
-	var existingConfirmation models.UserConfirmation
-	if err := r.DB.Where("ID = ?", confirmationID).First(&existingConfirmation).Error; err != nil {
-		return err // Handle record not found or other errors
-	}
-
-	// Start a transaction
-	tx := r.DB.Begin()
-
-	if err := tx.Model(&existingConfirmation).Updates(confirmation).Error; err != nil {
-		tx.Rollback() // Rollback the transaction in case of errors
-		return err
-	}
-
-	// Commit the transaction if everything went well
-	if err := tx.Commit().Error; err != nil {
-		return err
+// GetConfirmationByTokenHash returns the confirmation whose link carries the token
+// with the given hash.
+func (r *GORMRepo) GetConfirmationByTokenHash(tokenHash string) (*models.UserConfirmation, error) {
+	var confirmation models.UserConfirmation
+	if err := r.DB.Where("token_hash = ?", tokenHash).First(&confirmation).Error; err != nil {
+		return nil, err
 	}
+	return &confirmation, nil
+}
 
-	return nil
+// MarkConfirmed confirms a confirmation that is not confirmed yet. Concurrent calls
+// with the same confirmation only succeed once.
+//
+// Returns:
+// - bool: False if the confirmation was confirmed already.
+func (r *GORMRepo) MarkConfirmed(confirmationID string) (bool, error) {
+	result := r.DB.Model(&models.UserConfirmation{}).
+		Where("id = ? AND confirmed = ?", confirmationID, false).
+		Update("confirmed", true)
+	return result.RowsAffected > 0, result.Error
 }
 
 func (r *GORMRepo) GetConfirmationByID(confirmationID string) (*models.UserConfirmation, error) {
@@ -62,3 +62,11 @@ func (r *GORMRepo) GetConfirmationByID(confirmationID string) (*models.UserConfi
 	}
 	return userConfirmation, nil
 }
+
+// IsUserConfirmed reports whether the user confirmed the email address with any of
+// the confirmations sent.
+func (r *GORMRepo) IsUserConfirmed(userID string) (bool, error) {
+	var count int64
+	err := r.DB.Model(&models.UserConfirmation{}).Where("user_id = ? AND confirmed = ?", userID, true).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,91 @@
+package repositories
+
+import (
+	"TriceraPass/internal/models"
+	"time"
+)
+
+func (r *GORMRepo) InsertDataExport(export *models.DataExport) (string, error) {
+	if err := r.DB.Create(export).Error; err != nil {
+		return "", err
+	}
+	return export.ID, nil
+}
+
+func (r *GORMRepo) GetDataExportByID(id string) (*models.DataExport, error) {
+	var export models.DataExport
+	if err := r.DB.Where("id = ?", id).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// GetDataExportByTokenHash returns the export whose download link carries the token with the given hash.
+func (r *GORMRepo) GetDataExportByTokenHash(tokenHash string) (*models.DataExport, error) {
+	var export models.DataExport
+	if err := r.DB.Where("token_hash = ? AND status = ?", tokenHash, models.DataExportReady).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// GetDataExportsByUserID returns the exports of a user, newest first.
+func (r *GORMRepo) GetDataExportsByUserID(userID string) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	err := r.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&exports).Error
+	return exports, err
+}
+
+// HasPendingDataExport reports whether an export of the user is still being built.
+func (r *GORMRepo) HasPendingDataExport(userID string) (bool, error) {
+	var count int64
+	err := r.DB.Model(&models.DataExport{}).Where("user_id = ? AND status = ?", userID, models.DataExportPending).Count(&count).Error
+	return count > 0, err
+}
+
+// CompleteDataExport stores the archive and the download token of a built export.
+func (r *GORMRepo) CompleteDataExport(id, filePath, tokenHash string, expiredAt time.Time) error {
+	return r.DB.Model(&models.DataExport{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":       models.DataExportReady,
+		"file_path":    filePath,
+		"token_hash":   tokenHash,
+		"expired_at":   expiredAt.Unix(),
+		"completed_at": time.Now(),
+	}).Error
+}
+
+// FailDataExport marks an export whose archive could not be built, and forgets its
+// archive and download token.
+func (r *GORMRepo) FailDataExport(id string) error {
+	return r.DB.Model(&models.DataExport{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":       models.DataExportFailed,
+		"file_path":    "",
+		"token_hash":   "",
+		"completed_at": time.Now(),
+	}).Error
+}
+
+// GetExpiredDataExports returns the ready exports whose download link expired before the given time.
+func (r *GORMRepo) GetExpiredDataExports(before time.Time) ([]models.DataExport, error) {
+	var exports []models.DataExport
+	err := r.DB.Where("status = ? AND expired_at < ?", models.DataExportReady, before.Unix()).Find(&exports).Error
+	return exports, err
+}
+
+func (r *GORMRepo) DeleteDataExport(id string) error {
+	return r.DB.Where("id = ?", id).Delete(&models.DataExport{}).Error
+}
+
+// GetPasswordTokensByUserID returns the password reset tokens of a user.
+func (r *GORMRepo) GetPasswordTokensByUserID(userID string) ([]models.PasswordRestToken, error) {
+	var tokens []models.PasswordRestToken
+	err := r.DB.Where("user_id = ?", userID).Order("created_at").Find(&tokens).Error
+	return tokens, err
+}
+
+// GetEmailChangesByUserID returns the email changes of a user.
+func (r *GORMRepo) GetEmailChangesByUserID(userID string) ([]models.EmailChange, error) {
+	var changes []models.EmailChange
+	err := r.DB.Where("user_id = ?", userID).Order("created_at").Find(&changes).Error
+	return changes, err
+}
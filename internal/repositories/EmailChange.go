@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"TriceraPass/internal/models"
+	"errors"
+	"time"
+)
+
+// ErrInvalidEmailChangeToken is returned when an email change token is unknown, used or expired.
+var ErrInvalidEmailChangeToken = errors.New("invalid or expired email change link")
+
+// InsertEmailChange stores a requested email change.
+func (r *GORMRepo) InsertEmailChange(change *models.EmailChange) (string, error) {
+	if err := r.DB.Create(change).Error; err != nil {
+		return "", err
+	}
+	return change.ID, nil
+}
+
+// CancelPendingEmailChanges expires the changes of a user that were not confirmed yet,
+// so their confirmation links are rejected. Their revert links stay valid.
+func (r *GORMRepo) CancelPendingEmailChanges(userID string) error {
+	return r.DB.Model(&models.EmailChange{}).
+		Where("user_id = ? AND confirmed_at IS NULL AND reverted_at IS NULL", userID).
+		Update("expired_at", 0).Error
+}
+
+// GetEmailChangeByTokenHash returns the change whose confirmation link carries the token with the given hash.
+func (r *GORMRepo) GetEmailChangeByTokenHash(tokenHash string) (*models.EmailChange, error) {
+	var change models.EmailChange
+	if err := r.DB.Where("token_hash = ?", tokenHash).First(&change).Error; err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// GetEmailChangeByRevertTokenHash returns the change whose revert link carries the token with the given hash.
+func (r *GORMRepo) GetEmailChangeByRevertTokenHash(tokenHash string) (*models.EmailChange, error) {
+	var change models.EmailChange
+	if err := r.DB.Where("revert_token_hash = ?", tokenHash).First(&change).Error; err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// ConfirmEmailChange confirms a pending, unexpired change and swaps the email address
+// of the user. The check and the update are a single statement, so a change can only
+// be confirmed once.
+//
+// Returns:
+// - *models.EmailChange: The confirmed change.
+// - error: ErrInvalidEmailChangeToken if the change can not be confirmed, ErrUserExists
+// if another user took the new address in the meantime.
+func (r *GORMRepo) ConfirmEmailChange(tokenHash string) (*models.EmailChange, error) {
+	now := time.Now()
+	tx := r.DB.Begin()
+	result := tx.Model(&models.EmailChange{}).
+		Where("token_hash = ? AND confirmed_at IS NULL AND reverted_at IS NULL AND expired_at >= ?", tokenHash, now.Unix()).
+		Update("confirmed_at", now)
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		tx.Rollback()
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	var change models.EmailChange
+	if err := tx.Where("token_hash = ?", tokenHash).First(&change).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var taken int64
+	if err := tx.Model(&models.User{}).Where("email = ? AND id <> ?", change.NewEmail, change.UserID).Count(&taken).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if taken > 0 {
+		tx.Rollback()
+		return nil, ErrUserExists
+	}
+
+	if err := tx.Model(&models.User{}).Where("id = ?", change.UserID).Update("email", change.NewEmail).Error; err != nil {
+		tx.Rollback()
+		return nil, uniqueViolation(err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// RevertEmailChange restores the email address a change replaced and logs the user out
+// everywhere. Every other change of the user is reverted with it, so the revert links of
+// later changes can not undo the recovery.
+//
+// Returns:
+// - *models.EmailChange: The reverted change.
+// - error: ErrInvalidEmailChangeToken if the change can not be reverted.
+func (r *GORMRepo) RevertEmailChange(revertTokenHash string) (*models.EmailChange, error) {
+	now := time.Now()
+	tx := r.DB.Begin()
+	result := tx.Model(&models.EmailChange{}).
+		Where("revert_token_hash = ? AND reverted_at IS NULL AND revert_expired_at >= ?", revertTokenHash, now.Unix()).
+		Update("reverted_at", now)
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		tx.Rollback()
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	var change models.EmailChange
+	if err := tx.Where("revert_token_hash = ?", revertTokenHash).First(&change).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Model(&models.EmailChange{}).Where("user_id = ? AND reverted_at IS NULL", change.UserID).
+		Update("reverted_at", now).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Model(&models.User{}).Where("id = ?", change.UserID).
+		Updates(map[string]interface{}{"email": change.OldEmail, "sessions_revoked_at": now}).Error; err != nil {
+		tx.Rollback()
+		return nil, uniqueViolation(err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
@@ -24,6 +24,14 @@ func (r *GORMRepo) GetProfileImageByUserID(userID string) (*models.ProfileImage,
 	return &image, nil
 }
 
+// GetProfileImagesByUserID returns every profile image record of a user, including
+// records of replaced images.
+func (r *GORMRepo) GetProfileImagesByUserID(userID string) ([]models.ProfileImage, error) {
+	var images []models.ProfileImage
+	err := r.DB.Unscoped().Where("user_id = ?", userID).Find(&images).Error
+	return images, err
+}
+
 func (r *GORMRepo) GetProfileImageByFilename(filename string) (*models.ProfileImage, error) {
 	var image *models.ProfileImage
 
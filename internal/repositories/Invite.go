@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"TriceraPass/internal/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidInvite is returned when an invite is unknown, revoked, used up or expired.
+var ErrInvalidInvite = errors.New("invalid or expired invite")
+
+func (r *GORMRepo) InsertInvite(invite *models.Invite) (string, error) {
+	if err := r.DB.Create(invite).Error; err != nil {
+		return "", err
+	}
+	return invite.ID, nil
+}
+
+// GetAllInvites returns every invite, newest first.
+func (r *GORMRepo) GetAllInvites() ([]models.Invite, error) {
+	var invites []models.Invite
+	err := r.DB.Order("created_at DESC").Find(&invites).Error
+	return invites, err
+}
+
+// GetInviteByCodeHash returns the invite whose code has the given hash.
+func (r *GORMRepo) GetInviteByCodeHash(codeHash string) (*models.Invite, error) {
+	var invite models.Invite
+	if err := r.DB.Where("code_hash = ?", codeHash).First(&invite).Error; err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// UseInvite counts a registration with the invite. The check and the update are a
+// single statement, so an invite can not be used more often than allowed.
+//
+// Returns:
+// - error: ErrInvalidInvite if the invite is unknown, revoked, used up or expired.
+func (r *GORMRepo) UseInvite(id string) error {
+	result := r.DB.Model(&models.Invite{}).
+		Where("id = ? AND revoked_at IS NULL AND (max_uses = 0 OR uses < max_uses) AND (expired_at = 0 OR expired_at >= ?)", id, time.Now().Unix()).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrInvalidInvite
+	}
+	return nil
+}
+
+// ReleaseInvite gives back a use of the invite, when the registration failed after all.
+func (r *GORMRepo) ReleaseInvite(id string) error {
+	return r.DB.Model(&models.Invite{}).Where("id = ? AND uses > 0", id).
+		Update("uses", gorm.Expr("uses - 1")).Error
+}
+
+// RevokeInvite stops an invite from being used.
+//
+// Returns:
+// - error: ErrInvalidInvite if the invite is unknown or was revoked before.
+func (r *GORMRepo) RevokeInvite(id string) error {
+	result := r.DB.Model(&models.Invite{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return ErrInvalidInvite
+	}
+	return nil
+}
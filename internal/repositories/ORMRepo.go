@@ -18,6 +18,10 @@ func (repo *GORMRepo) Migrate() error {
 		&models.User{},
 		&models.UserConfirmation{},
 		&models.PasswordRestToken{},
+		&models.PasswordHistory{},
+		&models.EmailChange{},
+		&models.DataExport{},
+		&models.Invite{},
 		&models.Mode{},
 		&models.ProfileImage{},
 	)
@@ -25,6 +29,10 @@ func (repo *GORMRepo) Migrate() error {
 		return err
 	}
 
+	if err := repo.migrateUserUniqueness(); err != nil {
+		return err
+	}
+
 	_ = repo.DB.Logger.LogMode(logger.Info)
 
 	return nil
@@ -3,77 +3,172 @@ package repositories
 import (
 	"TriceraPass/cmd/api/controllers"
 	"TriceraPass/internal/models"
+	"errors"
+	"time"
 
+	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// ErrInvalidToken is returned when a password reset token is unknown, used or expired.
+var ErrInvalidToken = errors.New("invalid or expired password reset token")
+
 func (r *GORMRepo) InsertPasswordToken(passwordToken *models.PasswordRestToken) (string, error) {
 	tx := r.DB.Begin()
-	tx.SavePoint("beforeTokenInsert")
-	err := r.DB.Create(&passwordToken).Error
-	if err != nil {
-		tx.RollbackTo("beforeTokenInsert")
+	if err := tx.Create(&passwordToken).Error; err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if err := tx.Commit().Error; err != nil {
 		return "", err
 	}
-	tx.Commit()
 	return passwordToken.ID, nil
 }
 
-func (r *GORMRepo) GetLastPasswordTokenByUserID(userID string) (*models.PasswordRestToken, error) {
+// GetPasswordTokenByHash returns the reset token stored under the hash of the emailed token.
+func (r *GORMRepo) GetPasswordTokenByHash(tokenHash string) (*models.PasswordRestToken, error) {
 	var passwordToken *models.PasswordRestToken
-	err := r.DB.Where("user_id = ?", userID).Order("created_at DESC").First(&passwordToken).Error
-	if err != nil {
+	if err := r.DB.Where("token_hash = ?", tokenHash).First(&passwordToken).Error; err != nil {
 		return nil, err
 	}
 	return passwordToken, nil
 }
 
-func (r *GORMRepo) GetPasswordTokenByID(tokenID string) (*models.PasswordRestToken, error) {
+// ResetPasswordByToken consumes an unused, unexpired reset token and stores the
+// already hashed password in a single transaction, so the token is only used up when
+// the password is changed. The check and the update of the token are a single
+// statement, so a token can only be consumed once. The other outstanding tokens of
+// the user are used up as well and every session of the user is revoked.
+func (r *GORMRepo) ResetPasswordByToken(tokenHash, hashedPassword string, keepHistory int) (*models.PasswordRestToken, error) {
+	tx := r.DB.Begin()
+	result := tx.Model(&models.PasswordRestToken{}).
+		Where("token_hash = ? AND token_used = ? AND expired_at > ?", tokenHash, false, time.Now().Unix()).
+		Update("token_used", true)
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		tx.Rollback()
+		return nil, ErrInvalidToken
+	}
+
 	var passwordToken *models.PasswordRestToken
-	if err := r.DB.First(&passwordToken, tokenID).Error; err != nil {
+	if err := tx.Where("token_hash = ?", tokenHash).First(&passwordToken).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Model(&models.PasswordRestToken{}).Where("user_id = ? AND token_used = ?", passwordToken.UserID, false).
+		Update("token_used", true).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := setPassword(tx, passwordToken.UserID, hashedPassword, keepHistory, true); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
 		return nil, err
 	}
 	return passwordToken, nil
 }
 
-func (r *GORMRepo) SetTokenToUsed(tokenID string, passwordToken *models.PasswordRestToken) error {
-	var existingToken models.PasswordRestToken
-	if err := r.DB.Where("ID = ?", tokenID).First(&existingToken).Error; err != nil {
+// ChangePasswordByUserID hashes and stores a new password. The replaced hash is moved
+// to the password history, which is trimmed to the newest keepHistory entries. With
+// revokeSessions every token issued before now is invalidated in the same update.
+func (r *GORMRepo) ChangePasswordByUserID(userID, newPassword string, keepHistory int, revokeSessions bool) error {
+	hashedPassword, err := controllers.HashAPassword(newPassword)
+	if err != nil {
 		return err
 	}
 
 	tx := r.DB.Begin()
-	if err := tx.Model(&existingToken).Updates(passwordToken).Error; err != nil {
+	if err := setPassword(tx, userID, hashedPassword, keepHistory, revokeSessions); err != nil {
 		tx.Rollback()
 		return err
 	}
-
 	if err := tx.Commit().Error; err != nil {
 		return err
 	}
-
+	r.DB.Logger.LogMode(logger.LogLevel(1))
 	return nil
 }
 
-func (r *GORMRepo) ChangePasswordByUserID(userID, newPassword string) error {
-	var user *models.User
-	if err := r.DB.Where("ID = ?", userID).First(&user).Error; err != nil {
+// setPassword stores an already hashed password within tx. Only the password columns
+// are written, so concurrent updates to the rest of the user are kept.
+func setPassword(tx *gorm.DB, userID, hashedPassword string, keepHistory int, revokeSessions bool) error {
+	var user models.User
+	if err := tx.Select("password").Where("id = ?", userID).First(&user).Error; err != nil {
 		return err
 	}
 
-	// Update the password field
-	hashedPassword, err := controllers.HashAPassword(newPassword)
-	if err != nil {
+	now := time.Now()
+	if keepHistory > 0 {
+		entry := models.PasswordHistory{UserID: userID, Password: user.Password, CreatedAt: now}
+		if err := tx.Create(&entry).Error; err != nil {
+			return err
+		}
+	}
+	if err := trimPasswordHistory(tx, userID, keepHistory); err != nil {
 		return err
 	}
-	user.Password = hashedPassword
 
-	// Save the updated user back to the database
-	if err := r.DB.Save(&user).Error; err != nil {
+	updates := map[string]interface{}{
+		"password":                   hashedPassword,
+		"password_changed_at":        now,
+		"password_rotation_required": false,
+		"password_expiry_warned_at":  nil,
+	}
+	if revokeSessions {
+		updates["sessions_revoked_at"] = now
+	}
+	return tx.Model(&models.User{}).Where("id = ?", userID).Updates(updates).Error
+}
+
+// GetPasswordHistory returns the newest previous password hashes of a user.
+func (r *GORMRepo) GetPasswordHistory(userID string, limit int) ([]models.PasswordHistory, error) {
+	var history []models.PasswordHistory
+	if limit <= 0 {
+		return history, nil
+	}
+	err := r.DB.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Limit(limit).Find(&history).Error
+	return history, err
+}
+
+// trimPasswordHistory deletes all but the newest keep history entries of a user.
+func trimPasswordHistory(tx *gorm.DB, userID string, keep int) error {
+	if keep < 0 {
+		keep = 0
+	}
+
+	var stale []uint
+	err := tx.Model(&models.PasswordHistory{}).Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").Offset(keep).Limit(-1).Pluck("id", &stale).Error
+	if err != nil || len(stale) == 0 {
 		return err
 	}
-	r.DB.Logger.LogMode(logger.LogLevel(1))
-	return nil
+	return tx.Where("id IN ?", stale).Delete(&models.PasswordHistory{}).Error
+}
+
+// UpdatePasswordHash replaces the stored hash of an unchanged password, e.g. after a rehash on login.
+func (r *GORMRepo) UpdatePasswordHash(userID, hash string) error {
+	return r.DB.Model(&models.User{}).Where("ID = ?", userID).Update("password", hash).Error
+}
+
+func (r *GORMRepo) RequirePasswordRotation(userID string) error {
+	return r.DB.Model(&models.User{}).Where("ID = ?", userID).Update("password_rotation_required", true).Error
+}
+
+// MarkPasswordExpiryWarned records that the user was warned about the expiring password.
+func (r *GORMRepo) MarkPasswordExpiryWarned(userID string, at time.Time) error {
+	return r.DB.Model(&models.User{}).Where("ID = ?", userID).Update("password_expiry_warned_at", at).Error
+}
+
+// RevokeSessions invalidates every token issued to the user before now.
+func (r *GORMRepo) RevokeSessions(userID string) error {
+	return r.DB.Model(&models.User{}).Where("ID = ?", userID).Update("sessions_revoked_at", time.Now()).Error
 }
 
 func (r *GORMRepo) GetUserPasswordByID(userID string) (string, error) {
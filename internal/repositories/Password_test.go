@@ -0,0 +1,92 @@
+package repositories_test
+
+import (
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/repositories"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Test that a password change only writes the password columns of the user
+func TestChangePasswordByUserID(t *testing.T) {
+	repo := newTestRepo(t)
+
+	user := newUser("muldoon@jurassic.park", "muldoon")
+	user.Password = "old-hash"
+	user.PasswordRotationRequired = true
+	if _, err := repo.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	// A concurrent update the password change must not overwrite
+	if err := repo.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("first_name", "Robert").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := repo.ChangePasswordByUserID(user.ID, "clever-girl", 1, true); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := repo.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.FirstName != "Robert" {
+		t.Errorf("expected the concurrent update to be kept, got %q", changed.FirstName)
+	}
+	if valid, err := changed.PasswordMatches("clever-girl"); err != nil || !valid {
+		t.Errorf("expected the new password to match, got %v, error: %v", valid, err)
+	}
+	if changed.PasswordRotationRequired || changed.PasswordChangedAt.Before(before) {
+		t.Errorf("expected the rotation to be cleared and the change recorded, got %+v", changed)
+	}
+	if changed.SessionsRevokedAt == nil || changed.SessionsRevokedAt.Before(before) {
+		t.Errorf("expected the sessions to be revoked, got %v", changed.SessionsRevokedAt)
+	}
+
+	history, err := repo.GetPasswordHistory(user.ID, 5)
+	if err != nil || len(history) != 1 || history[0].Password != "old-hash" {
+		t.Errorf("expected the old hash in the history, got %+v, error: %v", history, err)
+	}
+}
+
+// Test that a reset token is only used up together with the password change
+func TestResetPasswordByToken(t *testing.T) {
+	repo := newTestRepo(t)
+
+	user := newUser("nedry@jurassic.park", "nedry")
+	if _, err := repo.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	expiredAt := time.Now().Add(time.Hour).Unix()
+	for _, token := range []models.PasswordRestToken{
+		{ID: uuid.NewString(), UserID: user.ID, TokenHash: "valid", ExpiredAt: expiredAt},
+		{ID: uuid.NewString(), UserID: uuid.NewString(), TokenHash: "orphan", ExpiredAt: expiredAt},
+	} {
+		if _, err := repo.InsertPasswordToken(&token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The password of an unknown user can not be set, the token stays usable
+	if _, err := repo.ResetPasswordByToken("orphan", "new-hash", 0); err == nil {
+		t.Error("expected the reset of an unknown user to fail")
+	}
+	if orphan, err := repo.GetPasswordTokenByHash("orphan"); err != nil || orphan.TokenUsed {
+		t.Errorf("expected the failed reset to keep the token, got %+v, error: %v", orphan, err)
+	}
+
+	if _, err := repo.ResetPasswordByToken("valid", "new-hash", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ResetPasswordByToken("valid", "newer-hash", 0); !errors.Is(err, repositories.ErrInvalidToken) {
+		t.Errorf("expected a used token to be rejected, got %v", err)
+	}
+	reset, err := repo.GetUserByID(user.ID)
+	if err != nil || reset.Password != "new-hash" || reset.SessionsRevokedAt == nil {
+		t.Errorf("expected the new hash and revoked sessions, got %+v, error: %v", reset, err)
+	}
+}
@@ -4,10 +4,121 @@ import (
 	"TriceraPass/internal/models"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ErrUserExists is returned when a user with the same email address already exists.
+var ErrUserExists = errors.New("user already exists")
+
+// ErrUserNameTaken is returned when another user has the same username, regardless of the case.
+var ErrUserNameTaken = errors.New("username is already taken")
+
+// Unique indexes of the users table. Emails are stored normalized, usernames keep their
+// case, so their index is on the lowercased name.
+const (
+	userEmailIndex    = "idx_users_email"
+	userUserNameIndex = "idx_users_user_name_lower"
+)
+
+// ErrUserNotDeleted is returned when restoring a user that is not marked as deleted.
+var ErrUserNotDeleted = errors.New("user is not deleted")
+
+// ErrUserNotSuspended is returned when reactivating a user that is neither suspended nor locked.
+var ErrUserNotSuspended = errors.New("user is not suspended")
+
+// ErrUserNotPending is returned when approving or rejecting a user that is not waiting for approval.
+var ErrUserNotPending = errors.New("user is not waiting for approval")
+
+// PendingUserFilter narrows down the users waiting for approval.
+type PendingUserFilter struct {
+	Confirmed        *bool     // Only users who did, or did not, confirm their email address, all if nil
+	Email            string    // Only users whose email address contains this text, case-insensitive
+	RegisteredAfter  time.Time // Only users registered after this time, if set
+	RegisteredBefore time.Time // Only users registered before this time, if set
+}
+
+// uniqueViolation translates a violated unique index of the users table into ErrUserExists
+// or ErrUserNameTaken, other errors are returned as they are.
+func uniqueViolation(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	// SQLite names the column of plain indexes and the index of expression indexes,
+	// PostgreSQL always names the index
+	switch {
+	case strings.Contains(msg, userEmailIndex), strings.Contains(msg, "users.email"):
+		return ErrUserExists
+	case strings.Contains(msg, userUserNameIndex):
+		return ErrUserNameTaken
+	}
+	return err
+}
+
+// migrateUserUniqueness normalizes the stored email addresses and usernames and creates
+// the unique indexes on them. It runs once, before the indexes exist, and fails without
+// changing anything if existing accounts collide, which have to be merged or renamed first.
+func (r *GORMRepo) migrateUserUniqueness() error {
+	migrator := r.DB.Migrator()
+	if migrator.HasIndex(&models.User{}, userEmailIndex) && migrator.HasIndex(&models.User{}, userUserNameIndex) {
+		return nil
+	}
+
+	var users []models.User
+	if err := r.DB.Select("id", "email", "user_name").Find(&users).Error; err != nil {
+		return err
+	}
+
+	emails := map[string]string{}
+	names := map[string]string{}
+	var collisions []string
+	for _, user := range users {
+		email := models.NormalizeEmail(user.Email)
+		if other, ok := emails[email]; ok && email != "" {
+			collisions = append(collisions, fmt.Sprintf("email %s (users %s and %s)", email, other, user.ID))
+		}
+		emails[email] = user.ID
+
+		name := strings.ToLower(models.NormalizeUserName(user.UserName))
+		if other, ok := names[name]; ok && name != "" {
+			collisions = append(collisions, fmt.Sprintf("username %s (users %s and %s)", name, other, user.ID))
+		}
+		names[name] = user.ID
+	}
+	if len(collisions) > 0 {
+		return fmt.Errorf("can not make emails and usernames unique, resolve these duplicates first: %s", strings.Join(collisions, "; "))
+	}
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		for _, user := range users {
+			email, name := models.NormalizeEmail(user.Email), models.NormalizeUserName(user.UserName)
+			if email == user.Email && name == user.UserName {
+				continue
+			}
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).
+				Updates(map[string]interface{}{"email": email, "user_name": name}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + userEmailIndex + " ON users (email) WHERE email <> ''").Error; err != nil {
+			return err
+		}
+		return tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + userUserNameIndex + " ON users (LOWER(user_name)) WHERE user_name <> ''").Error
+	})
+}
+
+// UpdateUser writes the non-empty profile fields of the user: the username, the first and
+// last name, and the creation date. The username is normalized first. The email address,
+// the password, the status and the revoked sessions are changed through their own flows
+// and are never written here.
+//
+// Returns:
+// - *models.User: The updated user.
+// - error: ErrUserExists or ErrUserNameTaken if another user has the email address or the username.
 func (r *GORMRepo) UpdateUser(id string, user *models.User) (*models.User, error) {
 	var existingUser *models.User
 	err := r.DB.Where("id = ?", id).First(&existingUser).Error
@@ -18,12 +129,31 @@ func (r *GORMRepo) UpdateUser(id string, user *models.User) (*models.User, error
 		return nil, err
 	}
 
+	user.UserName = models.NormalizeUserName(user.UserName)
+	profile := map[string]interface{}{}
+	if user.UserName != "" {
+		profile["user_name"] = user.UserName
+	}
+	if user.FirstName != "" {
+		profile["first_name"] = user.FirstName
+	}
+	if user.LastName != "" {
+		profile["last_name"] = user.LastName
+	}
+	if !user.CreatedAt.IsZero() {
+		profile["created_at"] = user.CreatedAt
+	}
+	if len(profile) == 0 {
+		return user, nil
+	}
+
 	tx := r.DB.Begin()
 	tx.SavePoint("beforeUserUpdate")
-	err = tx.Model(&existingUser).Updates(user).Error
+	err = tx.Model(&existingUser).Updates(profile).Error
 	if err != nil {
 		tx.RollbackTo("beforeUserUpdate")
-		return nil, err
+		tx.Rollback()
+		return nil, uniqueViolation(err)
 	}
 	err = tx.Commit().Error
 	if err != nil {
@@ -34,13 +164,20 @@ func (r *GORMRepo) UpdateUser(id string, user *models.User) (*models.User, error
 
 func (r *GORMRepo) GetAllUsers() ([]models.User, error) {
 	var users []models.User
-	err := r.DB.Find(&users).Error
+	err := r.DB.Preload("Mode").Find(&users).Error
 	if err != nil {
 		return nil, err
 	}
 	return users, nil
 }
 
+// CountUsers returns how many users exist, including deleted users waiting for their purge.
+func (r *GORMRepo) CountUsers() (int64, error) {
+	var count int64
+	err := r.DB.Model(&models.User{}).Count(&count).Error
+	return count, err
+}
+
 func (r *GORMRepo) GetUserByID(id string) (*models.User, error) {
 	var currentUser *models.User
 	err := r.DB.Preload("Mode").First(&currentUser, "id = ?", id).Error
@@ -53,46 +190,92 @@ func (r *GORMRepo) GetUserByID(id string) (*models.User, error) {
 	return currentUser, nil
 }
 
+// GetUserByEmail looks a user up by the email address, regardless of its case.
 func (r *GORMRepo) GetUserByEmail(email string) (*models.User, error) {
 	var user *models.User
-	err := r.DB.Where("email = ?", email).First(&user).Error
+	err := r.DB.Preload("Mode").Where("email = ?", models.NormalizeEmail(email)).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
+// UserNameTaken reports whether a user has the username, regardless of its case, like
+// the unique index on the usernames.
+func (r *GORMRepo) UserNameTaken(name string) (bool, error) {
+	name = models.NormalizeUserName(name)
+	if name == "" {
+		return false, nil
+	}
+	var count int64
+	err := r.DB.Model(&models.User{}).Where("LOWER(user_name) = LOWER(?)", name).Count(&count).Error
+	return count > 0, err
+}
+
 func (r *GORMRepo) CreateAdminUser(user *models.User) error {
+	user.Email = models.NormalizeEmail(user.Email)
+	user.UserName = models.NormalizeUserName(user.UserName)
+
 	tx := r.DB.Begin()
 	tx.SavePoint("beforeCreateAdminUser")
 	if err := tx.Create(&user).Error; err != nil {
 		tx.RollbackTo("beforeCreateAdminUser")
-		return err
+		tx.Rollback()
+		return uniqueViolation(err)
 	}
 	tx.Commit()
 	return nil
 }
 
+// CreateUser inserts a new user with a normalized email address and username. The unique
+// indexes decide whether they are taken, so concurrent registrations can not both succeed.
+//
+// Returns:
+// - string: The ID of the user.
+// - error: ErrUserExists or ErrUserNameTaken if another user has the email address or the username.
 func (r *GORMRepo) CreateUser(user *models.User) (string, error) {
-	var currUser models.User
+	user.Email = models.NormalizeEmail(user.Email)
+	user.UserName = models.NormalizeUserName(user.UserName)
 
-	if err := r.DB.Where("email = ?", user.Email).First(&currUser).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			tx := r.DB.Begin()
-			tx.SavePoint("beforeCreateUser")
-			if err := tx.Create(&user).Error; err != nil {
-				tx.RollbackTo("beforeCreateUser")
-				return "", err
-			}
-			tx.Commit()
-			return user.ID, nil
-		}
+	tx := r.DB.Begin()
+	tx.SavePoint("beforeCreateUser")
+	if err := tx.Create(&user).Error; err != nil {
+		tx.RollbackTo("beforeCreateUser")
+		tx.Rollback()
+		return "", uniqueViolation(err)
+	}
+	if err := tx.Commit().Error; err != nil {
 		return "", err
 	}
+	return user.ID, nil
+}
+
+// ImportUser creates a user imported from another identity provider together with its
+// mode and a confirmation record, which is already confirmed for verified emails.
+func (r *GORMRepo) ImportUser(user *models.User, modeName string, confirmed bool) error {
+	user.Email = models.NormalizeEmail(user.Email)
+	user.UserName = models.NormalizeUserName(user.UserName)
 
-	return "", fmt.Errorf("user already exists")
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return uniqueViolation(err)
+		}
+		if err := tx.Create(&models.Mode{Name: modeName, UserID: user.ID}).Error; err != nil {
+			return err
+		}
+		confirmation := models.UserConfirmation{
+			ID:        uuid.NewString(),
+			UserID:    user.ID,
+			ExpiredAt: time.Now().UTC().Add(30 * time.Minute).Unix(),
+			CreatedAt: time.Now(),
+			Confirmed: confirmed,
+		}
+		return tx.Create(&confirmation).Error
+	})
 }
 
+// DeleteUserByID removes a user for good, together with the mode, confirmations, reset
+// tokens, email changes, data exports, password history and profile image records of the user.
 func (r *GORMRepo) DeleteUserByID(id string) error {
 	user := &models.User{}
 
@@ -102,12 +285,165 @@ func (r *GORMRepo) DeleteUserByID(id string) error {
 		}
 		return err
 	}
-	tx := r.DB.Begin()
-	tx.SavePoint("beforeDeleteUser")
-	if err := tx.Delete(user, "id = ?", id).Error; err != nil {
-		tx.RollbackTo("beforeDeleteUser")
-		return err
+
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		for _, related := range []interface{}{
+			&models.PasswordHistory{},
+			&models.Mode{},
+			&models.UserConfirmation{},
+			&models.PasswordRestToken{},
+			&models.EmailChange{},
+			&models.DataExport{},
+			&models.ProfileImage{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ?", id).Delete(related).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(user, "id = ?", id).Error
+	})
+}
+
+// SoftDeleteUserByID marks a user as deleted and revokes every session of the user.
+// The rows are kept until DeleteUserByID purges them.
+func (r *GORMRepo) SoftDeleteUserByID(id string, at time.Time) error {
+	result := r.DB.Model(&models.User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"deleted_at": at, "sessions_revoked_at": at})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// RestoreUserByID clears the deletion mark of a user.
+//
+// Returns:
+// - error: ErrUserNotDeleted if the user is not marked as deleted.
+func (r *GORMRepo) RestoreUserByID(id string) error {
+	result := r.DB.Model(&models.User{}).Where("id = ? AND deleted_at > ?", id, time.Time{}).
+		Update("deleted_at", time.Time{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotDeleted
+	}
+	return nil
+}
+
+// GetUsersDeletedBefore returns the users marked as deleted before the given time.
+func (r *GORMRepo) GetUsersDeletedBefore(before time.Time) ([]models.User, error) {
+	var users []models.User
+	err := r.DB.Where("deleted_at > ? AND deleted_at <= ?", time.Time{}, before).Find(&users).Error
+	return users, err
+}
+
+// SuspendUserByID blocks a user with the given status and revokes every session of the
+// user, so outstanding tokens stop working right away.
+//
+// Parameters:
+// - id: The ID of the user.
+// - status: models.UserStatusSuspended or models.UserStatusLocked.
+// - reason: Why the user is blocked.
+// - actor: The ID of the admin blocking the user.
+// - until: When the block ends by itself, nil if it does not.
+func (r *GORMRepo) SuspendUserByID(id, status, reason, actor string, until *time.Time) error {
+	now := time.Now()
+	result := r.DB.Model(&models.User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":              status,
+			"status_reason":       reason,
+			"status_changed_by":   actor,
+			"status_changed_at":   now,
+			"status_until":        until,
+			"sessions_revoked_at": now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// ReactivateUserByID sets a suspended or locked user back to active.
+//
+// Returns:
+// - error: ErrUserNotSuspended if the user is neither suspended nor locked.
+func (r *GORMRepo) ReactivateUserByID(id, actor string) error {
+	result := r.DB.Model(&models.User{}).
+		Where("id = ? AND status IN ?", id, []string{models.UserStatusSuspended, models.UserStatusLocked}).
+		Updates(map[string]interface{}{
+			"status":            models.UserStatusActive,
+			"status_reason":     "",
+			"status_changed_by": actor,
+			"status_changed_at": time.Now(),
+			"status_until":      nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotSuspended
+	}
+	return nil
+}
+
+// GetPendingApprovalUsers returns the users waiting for approval that match the filter, oldest first.
+func (r *GORMRepo) GetPendingApprovalUsers(filter PendingUserFilter) ([]models.User, error) {
+	query := r.DB.Preload("Mode").Where("status = ? AND deleted_at <= ?", models.UserStatusPendingApproval, time.Time{})
+
+	if filter.Confirmed != nil {
+		confirmed := r.DB.Model(&models.UserConfirmation{}).Select("user_id").Where("confirmed = ?", true)
+		if *filter.Confirmed {
+			query = query.Where("id IN (?)", confirmed)
+		} else {
+			query = query.Where("id NOT IN (?)", confirmed)
+		}
+	}
+	if filter.Email != "" {
+		query = query.Where("LOWER(email) LIKE ?", "%"+strings.ToLower(filter.Email)+"%")
+	}
+	if !filter.RegisteredAfter.IsZero() {
+		query = query.Where("created_at > ?", filter.RegisteredAfter)
+	}
+	if !filter.RegisteredBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.RegisteredBefore)
+	}
+
+	var users []models.User
+	err := query.Order("created_at").Find(&users).Error
+	return users, err
+}
+
+// DecideApprovalByID approves or rejects a user waiting for approval.
+//
+// Parameters:
+// - id: The ID of the user.
+// - status: models.UserStatusActive to approve, models.UserStatusRejected to reject.
+// - reason: Why the user was rejected, may be empty.
+// - actor: The ID of the admin deciding.
+//
+// Returns:
+// - error: ErrUserNotPending if the user is not waiting for approval.
+func (r *GORMRepo) DecideApprovalByID(id, status, reason, actor string) error {
+	result := r.DB.Model(&models.User{}).Where("id = ? AND status = ?", id, models.UserStatusPendingApproval).
+		Updates(map[string]interface{}{
+			"status":            status,
+			"status_reason":     reason,
+			"status_changed_by": actor,
+			"status_changed_at": time.Now(),
+			"status_until":      nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotPending
 	}
-	tx.Commit()
 	return nil
 }
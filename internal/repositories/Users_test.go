@@ -0,0 +1,165 @@
+package repositories_test
+
+import (
+	"TriceraPass/internal/models"
+	"TriceraPass/internal/repositories"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestRepo returns a repository on a fresh, migrated SQLite database.
+func newTestRepo(t *testing.T) *repositories.GORMRepo {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	repo := &repositories.GORMRepo{DB: db}
+	if err := repo.Migrate(); err != nil {
+		t.Fatalf("Error migrating database: %v", err)
+	}
+	return repo
+}
+
+// newUser returns an unsaved user with the given address and username.
+func newUser(email, name string) *models.User {
+	return &models.User{ID: uuid.NewString(), CreatedAt: time.Now(), Email: email, UserName: name}
+}
+
+// Test that email addresses and usernames are unique regardless of the case
+func TestCreateUserUniqueness(t *testing.T) {
+	repo := newTestRepo(t)
+
+	user := newUser(" Grant@Jurassic.Park ", " Grant ")
+	if _, err := repo.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "grant@jurassic.park" || user.UserName != "Grant" {
+		t.Errorf("expected the address and the username to be normalized, got %q and %q", user.Email, user.UserName)
+	}
+	if found, err := repo.GetUserByEmail("GRANT@jurassic.park."); err != nil || found.ID != user.ID {
+		t.Errorf("expected the lookup to normalize the address, got %+v, error: %v", found, err)
+	}
+
+	if _, err := repo.CreateUser(newUser("grant@JURASSIC.park", "alan")); !errors.Is(err, repositories.ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got %v", err)
+	}
+	if _, err := repo.CreateUser(newUser("alan@jurassic.park", "gRANT")); !errors.Is(err, repositories.ErrUserNameTaken) {
+		t.Errorf("expected ErrUserNameTaken, got %v", err)
+	}
+
+	// Users without a username do not collide
+	for _, email := range []string{"a@jurassic.park", "b@jurassic.park"} {
+		if _, err := repo.CreateUser(newUser(email, "")); err != nil {
+			t.Errorf("expected users without a username to be created, got %v", err)
+		}
+	}
+
+	other := newUser("sattler@jurassic.park", "sattler")
+	if _, err := repo.CreateUser(other); err != nil {
+		t.Fatal(err)
+	}
+	other.UserName = "GRANT"
+	if _, err := repo.UpdateUser(other.ID, other); !errors.Is(err, repositories.ErrUserNameTaken) {
+		t.Errorf("expected the update to a taken username to fail, got %v", err)
+	}
+}
+
+// Test that the migration normalizes existing addresses and refuses existing duplicates
+func TestMigrateUserUniqueness(t *testing.T) {
+	repo := newTestRepo(t)
+	db := repo.DB
+
+	if _, err := repo.CreateUser(newUser("grant@jurassic.park", "grant")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows written before the indexes existed
+	for _, index := range []string{"idx_users_email", "idx_users_user_name_lower"} {
+		if err := db.Exec("DROP INDEX " + index).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Exec("INSERT INTO users (id, email, user_name) VALUES ('legacy', 'GRANT@jurassic.park', 'legacy')").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Migrate(); err == nil || !strings.Contains(err.Error(), "grant@jurassic.park") {
+		t.Errorf("expected the migration to report the duplicate, got %v", err)
+	}
+
+	if err := db.Exec("UPDATE users SET email = ' Ellie@Jurassic.Park' WHERE id = 'legacy'").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var legacy models.User
+	if err := db.First(&legacy, "id = ?", "legacy").Error; err != nil || legacy.Email != "ellie@jurassic.park" {
+		t.Errorf("expected the migration to normalize existing addresses, got %q, error: %v", legacy.Email, err)
+	}
+	if _, err := repo.CreateUser(newUser("ELLIE@jurassic.park", "ellie")); !errors.Is(err, repositories.ErrUserExists) {
+		t.Errorf("expected the migration to recreate the indexes, got %v", err)
+	}
+}
+
+// Test that a profile update with a stale copy of the user keeps the columns changed by other flows
+func TestUpdateUserProfileOnly(t *testing.T) {
+	repo := newTestRepo(t)
+
+	user := newUser("grant@jurassic.park", "grant")
+	user.Password = "old-password"
+	if _, err := repo.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := repo.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An admin suspends the user and the password changes while the update is prepared
+	if err := repo.SuspendUserByID(user.ID, models.UserStatusSuspended, "terms of use", "admin", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ChangePasswordByUserID(user.ID, "new-password", 0, true); err != nil {
+		t.Fatal(err)
+	}
+	current, err := repo.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale.UserName = "alan"
+	stale.FirstName = "Alan"
+	stale.Email = "alan@jurassic.park"
+	if _, err := repo.UpdateUser(user.ID, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := repo.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.UserName != "alan" || updated.FirstName != "Alan" {
+		t.Errorf("expected the profile to be updated, got %q %q", updated.UserName, updated.FirstName)
+	}
+	if updated.Email != "grant@jurassic.park" {
+		t.Errorf("expected the email address to be kept, got %q", updated.Email)
+	}
+	if updated.Status != models.UserStatusSuspended || updated.StatusReason != "terms of use" {
+		t.Errorf("expected the suspension to be kept, got %q (%q)", updated.Status, updated.StatusReason)
+	}
+	if updated.Password != current.Password || current.Password == stale.Password {
+		t.Errorf("expected the new password to be kept, got %q", updated.Password)
+	}
+	if updated.SessionsRevokedAt == nil || current.SessionsRevokedAt == nil || !updated.SessionsRevokedAt.Equal(*current.SessionsRevokedAt) {
+		t.Errorf("expected the session revocation to be kept, got %v", updated.SessionsRevokedAt)
+	}
+}
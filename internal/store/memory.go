@@ -0,0 +1,128 @@
+package store
+
+import (
+	"TriceraPass/internal/ratelimit"
+	"context"
+	"sync"
+	"time"
+)
+
+// code is a single-use code with its expiry.
+type code struct {
+	value   string
+	expires time.Time
+}
+
+// Memory keeps the state in memory, so every instance counts on its own and the state
+// is lost on a restart. It is used when no Redis is configured or reachable.
+type Memory struct {
+	*ratelimit.MemoryStore
+
+	mu        sync.Mutex
+	throttles map[string]Throttle
+	codes     map[string]code
+	lastSweep time.Time
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		MemoryStore: ratelimit.NewMemoryStore(),
+		throttles:   map[string]Throttle{},
+		codes:       map[string]code{},
+	}
+}
+
+// GetThrottle implements Store.
+func (m *Memory) GetThrottle(_ context.Context, key string) (Throttle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.throttles[key], nil
+}
+
+// RecordFailure implements Store.
+func (m *Memory) RecordFailure(_ context.Context, key string, now time.Time, window time.Duration) (Throttle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Forget expired failures once a minute
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, t := range m.throttles {
+			if now.Sub(t.LastFailureAt) > window && !t.Locked(now) {
+				delete(m.throttles, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	throttle := m.throttles[key]
+	if now.Sub(throttle.LastFailureAt) > window && !throttle.Locked(now) {
+		throttle = Throttle{}
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = now
+	m.throttles[key] = throttle
+	return throttle, nil
+}
+
+// Lock implements Store.
+func (m *Memory) Lock(_ context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	throttle := m.throttles[key]
+	throttle.LockedUntil = until
+	m.throttles[key] = throttle
+	return nil
+}
+
+// ResetThrottle implements Store.
+func (m *Memory) ResetThrottle(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.throttles, key)
+	return nil
+}
+
+// SetCode implements Store.
+func (m *Memory) SetCode(_ context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop expired codes so the map does not grow forever
+	now := time.Now()
+	for k, c := range m.codes {
+		if !now.Before(c.expires) {
+			delete(m.codes, k)
+		}
+	}
+
+	m.codes[key] = code{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+// Claim implements Store.
+func (m *Memory) Claim(_ context.Context, key, value string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if c, ok := m.codes[key]; ok && now.Before(c.expires) {
+		return false, nil
+	}
+	m.codes[key] = code{value: value, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// TakeCode implements Store.
+func (m *Memory) TakeCode(_ context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.codes[key]
+	delete(m.codes, key)
+	if !ok || !time.Now().Before(c.expires) {
+		return "", ErrCodeNotFound
+	}
+	return c.value, nil
+}
@@ -0,0 +1,222 @@
+package store
+
+import (
+	"TriceraPass/internal/ratelimit"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills a token bucket and takes a token, atomically for all replicas.
+// The bucket expires once it would be full again.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local refill = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = capacity
+	updated = now
+end
+if now > updated then
+	tokens = math.min(capacity, tokens + (now - updated) * refill)
+	updated = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(updated))
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / refill) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// failureScript counts a failed login. The counter expires with the window or the
+// lockout, whichever ends later.
+var failureScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local state = redis.call('HMGET', KEYS[1], 'failures', 'last', 'locked_until')
+local failures = tonumber(state[1]) or 0
+local last = tonumber(state[2]) or 0
+local locked = tonumber(state[3]) or 0
+if now - last > window and now >= locked then
+	failures = 0
+end
+failures = failures + 1
+
+redis.call('HSET', KEYS[1], 'failures', failures, 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.max(window, locked - now))
+return {failures, tostring(locked)}
+`)
+
+// lockScript locks a key and keeps it at least until the lockout ends.
+var lockScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], 'locked_until', ARGV[1])
+local remaining = tonumber(ARGV[1]) - tonumber(ARGV[2])
+if redis.call('PTTL', KEYS[1]) < remaining then
+	redis.call('PEXPIRE', KEYS[1], remaining)
+end
+return 1
+`)
+
+// Redis keeps the state in Redis, shared by every replica.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis wraps a Redis client.
+//
+// Parameters:
+// - client: The Redis client.
+// - prefix: Prefix of all keys.
+//
+// Returns:
+// - *Redis: The store.
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+// OpenRedis connects to the Redis of the configuration and checks that it answers.
+//
+// Parameters:
+// - ctx: Bounds the connection check.
+// - config: The redis section of settings.yml.
+//
+// Returns:
+// - *Redis: The store.
+// - error: An error if Redis can not be reached.
+func OpenRedis(ctx context.Context, config RedisConfig) (*Redis, error) {
+	port := config.Port
+	if port == 0 {
+		port = 6379
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = "tricerapass:"
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", config.Host, port),
+		Password: config.Password,
+		DB:       config.DB,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("could not reach redis at %s:%d: %w", config.Host, port, err)
+	}
+	return NewRedis(client, prefix), nil
+}
+
+// Close closes the connections to Redis.
+func (s *Redis) Close() error {
+	return s.client.Close()
+}
+
+// Take implements ratelimit.Store.
+func (s *Redis) Take(ctx context.Context, key string, rule ratelimit.Rule, now time.Time) (ratelimit.Result, error) {
+	reply, err := takeScript.Run(ctx, s.client, []string{s.prefix + key},
+		rule.Capacity(), rule.Refill()/1000, now.UnixMilli()).Slice()
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	if len(reply) != 2 {
+		return ratelimit.Result{}, errors.New("unexpected reply of the rate limit script")
+	}
+
+	allowed, _ := reply[0].(int64)
+	tokens, err := strconv.ParseFloat(fmt.Sprint(reply[1]), 64)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	return rule.Result(allowed == 1, tokens), nil
+}
+
+// GetThrottle implements Store.
+func (s *Redis) GetThrottle(ctx context.Context, key string) (Throttle, error) {
+	values, err := s.client.HMGet(ctx, s.prefix+key, "failures", "last", "locked_until").Result()
+	if err != nil {
+		return Throttle{}, err
+	}
+
+	var throttle Throttle
+	throttle.Failures = int(parseInt(values[0]))
+	throttle.LastFailureAt = parseMillis(values[1])
+	throttle.LockedUntil = parseMillis(values[2])
+	return throttle, nil
+}
+
+// RecordFailure implements Store.
+func (s *Redis) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (Throttle, error) {
+	reply, err := failureScript.Run(ctx, s.client, []string{s.prefix + key},
+		now.UnixMilli(), window.Milliseconds()).Slice()
+	if err != nil {
+		return Throttle{}, err
+	}
+	if len(reply) != 2 {
+		return Throttle{}, errors.New("unexpected reply of the failed login script")
+	}
+
+	failures, _ := reply[0].(int64)
+	return Throttle{
+		Failures:      int(failures),
+		LastFailureAt: time.UnixMilli(now.UnixMilli()),
+		LockedUntil:   parseMillis(reply[1]),
+	}, nil
+}
+
+// Lock implements Store.
+func (s *Redis) Lock(ctx context.Context, key string, until time.Time) error {
+	return lockScript.Run(ctx, s.client, []string{s.prefix + key},
+		until.UnixMilli(), time.Now().UnixMilli()).Err()
+}
+
+// ResetThrottle implements Store.
+func (s *Redis) ResetThrottle(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+// SetCode implements Store.
+func (s *Redis) SetCode(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+// TakeCode implements Store.
+func (s *Redis) TakeCode(ctx context.Context, key string) (string, error) {
+	value, err := s.client.GetDel(ctx, s.prefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrCodeNotFound
+	}
+	return value, err
+}
+
+// Claim implements Store.
+func (s *Redis) Claim(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+key, value, ttl).Result()
+}
+
+// parseInt reads an integer reply, 0 if it is missing.
+func parseInt(value interface{}) int64 {
+	n, _ := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+	return n
+}
+
+// parseMillis reads a Unix time in milliseconds, the zero time if it is missing.
+func parseMillis(value interface{}) time.Time {
+	if ms := parseInt(value); ms > 0 {
+		return time.UnixMilli(ms)
+	}
+	return time.Time{}
+}
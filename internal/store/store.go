@@ -0,0 +1,58 @@
+// Package store keeps the short-lived state that has to be shared by all replicas of
+// the service: rate limit buckets, failed login counters and single-use codes. It is
+// kept in Redis when one is configured, otherwise in memory of the single instance.
+package store
+
+import (
+	"TriceraPass/internal/ratelimit"
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCodeNotFound is returned when a single-use code is unknown, expired or was used.
+var ErrCodeNotFound = errors.New("code not found")
+
+// Store keeps the shared state. All methods are safe for concurrent use.
+type Store interface {
+	ratelimit.Store
+
+	// GetThrottle returns the failed logins counted for the key.
+	GetThrottle(ctx context.Context, key string) (Throttle, error)
+	// RecordFailure counts a failed login for the key. Failures older than the window
+	// are forgotten unless the key is locked.
+	RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (Throttle, error)
+	// Lock blocks the key until the given time.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// ResetThrottle forgets the failed logins of the key and lifts its lock.
+	ResetThrottle(ctx context.Context, key string) error
+
+	// SetCode stores a single-use code, replacing an existing one.
+	SetCode(ctx context.Context, key, value string, ttl time.Duration) error
+	// TakeCode returns the value of a code and deletes it, ErrCodeNotFound if there is none.
+	TakeCode(ctx context.Context, key string) (string, error)
+	// Claim stores a code only if the key is free and reports whether it did, so that
+	// one replica at a time holds the key until it is taken or expires.
+	Claim(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+}
+
+// Throttle is the failed login count of one email address or IP address.
+type Throttle struct {
+	Failures      int       // Failed logins in the window
+	LastFailureAt time.Time // Time of the last failed login
+	LockedUntil   time.Time // End of the lockout, zero if the key is not locked
+}
+
+// Locked reports whether logins for the key are blocked.
+func (t Throttle) Locked(now time.Time) bool {
+	return now.Before(t.LockedUntil)
+}
+
+// RedisConfig is the redis section of settings.yml.
+type RedisConfig struct {
+	Host     string `yaml:"host"`     // Redis host, the state is kept in memory if empty
+	Port     int    `yaml:"port"`     // Redis port, 6379 by default
+	Password string `yaml:"password"` // Redis password, if any
+	DB       int    `yaml:"db"`       // Redis database number
+	Prefix   string `yaml:"prefix"`   // Prefix of all keys, "tricerapass:" by default
+}
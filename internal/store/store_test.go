@@ -0,0 +1,173 @@
+package store
+
+import (
+	"TriceraPass/internal/ratelimit"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testStore is a store under test.
+type testStore struct {
+	Store
+	expire func(d time.Duration) // Lets the store notice that time passed
+}
+
+// stores returns the stores to test: memory, an in-process fake Redis and, if
+// TEST_REDIS_ADDR is set, a real Redis.
+func stores(t *testing.T) map[string]testStore {
+	t.Helper()
+	sleep := func(d time.Duration) { time.Sleep(d) }
+
+	fake := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: fake.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	all := map[string]testStore{
+		"memory":    {NewMemory(), sleep},
+		"miniredis": {NewRedis(client, "test:"), fake.FastForward}, // miniredis only expires keys when told to
+	}
+
+	if addr := os.Getenv("TEST_REDIS_ADDR"); addr != "" {
+		live := redis.NewClient(&redis.Options{Addr: addr})
+		t.Cleanup(func() { _ = live.Close() })
+		all["redis"] = testStore{NewRedis(live, "tricerapass-test:"+time.Now().Format("150405.000")+":"), sleep}
+	}
+	return all
+}
+
+// Test that every store runs the same token bucket
+func TestTake(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			rule := ratelimit.Rule{RequestsPerMinute: 60, Burst: 2}
+			now := time.Now()
+
+			for i := 0; i < 2; i++ {
+				result, err := s.Take(ctx, "bucket", rule, now)
+				if err != nil || !result.Allowed || result.Remaining != 1-i {
+					t.Fatalf("request %d: unexpected result %+v, %v", i+1, result, err)
+				}
+			}
+
+			result, err := s.Take(ctx, "bucket", rule, now)
+			if err != nil || result.Allowed || result.RetryAfter != time.Second || result.Limit != 2 {
+				t.Fatalf("expected the empty bucket to reject, got %+v, %v", result, err)
+			}
+
+			result, err = s.Take(ctx, "bucket", rule, now.Add(1500*time.Millisecond))
+			if err != nil || !result.Allowed || result.Remaining != 0 {
+				t.Errorf("expected a token after a second, got %+v, %v", result, err)
+			}
+		})
+	}
+}
+
+// Test counting, locking and resetting failed logins
+func TestThrottle(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			window := time.Minute
+
+			if throttle, err := s.GetThrottle(ctx, "email:alan"); err != nil || throttle.Failures != 0 {
+				t.Fatalf("expected no failures, got %+v, %v", throttle, err)
+			}
+
+			for i := 1; i <= 3; i++ {
+				throttle, err := s.RecordFailure(ctx, "email:alan", now, window)
+				if err != nil || throttle.Failures != i {
+					t.Fatalf("expected %d failures, got %+v, %v", i, throttle, err)
+				}
+			}
+
+			// Failures outside the window start over
+			throttle, err := s.RecordFailure(ctx, "email:alan", now.Add(2*window), window)
+			if err != nil || throttle.Failures != 1 {
+				t.Fatalf("expected the count to start over, got %+v, %v", throttle, err)
+			}
+
+			until := time.Now().Add(time.Hour)
+			if err := s.Lock(ctx, "email:alan", until); err != nil {
+				t.Fatal(err)
+			}
+			throttle, err = s.GetThrottle(ctx, "email:alan")
+			if err != nil || !throttle.Locked(time.Now()) || throttle.LockedUntil.UnixMilli() != until.UnixMilli() {
+				t.Fatalf("expected the key to be locked, got %+v, %v", throttle, err)
+			}
+
+			// A locked key keeps counting past the window
+			throttle, err = s.RecordFailure(ctx, "email:alan", now.Add(4*window), window)
+			if err != nil || throttle.Failures != 2 || !throttle.Locked(time.Now()) {
+				t.Fatalf("expected the locked key to keep its count, got %+v, %v", throttle, err)
+			}
+
+			if err := s.ResetThrottle(ctx, "email:alan"); err != nil {
+				t.Fatal(err)
+			}
+			if throttle, err := s.GetThrottle(ctx, "email:alan"); err != nil || throttle.Failures != 0 || throttle.Locked(time.Now()) {
+				t.Errorf("expected the reset to forget everything, got %+v, %v", throttle, err)
+			}
+		})
+	}
+}
+
+// Test that codes work once and expire
+func TestCodes(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if err := s.SetCode(ctx, "code:1", "alan", time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if value, err := s.TakeCode(ctx, "code:1"); err != nil || value != "alan" {
+				t.Fatalf("expected the code, got %q, %v", value, err)
+			}
+			if _, err := s.TakeCode(ctx, "code:1"); !errors.Is(err, ErrCodeNotFound) {
+				t.Errorf("expected the code to work once, got %v", err)
+			}
+
+			if err := s.SetCode(ctx, "code:2", "ellie", 10*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+			s.expire(20 * time.Millisecond)
+			if _, err := s.TakeCode(ctx, "code:2"); !errors.Is(err, ErrCodeNotFound) {
+				t.Errorf("expected the code to expire, got %v", err)
+			}
+		})
+	}
+}
+
+// Test that a claimed key can not be claimed again until it is taken or expires
+func TestClaim(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if ok, err := s.Claim(ctx, "job:purge", "replica-1", time.Minute); err != nil || !ok {
+				t.Fatalf("expected the free key to be claimed, got %v, %v", ok, err)
+			}
+			if ok, err := s.Claim(ctx, "job:purge", "replica-2", time.Minute); err != nil || ok {
+				t.Fatalf("expected the claimed key to be refused, got %v, %v", ok, err)
+			}
+			if value, err := s.TakeCode(ctx, "job:purge"); err != nil || value != "replica-1" {
+				t.Fatalf("expected the claim to be released, got %q, %v", value, err)
+			}
+			if ok, err := s.Claim(ctx, "job:purge", "replica-2", 10*time.Millisecond); err != nil || !ok {
+				t.Fatalf("expected the released key to be claimed, got %v, %v", ok, err)
+			}
+			s.expire(20 * time.Millisecond)
+			if ok, err := s.Claim(ctx, "job:purge", "replica-1", time.Minute); err != nil || !ok {
+				t.Errorf("expected the expired claim to be free, got %v, %v", ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package testenv starts a complete TriceraPass server for tests: the real routes
+// backed by a fresh SQLite database and a fake Mailgun API that records every email.
+// It is shared by the test suites of the handlers, the application and the Go client.
+package testenv
+
+import (
+	"TriceraPass/cmd/api/application"
+	"TriceraPass/cmd/api/auth"
+	"TriceraPass/cmd/api/server"
+	"TriceraPass/internal/repositories"
+	"TriceraPass/pkg/client"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Main runs a test suite from the repository root, where the email templates are
+// found, and is called from the TestMain of the suites using Env.
+//
+// Parameters:
+// - m: The test suite.
+func Main(m *testing.M) {
+	root, err := moduleRoot()
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// moduleRoot returns the closest parent directory holding the go.mod file.
+func moduleRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("go.mod not found")
+		}
+		dir = parent
+	}
+}
+
+// Email is a message captured by the fake Mailgun API.
+type Email struct {
+	To      string
+	Subject string
+	HTML    string
+}
+
+// Mailgun records the messages sent through the Mailgun API.
+type Mailgun struct {
+	mu     sync.Mutex
+	emails []Email
+}
+
+func (f *Mailgun) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseMultipartForm(1 << 20)
+	f.mu.Lock()
+	f.emails = append(f.emails, Email{To: r.FormValue("to"), Subject: r.FormValue("subject"), HTML: r.FormValue("html")})
+	f.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]string{"id": "<test@mailgun>", "message": "Queued. Thank you."})
+}
+
+// Sent returns a copy of the captured messages.
+func (f *Mailgun) Sent() []Email {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Email(nil), f.emails...)
+}
+
+// WaitFor waits until at least n messages were captured, since most handlers
+// send their emails after writing the response.
+func (f *Mailgun) WaitFor(t *testing.T, n int) []Email {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		if sent := f.Sent(); len(sent) >= n {
+			return sent
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("expected %d emails, got %d", n, len(f.Sent()))
+	return nil
+}
+
+// Env is a TriceraPass server backed by SQLite and a fake Mailgun API.
+type Env struct {
+	Server *httptest.Server
+	App    *application.Application
+	Mail   *Mailgun
+}
+
+// New starts the real server.Routes against a fresh database.
+//
+// Parameters:
+// - t: The running test, the server is stopped when it ends.
+//
+// Returns:
+// - *Env: The running server.
+func New(t *testing.T) *Env {
+	t.Helper()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "static", "default"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "static", "default", "default_1.png"), []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(root, "test.db")+"?_busy_timeout=5000"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+
+	config := &application.Config{}
+	config.Security.Scopes = map[string][]string{"admin": {"users:read"}}
+
+	app := &application.Application{
+		Root:       root,
+		Config:     config,
+		Repository: &repositories.GORMRepo{DB: db},
+		JWTSecret:  "test-secret",
+		JWTIssuer:  "test-issuer",
+		Auth: auth.Auth{
+			Issuer:           "test-issuer",
+			Audience:         "test-audience",
+			Secret:           "test-secret",
+			TokenExpiry:      time.Minute * 15,
+			RefreshExpiry:    time.Hour * 24,
+			CookiePath:       "/",
+			CookieName:       "refresh_token",
+			AccessCookieName: "access_token",
+		},
+	}
+	if err := app.Repository.Migrate(); err != nil {
+		t.Fatalf("Error migrating database: %v", err)
+	}
+
+	mail := &Mailgun{}
+	mailServer := httptest.NewServer(mail)
+	t.Cleanup(mailServer.Close)
+
+	t.Setenv("CORS", "http://localhost:3000")
+	t.Setenv("MAIL_SERVER_DOMAIN", "mg.test")
+	t.Setenv("MAIL_SERVER_API_KEY", "key-test")
+	t.Setenv("MAIL_SERVER_API_BASE", mailServer.URL+"/v3")
+
+	srv := httptest.NewServer(server.Routes(app))
+	t.Cleanup(srv.Close)
+
+	return &Env{Server: srv, App: app, Mail: mail}
+}
+
+// Register creates a user with the address name@jurassic.park through the API and returns its ID.
+func (e *Env) Register(t *testing.T, name, password string) string {
+	t.Helper()
+	userID, err := client.New(e.Server.URL).Register(context.Background(), client.RegisterRequest{
+		UserName: name, FirstName: name, LastName: "Test", Email: name + "@jurassic.park", Password: password,
+	})
+	if err != nil {
+		t.Fatalf("Error registering %s: %v", name, err)
+	}
+	return userID
+}
+
+// Login returns a client logged in as the user registered by Register.
+func (e *Env) Login(t *testing.T, name, password string) *client.Client {
+	t.Helper()
+	c := client.New(e.Server.URL)
+	if _, err := c.Login(context.Background(), name+"@jurassic.park", password); err != nil {
+		t.Fatalf("Error logging in as %s: %v", name, err)
+	}
+	return c
+}
+
+var tokenLink = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// MailToken extracts the token from the link in the latest email to an address whose
+// subject starts with the given prefix.
+func MailToken(t *testing.T, sent []Email, to, subject string) string {
+	t.Helper()
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].To != to || !strings.HasPrefix(sent[i].Subject, subject) {
+			continue
+		}
+		if match := tokenLink.FindStringSubmatch(sent[i].HTML); match != nil {
+			return match[1]
+		}
+	}
+	t.Fatalf("expected a %q email with a link to %s", subject, to)
+	return ""
+}
+
+// ConfirmationToken extracts the token from the link in the latest confirmation email to an address.
+func ConfirmationToken(t *testing.T, sent []Email, to string) string {
+	t.Helper()
+	return MailToken(t, sent, to, "Sign Up Confirmation")
+}
+
+// ResetToken extracts the token from the link in the latest password reset email.
+func ResetToken(t *testing.T, sent []Email) string {
+	t.Helper()
+	for i := len(sent) - 1; i >= 0; i-- {
+		if !strings.HasPrefix(sent[i].Subject, "Password Reset") {
+			continue
+		}
+		if match := tokenLink.FindStringSubmatch(sent[i].HTML); match != nil {
+			return match[1]
+		}
+	}
+	t.Fatal("expected a password reset email with a reset link")
+	return ""
+}
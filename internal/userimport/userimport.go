@@ -0,0 +1,281 @@
+// Package userimport reads user exports of other identity providers into a common
+// record format, keeping the existing password hashes so users can log in with
+// their old passwords. The hashes are verified by the passwords package and
+// upgraded to the native format on the first login.
+package userimport
+
+import (
+	"TriceraPass/internal/passwords"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Supported export formats.
+const (
+	FormatJSON     = "json"     // JSON array of Record
+	FormatCSV      = "csv"      // CSV with a header row naming Record fields, e.g. a Django auth_user dump
+	FormatFirebase = "firebase" // Output of firebase auth:export in JSON
+	FormatAuth0    = "auth0"    // Auth0 user export, newline delimited or as a JSON array
+)
+
+// Record is a user to import.
+type Record struct {
+	Email         string `json:"email"`
+	UserName      string `json:"username"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	PasswordHash  string `json:"password_hash"` // bcrypt, argon2id, Django pbkdf2_sha256 or encoded Firebase scrypt, empty for users without a password
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// Failure is a record that could not be imported.
+type Failure struct {
+	Row    int    `json:"row"` // Position of the record in the export, starting at 1
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
+
+// Report summarizes an import.
+type Report struct {
+	DryRun   bool      `json:"dry_run"`  // Nothing was written
+	Total    int       `json:"total"`    // Records in the export
+	Imported int       `json:"imported"` // Records imported, or that would be imported in a dry run
+	Failed   []Failure `json:"failed"`   // Records that were skipped
+}
+
+// Parse reads an export.
+//
+// Parameters:
+// - format: One of the Format constants.
+// - project: The Firebase project the hashes belong to, as configured under security.password_hashing.firebase.
+// - r: The export.
+//
+// Returns:
+// - []Record: The users in the export, with defaults filled in.
+// - error: An error if the export can not be read.
+func Parse(format, project string, r io.Reader) ([]Record, error) {
+	var records []Record
+	var err error
+
+	switch format {
+	case FormatJSON:
+		err = json.NewDecoder(r).Decode(&records)
+	case FormatCSV:
+		records, err = parseCSV(r)
+	case FormatFirebase:
+		if project == "" {
+			return nil, errors.New("firebase imports need the project name")
+		}
+		records, err = parseFirebase(r, project)
+	case FormatAuth0:
+		records, err = parseAuth0(r)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s export: %w", format, err)
+	}
+
+	for i := range records {
+		records[i].normalize()
+	}
+	return records, nil
+}
+
+// Validate reports why a record can not be imported.
+//
+// Returns:
+// - error: An error if the email is missing or no configured algorithm can verify the hash.
+func (rec *Record) Validate() error {
+	if rec.Email == "" || !strings.Contains(rec.Email, "@") {
+		return errors.New("missing or invalid email")
+	}
+	if rec.PasswordHash != "" && !passwords.Recognizes(rec.PasswordHash) {
+		return errors.New("unsupported password hash format")
+	}
+	return nil
+}
+
+// normalize trims the fields and fills in the username.
+func (rec *Record) normalize() {
+	rec.Email = strings.TrimSpace(rec.Email)
+	rec.UserName = strings.TrimSpace(rec.UserName)
+	rec.FirstName = strings.TrimSpace(rec.FirstName)
+	rec.LastName = strings.TrimSpace(rec.LastName)
+	rec.PasswordHash = strings.TrimSpace(rec.PasswordHash)
+
+	// Django marks accounts without a usable password with a leading "!"
+	if strings.HasPrefix(rec.PasswordHash, "!") {
+		rec.PasswordHash = ""
+	}
+
+	if rec.UserName == "" {
+		rec.UserName = strings.SplitN(rec.Email, "@", 2)[0]
+	}
+}
+
+// parseCSV reads a CSV export whose header names the columns. Django's "password"
+// and "is_email_verified" style columns are accepted as well.
+func parseCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	field := func(row []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return row[i]
+			}
+		}
+		return ""
+	}
+
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		verified, _ := strconv.ParseBool(field(row, "email_verified", "is_email_verified", "verified"))
+		records = append(records, Record{
+			Email:         field(row, "email"),
+			UserName:      field(row, "username"),
+			FirstName:     field(row, "first_name"),
+			LastName:      field(row, "last_name"),
+			PasswordHash:  field(row, "password_hash", "password"),
+			EmailVerified: verified,
+		})
+	}
+	return records, nil
+}
+
+// firebaseExport is the file written by firebase auth:export --format=json.
+type firebaseExport struct {
+	Users []struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"emailVerified"`
+		DisplayName   string `json:"displayName"`
+		PasswordHash  string `json:"passwordHash"`
+		Salt          string `json:"salt"`
+	} `json:"users"`
+}
+
+// parseFirebase reads a Firebase export and encodes the scrypt hashes for the project.
+func parseFirebase(r io.Reader, project string) ([]Record, error) {
+	var export firebaseExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(export.Users))
+	for _, user := range export.Users {
+		rec := Record{Email: user.Email, EmailVerified: user.EmailVerified}
+		rec.FirstName, rec.LastName = splitName(user.DisplayName)
+		if user.PasswordHash != "" {
+			rec.PasswordHash = passwords.FirebaseHash(project, user.Salt, user.PasswordHash)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// auth0User is a user of an Auth0 export.
+type auth0User struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Username      string `json:"username"`
+	Nickname      string `json:"nickname"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	PasswordHash  string `json:"passwordHash"`
+}
+
+// parseAuth0 reads an Auth0 export, either newline delimited JSON as written by the
+// export job or a JSON array.
+func parseAuth0(r io.Reader) ([]Record, error) {
+	reader := bufio.NewReader(r)
+	first, err := firstNonSpace(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []auth0User
+	if first == '[' {
+		if err := json.NewDecoder(reader).Decode(&users); err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := json.NewDecoder(reader)
+		for {
+			var user auth0User
+			if err := decoder.Decode(&user); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			users = append(users, user)
+		}
+	}
+
+	records := make([]Record, 0, len(users))
+	for _, user := range users {
+		username := user.Username
+		if username == "" {
+			username = user.Nickname
+		}
+		records = append(records, Record{
+			Email:         user.Email,
+			UserName:      username,
+			FirstName:     user.GivenName,
+			LastName:      user.FamilyName,
+			PasswordHash:  user.PasswordHash,
+			EmailVerified: user.EmailVerified,
+		})
+	}
+	return records, nil
+}
+
+// firstNonSpace peeks at the first non-whitespace byte without consuming it.
+func firstNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+// splitName splits a display name into first and last name.
+func splitName(name string) (string, string) {
+	parts := strings.Fields(name)
+	if len(parts) == 0 {
+		return "", ""
+	}
+	return parts[0], strings.Join(parts[1:], " ")
+}
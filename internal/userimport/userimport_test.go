@@ -0,0 +1,96 @@
+package userimport
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test reading the supported export formats
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, format, project, export string
+		want                          Record
+	}{
+		{
+			name:   "json",
+			format: FormatJSON,
+			export: `[{"email": " grant@jurassic.park ", "first_name": "Alan", "password_hash": "$2a$04$abc", "email_verified": true}]`,
+			want:   Record{Email: "grant@jurassic.park", UserName: "grant", FirstName: "Alan", PasswordHash: "$2a$04$abc", EmailVerified: true},
+		},
+		{
+			name:   "django csv",
+			format: FormatCSV,
+			export: "id,username,email,first_name,last_name,password,is_superuser\n1,alan,grant@jurassic.park,Alan,Grant,pbkdf2_sha256$1$salt$aGFzaA==,0\n",
+			want:   Record{Email: "grant@jurassic.park", UserName: "alan", FirstName: "Alan", LastName: "Grant", PasswordHash: "pbkdf2_sha256$1$salt$aGFzaA=="},
+		},
+		{
+			name:   "django unusable password",
+			format: FormatCSV,
+			export: "email,password\ngrant@jurassic.park,!unusable\n",
+			want:   Record{Email: "grant@jurassic.park", UserName: "grant"},
+		},
+		{
+			name:    "firebase",
+			format:  FormatFirebase,
+			project: "isla-nublar",
+			export:  `{"users": [{"localId": "x", "email": "grant@jurassic.park", "emailVerified": true, "displayName": "Alan Grant", "passwordHash": "aGFzaA==", "salt": "c2FsdA=="}]}`,
+			want:    Record{Email: "grant@jurassic.park", UserName: "grant", FirstName: "Alan", LastName: "Grant", PasswordHash: "$firebase-scrypt$project=isla-nublar$c2FsdA==$aGFzaA==", EmailVerified: true},
+		},
+		{
+			name:   "auth0 ndjson",
+			format: FormatAuth0,
+			export: "{\"email\": \"grant@jurassic.park\", \"nickname\": \"alan\", \"given_name\": \"Alan\", \"passwordHash\": \"$2b$10$abc\"}\n",
+			want:   Record{Email: "grant@jurassic.park", UserName: "alan", FirstName: "Alan", PasswordHash: "$2b$10$abc"},
+		},
+		{
+			name:   "auth0 array",
+			format: FormatAuth0,
+			export: "\n [{\"email\": \"grant@jurassic.park\", \"username\": \"alan\"}]",
+			want:   Record{Email: "grant@jurassic.park", UserName: "alan"},
+		},
+	}
+
+	for _, tt := range tests {
+		records, err := Parse(tt.format, tt.project, strings.NewReader(tt.export))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(records) != 1 || records[0] != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, records)
+		}
+	}
+}
+
+// Test that unusable exports are rejected
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse("ldap", "", strings.NewReader("")); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+	if _, err := Parse(FormatFirebase, "", strings.NewReader(`{"users": []}`)); err == nil {
+		t.Error("expected a firebase import without project to be rejected")
+	}
+	if _, err := Parse(FormatJSON, "", strings.NewReader(`{"broken"`)); err == nil {
+		t.Error("expected malformed JSON to be rejected")
+	}
+}
+
+// Test record validation against the known hash formats
+func TestValidate(t *testing.T) {
+	valid := Record{Email: "grant@jurassic.park", PasswordHash: "pbkdf2_sha256$1$salt$aGFzaA=="}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected a django hash to be accepted, got %v", err)
+	}
+	noPassword := Record{Email: "grant@jurassic.park"}
+	if err := noPassword.Validate(); err != nil {
+		t.Errorf("expected a user without password to be accepted, got %v", err)
+	}
+	unknown := Record{Email: "grant@jurassic.park", PasswordHash: "md5$abc"}
+	if err := unknown.Validate(); err == nil {
+		t.Error("expected an unknown hash format to be rejected")
+	}
+	noEmail := Record{UserName: "grant"}
+	if err := noEmail.Validate(); err == nil {
+		t.Error("expected a record without email to be rejected")
+	}
+}
@@ -0,0 +1,338 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ListUsers returns all users. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - []User: The users.
+// - error: An *APIError if the request fails.
+func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
+	var users []User
+	if _, err := c.callEnvelope(ctx, true, http.MethodGet, "/auth/api/admin/users", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteUser deletes a user by ID. Requires an admin client. The user can be restored
+// with RestoreUser until the server purges the account after its grace period.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+//
+// Returns:
+// - error: An *APIError if the request fails.
+func (c *Client) DeleteUser(ctx context.Context, userID string) error {
+	_, err := c.callEnvelope(ctx, true, http.MethodDelete, "/auth/api/admin/user/"+url.PathEscape(userID), nil, nil)
+	return err
+}
+
+// SuspendUser suspends or locks a user. Requires an admin client. Every session of the
+// user is logged out and logins are rejected with ErrForbidden until the suspension ends.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+// - request: The status, reason and end of the suspension.
+//
+// Returns:
+// - error: An *APIError if the request fails, ErrBadRequest for an invalid status or end.
+func (c *Client) SuspendUser(ctx context.Context, userID string, request SuspendRequest) error {
+	_, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/admin/user/"+url.PathEscape(userID)+"/suspend", request, nil)
+	return err
+}
+
+// ReactivateUser lifts the suspension or lock of a user. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+//
+// Returns:
+// - error: An *APIError if the request fails, ErrConflict if the user is not suspended.
+func (c *Client) ReactivateUser(ctx context.Context, userID string) error {
+	_, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/admin/user/"+url.PathEscape(userID)+"/reactivate", nil, nil)
+	return err
+}
+
+// HashingMetrics returns the metrics of the server's password hashing pool. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - *HashingMetrics: The pool metrics.
+// - error: An *APIError if the request fails.
+func (c *Client) HashingMetrics(ctx context.Context) (*HashingMetrics, error) {
+	var metrics HashingMetrics
+	if _, err := c.callEnvelope(ctx, true, http.MethodGet, "/auth/api/admin/metrics/hashing", nil, &metrics); err != nil {
+		return nil, err
+	}
+	return &metrics, nil
+}
+
+// ListModes returns all user modes. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - []Mode: The modes.
+// - error: An *APIError if the request fails.
+func (c *Client) ListModes(ctx context.Context) ([]Mode, error) {
+	var modes []Mode
+	if err := c.callAuthed(ctx, http.MethodGet, "/auth/api/admin/user/modes", nil, &modes); err != nil {
+		return nil, err
+	}
+	return modes, nil
+}
+
+// CreateMode assigns a mode to a user. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+// - name: The name of the mode.
+//
+// Returns:
+// - *Mode: The created mode.
+// - error: An *APIError if the request fails.
+func (c *Client) CreateMode(ctx context.Context, userID, name string) (*Mode, error) {
+	payload := struct {
+		Name   string `json:"mode_name"`
+		UserID string `json:"user_id"`
+	}{Name: name, UserID: userID}
+
+	var mode Mode
+	if _, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/admin/user/mode", payload, &mode); err != nil {
+		return nil, err
+	}
+	return &mode, nil
+}
+
+// UpdateMode renames a mode. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - modeID: The ID of the mode.
+// - name: The new name of the mode.
+//
+// Returns:
+// - *Mode: The updated mode.
+// - error: An *APIError if the request fails.
+func (c *Client) UpdateMode(ctx context.Context, modeID uint, name string) (*Mode, error) {
+	payload := struct {
+		Name string `json:"mode_name"`
+	}{Name: name}
+
+	var mode Mode
+	path := "/auth/api/admin/user/mode/" + strconv.FormatUint(uint64(modeID), 10)
+	if _, err := c.callEnvelope(ctx, true, http.MethodPatch, path, payload, &mode); err != nil {
+		return nil, err
+	}
+	return &mode, nil
+}
+
+// DeleteMode deletes a mode. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - modeID: The ID of the mode.
+//
+// Returns:
+// - error: An *APIError if the request fails.
+func (c *Client) DeleteMode(ctx context.Context, modeID uint) error {
+	path := "/auth/api/admin/user/mode/" + strconv.FormatUint(uint64(modeID), 10)
+	_, err := c.callEnvelope(ctx, true, http.MethodDelete, path, nil, nil)
+	return err
+}
+
+// ImportUsers uploads a user export, keeping the users' password hashes. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - format: "firebase", "auth0", "csv" or "json".
+// - project: The Firebase project whose hash parameters are configured on the server, empty for other formats.
+// - export: The exported users.
+// - dryRun: Only check the export, nothing is imported.
+//
+// Returns:
+// - *ImportReport: The number of imported users and the skipped records.
+// - error: An *APIError if the export can not be read.
+func (c *Client) ImportUsers(ctx context.Context, format, project string, export io.Reader, dryRun bool) (*ImportReport, error) {
+	data, err := io.ReadAll(export)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("format", format)
+	if project != "" {
+		query.Set("project", project)
+	}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+
+	contentType := "application/json"
+	if format == "csv" {
+		contentType = "text/csv"
+	}
+
+	var report ImportReport
+	body := rawBody{contentType: contentType, data: data}
+	if _, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/admin/users/import?"+query.Encode(), body, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// UnlockUser lifts the login lockout of a user. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+//
+// Returns:
+// - error: An *APIError if the request fails.
+func (c *Client) UnlockUser(ctx context.Context, userID string) error {
+	_, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/admin/user/"+url.PathEscape(userID)+"/unlock", nil, nil)
+	return err
+}
+
+// RestoreUser restores a deleted user within the deletion grace period. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+//
+// Returns:
+// - error: An *APIError if the user is not deleted (409) or can no longer be restored (410).
+func (c *Client) RestoreUser(ctx context.Context, userID string) error {
+	_, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/admin/user/"+url.PathEscape(userID)+"/restore", nil, nil)
+	return err
+}
+
+// CreateInvite creates a registration invite. Requires an admin client. Invites for an
+// email address are also emailed to it.
+//
+// Parameters:
+// - ctx: The request context.
+// - request: Who can use the invite, how often and until when.
+//
+// Returns:
+// - *CreatedInvite: The invite with its code.
+// - error: An *APIError if the request fails.
+func (c *Client) CreateInvite(ctx context.Context, request CreateInviteRequest) (*CreatedInvite, error) {
+	var invite CreatedInvite
+	if _, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/admin/invites", request, &invite); err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// ListInvites returns every registration invite, newest first. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - []Invite: The invites, without their codes.
+// - error: An *APIError if the request fails.
+func (c *Client) ListInvites(ctx context.Context) ([]Invite, error) {
+	var invites []Invite
+	if _, err := c.callEnvelope(ctx, true, http.MethodGet, "/auth/api/admin/invites", nil, &invites); err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// RevokeInvite stops a registration invite from being used. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - inviteID: The ID of the invite.
+//
+// Returns:
+// - error: An *APIError if the request fails, ErrNotFound if the invite is unknown or revoked.
+func (c *Client) RevokeInvite(ctx context.Context, inviteID string) error {
+	_, err := c.callEnvelope(ctx, true, http.MethodDelete, "/auth/api/admin/invites/"+url.PathEscape(inviteID), nil, nil)
+	return err
+}
+
+// ListPendingUsers returns the users waiting for approval, oldest first. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - filter: Narrows down the list.
+//
+// Returns:
+// - []User: The users waiting for approval.
+// - error: An *APIError if the request fails.
+func (c *Client) ListPendingUsers(ctx context.Context, filter PendingUsersFilter) ([]User, error) {
+	query := url.Values{}
+	if filter.Confirmed != nil {
+		query.Set("confirmed", strconv.FormatBool(*filter.Confirmed))
+	}
+	if filter.Email != "" {
+		query.Set("email", filter.Email)
+	}
+	if !filter.RegisteredAfter.IsZero() {
+		query.Set("registered_after", filter.RegisteredAfter.Format(time.RFC3339))
+	}
+	if !filter.RegisteredBefore.IsZero() {
+		query.Set("registered_before", filter.RegisteredBefore.Format(time.RFC3339))
+	}
+
+	path := "/auth/api/admin/approvals"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var users []User
+	if _, err := c.callEnvelope(ctx, true, http.MethodGet, path, nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ApproveUser approves a user waiting for approval, who is emailed about it. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+//
+// Returns:
+// - error: An *APIError if the request fails, ErrConflict if the user is not waiting for
+// approval or did not confirm the email address yet.
+func (c *Client) ApproveUser(ctx context.Context, userID string) error {
+	_, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/admin/user/"+url.PathEscape(userID)+"/approve", nil, nil)
+	return err
+}
+
+// RejectUser rejects a user waiting for approval, who is emailed the reason. Requires an admin client.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+// - reason: Why the registration was rejected.
+//
+// Returns:
+// - error: An *APIError if the request fails, ErrConflict if the user is not waiting for approval.
+func (c *Client) RejectUser(ctx context.Context, userID, reason string) error {
+	body := map[string]string{"reason": reason}
+	_, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/admin/user/"+url.PathEscape(userID)+"/reject", body, nil)
+	return err
+}
@@ -0,0 +1,275 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Login authenticates with email and password and stores the returned tokens in the client.
+//
+// Parameters:
+// - ctx: The request context.
+// - email, password: The user's credentials.
+//
+// Returns:
+// - TokenPair: The issued tokens.
+// - error: An *APIError if the credentials are rejected.
+func (c *Client) Login(ctx context.Context, email, password string) (TokenPair, error) {
+	payload := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{Email: email, Password: password}
+
+	var tokens TokenPair
+	if err := c.call(ctx, http.MethodPost, "/auth/api/login", payload, &tokens); err != nil {
+		return TokenPair{}, err
+	}
+
+	c.SetTokens(tokens)
+	return tokens, nil
+}
+
+// Refresh exchanges the stored refresh token for a new token pair and stores it in the client.
+// Protected calls do this automatically when the access token is rejected.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - TokenPair: The new tokens.
+// - error: ErrNoRefresh if the client holds no refresh token, or an *APIError if it is rejected.
+func (c *Client) Refresh(ctx context.Context) (TokenPair, error) {
+	refreshToken := c.Tokens().RefreshToken
+	if refreshToken == "" {
+		return TokenPair{}, ErrNoRefresh
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/auth/api/refresh", nil)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.AddCookie(&http.Cookie{Name: c.refreshCookieName, Value: refreshToken})
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var env envelope
+		if json.NewDecoder(resp.Body).Decode(&env) == nil {
+			apiErr.Message = env.Message
+		}
+		return TokenPair{}, apiErr
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	var tokens TokenPair
+	if err := json.Unmarshal(data, &tokens); err != nil || tokens.Token == "" {
+		return TokenPair{}, fmt.Errorf("tricerapass: refresh returned no tokens")
+	}
+
+	c.SetTokens(tokens)
+	return tokens, nil
+}
+
+// Logout ends the session on the server and forgets the stored tokens.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - error: An *APIError if the request fails.
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.callAuthed(ctx, http.MethodPost, "/auth/api/logged_in/logout", nil, nil); err != nil {
+		return err
+	}
+	c.SetTokens(TokenPair{})
+	return nil
+}
+
+// Register creates a new user account. The server sends a confirmation email.
+//
+// Parameters:
+// - ctx: The request context.
+// - request: The new user's data.
+//
+// Returns:
+// - string: The ID of the new user.
+// - error: An *APIError if the registration is rejected, ErrConflict if the email address
+// or the username is taken, regardless of the case.
+func (c *Client) Register(ctx context.Context, request RegisterRequest) (string, error) {
+	var userID string
+	_, err := c.callEnvelope(ctx, false, http.MethodPost, "/auth/api/register", request, &userID)
+	return userID, err
+}
+
+// ConfirmEmail confirms an email address with the token from the confirmation email.
+//
+// Parameters:
+// - ctx: The request context.
+// - token: The token of the link in the confirmation email.
+//
+// Returns:
+// - error: An *APIError if the token is invalid, expired (410) or was used before (409).
+func (c *Client) ConfirmEmail(ctx context.Context, token string) error {
+	body := map[string]string{"token": token}
+	_, err := c.callEnvelope(ctx, false, http.MethodPost, "/auth/api/confirmation", body, nil)
+	return err
+}
+
+// ConfirmEmailChange swaps the email address with the token sent to the new address.
+//
+// Parameters:
+// - ctx: The request context.
+// - token: The token of the link in the email sent to the new address.
+//
+// Returns:
+// - error: An *APIError if the token is invalid, expired (410), was used before or the
+// address was taken in the meantime (409).
+func (c *Client) ConfirmEmailChange(ctx context.Context, token string) error {
+	body := map[string]string{"token": token}
+	_, err := c.callEnvelope(ctx, false, http.MethodPost, "/auth/api/email_change/confirm", body, nil)
+	return err
+}
+
+// RevertEmailChange restores the old email address with the token sent to it. Every
+// session of the user is logged out.
+//
+// Parameters:
+// - ctx: The request context.
+// - token: The token of the link in the email sent to the old address.
+//
+// Returns:
+// - error: An *APIError if the token is invalid, expired (410) or was used before (409).
+func (c *Client) RevertEmailChange(ctx context.Context, token string) error {
+	body := map[string]string{"token": token}
+	_, err := c.callEnvelope(ctx, false, http.MethodPost, "/auth/api/email_change/revert", body, nil)
+	return err
+}
+
+// ResendConfirmation asks for a new confirmation email. The server answers the same
+// way whether or not an unconfirmed account uses the address.
+//
+// Parameters:
+// - ctx: The request context.
+// - email: The email address of the account.
+//
+// Returns:
+// - error: An *APIError if the request is rejected, e.g. ErrTooManyRequests.
+func (c *Client) ResendConfirmation(ctx context.Context, email string) error {
+	payload := struct {
+		Email string `json:"email"`
+	}{Email: email}
+	_, err := c.callEnvelope(ctx, false, http.MethodPost, "/auth/api/confirmation/resend", payload, nil)
+	return err
+}
+
+// GetLastConfirmation returns the most recent confirmation record of a user.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+//
+// Returns:
+// - *Confirmation: The confirmation record.
+// - error: An *APIError if the request fails.
+func (c *Client) GetLastConfirmation(ctx context.Context, userID string) (*Confirmation, error) {
+	var confirmation Confirmation
+	if _, err := c.callEnvelope(ctx, false, http.MethodGet, "/auth/api/confirmation/user/"+userID, nil, &confirmation); err != nil {
+		return nil, err
+	}
+	return &confirmation, nil
+}
+
+// SendForgottenPasswordEmail sends a password reset email to the given address.
+//
+// Parameters:
+// - ctx: The request context.
+// - email: The email address of the account.
+//
+// Returns:
+// - error: An *APIError if the request fails.
+func (c *Client) SendForgottenPasswordEmail(ctx context.Context, email string) error {
+	payload := struct {
+		Email string `json:"email"`
+	}{Email: email}
+	_, err := c.callEnvelope(ctx, false, http.MethodPost, "/auth/api/send_password_email", payload, nil)
+	return err
+}
+
+// ResetPassword sets a new password with the token from a password reset email.
+// The token only works once and every existing session of the user is revoked.
+//
+// Parameters:
+// - ctx: The request context.
+// - token: The token from the reset link.
+// - newPassword: The new password.
+//
+// Returns:
+// - error: An *APIError if the token is invalid or the new password is rejected.
+func (c *Client) ResetPassword(ctx context.Context, token, newPassword string) error {
+	payload := struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}{Token: token, NewPassword: newPassword}
+	_, err := c.callEnvelope(ctx, false, http.MethodPost, "/auth/api/password/reset", payload, nil)
+	return err
+}
+
+// UnlockAccount lifts a login lockout with the token from the account locked email.
+//
+// Parameters:
+// - ctx: The request context.
+// - token: The token from the unlock link.
+//
+// Returns:
+// - error: An *APIError if the token is invalid or was used.
+func (c *Client) UnlockAccount(ctx context.Context, token string) error {
+	body := map[string]string{"token": token}
+	_, err := c.callEnvelope(ctx, false, http.MethodPost, "/auth/api/unlock", body, nil)
+	return err
+}
+
+// RestoreAccount restores a deleted account within the deletion grace period. Log in
+// again afterwards.
+//
+// Parameters:
+// - ctx: The request context.
+// - email: The email address of the account.
+// - password: The password of the account.
+//
+// Returns:
+// - error: ErrUnauthorized for wrong credentials, or an *APIError if the account is not
+// deleted (409) or can no longer be restored (410).
+func (c *Client) RestoreAccount(ctx context.Context, email, password string) error {
+	payload := map[string]string{"email": email, "password": password}
+	_, err := c.callEnvelope(ctx, false, http.MethodPost, "/auth/api/account/restore", payload, nil)
+	return err
+}
+
+// GetPasswordPolicy returns the rules new passwords have to satisfy.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - *PasswordPolicy: The active password policy.
+// - error: An *APIError if the request fails.
+func (c *Client) GetPasswordPolicy(ctx context.Context) (*PasswordPolicy, error) {
+	var policy PasswordPolicy
+	if _, err := c.callEnvelope(ctx, false, http.MethodGet, "/auth/api/password/policy", nil, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
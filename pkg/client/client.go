@@ -0,0 +1,286 @@
+// Package client is a typed Go client for the TriceraPass REST API. It wraps the
+// /auth/api endpoints, decodes the JSON envelopes into typed values, returns
+// *APIError for non-2xx responses and transparently refreshes the access token
+// when a protected call is rejected with 401.
+//
+//	c := client.New("http://localhost:1993")
+//	if _, err := c.Login(ctx, "alan@grant.com", "clever-girl"); err != nil {
+//		log.Fatal(err)
+//	}
+//	user, err := c.GetUserByID(ctx, userID)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sentinel errors matched by APIError through errors.Is.
+var (
+	ErrBadRequest      = errors.New("bad request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrTooManyRequests = errors.New("too many requests")
+	ErrUnavailable     = errors.New("service unavailable")
+	ErrNoRefresh       = errors.New("no refresh token available")
+)
+
+// APIError is returned for every response with a non-2xx status code.
+type APIError struct {
+	StatusCode int             // HTTP status code of the response.
+	Message    string          // Message of the JSON error envelope, if any.
+	Data       json.RawMessage // Data of the JSON error envelope, if any.
+	RetryAfter time.Duration   // Retry-After of throttled or overloaded responses, if any.
+}
+
+// PasswordViolations returns the password policy rules reported by the server
+// when a new password is rejected.
+func (e *APIError) PasswordViolations() []PasswordViolation {
+	var violations []PasswordViolation
+	if len(e.Data) > 0 {
+		_ = json.Unmarshal(e.Data, &violations)
+	}
+	return violations
+}
+
+// Code returns the machine-readable code of the error, e.g. the account status of a
+// rejected login such as "pending_approval", "rejected" or "suspended". It is empty if
+// the server sent none.
+func (e *APIError) Code() string {
+	var data struct {
+		Code string `json:"code"`
+	}
+	if len(e.Data) > 0 {
+		_ = json.Unmarshal(e.Data, &data)
+	}
+	return data.Code
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("tricerapass: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("tricerapass: %d %s", e.StatusCode, e.Message)
+}
+
+// Is lets errors.Is match an APIError against the sentinel errors of this package.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrTooManyRequests:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for all requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRefreshCookieName sets the name of the refresh token cookie, "refresh_token" by default.
+func WithRefreshCookieName(name string) Option {
+	return func(c *Client) {
+		c.refreshCookieName = name
+	}
+}
+
+// WithTokens starts the client with an existing token pair, e.g. restored from storage.
+func WithTokens(tokens TokenPair) Option {
+	return func(c *Client) {
+		c.tokens = tokens
+	}
+}
+
+// Client talks to a TriceraPass server. It is safe for concurrent use.
+type Client struct {
+	baseURL           string
+	httpClient        *http.Client
+	refreshCookieName string
+
+	mu     sync.Mutex
+	tokens TokenPair
+}
+
+// New creates a client for the server at baseURL, e.g. http://localhost:1993.
+//
+// Parameters:
+// - baseURL: The scheme and host of the TriceraPass server.
+// - options: Optional settings.
+//
+// Returns:
+// - *Client: The client.
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:           strings.TrimSuffix(baseURL, "/"),
+		httpClient:        &http.Client{Timeout: 30 * time.Second},
+		refreshCookieName: "refresh_token",
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Tokens returns the token pair currently held by the client.
+func (c *Client) Tokens() TokenPair {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens
+}
+
+// SetTokens replaces the token pair held by the client.
+func (c *Client) SetTokens(tokens TokenPair) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = tokens
+}
+
+// envelope mirrors utils.JSONResponse of the server.
+type envelope struct {
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// rawBody is sent as is instead of being encoded as JSON.
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+// call sends a public request and decodes the raw response body into out.
+func (c *Client) call(ctx context.Context, method, path string, in, out interface{}) error {
+	_, err := c.do(ctx, method, path, in, out, false)
+	return err
+}
+
+// callAuthed sends a request with the access token, refreshing the tokens once on 401.
+func (c *Client) callAuthed(ctx context.Context, method, path string, in, out interface{}) error {
+	status, err := c.do(ctx, method, path, in, out, true)
+	if status != http.StatusUnauthorized || c.Tokens().RefreshToken == "" {
+		return err
+	}
+
+	if _, refreshErr := c.Refresh(ctx); refreshErr != nil {
+		return err
+	}
+
+	_, err = c.do(ctx, method, path, in, out, true)
+	return err
+}
+
+// callEnvelope sends a request and decodes the data field of the JSON envelope into out.
+func (c *Client) callEnvelope(ctx context.Context, authed bool, method, path string, in, out interface{}) (string, error) {
+	var env envelope
+	var err error
+	if authed {
+		err = c.callAuthed(ctx, method, path, in, &env)
+	} else {
+		err = c.call(ctx, method, path, in, &env)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if out != nil && len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, out); err != nil {
+			return "", fmt.Errorf("tricerapass: could not decode response data: %w", err)
+		}
+	}
+	return env.Message, nil
+}
+
+// do performs a single HTTP round trip and returns the status code.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}, authed bool) (int, error) {
+	var body io.Reader
+	contentType := "application/json"
+	if raw, ok := in.(rawBody); ok {
+		body = bytes.NewReader(raw.data)
+		contentType = raw.contentType
+	} else if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if authed {
+		if token := c.Tokens().Token; token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		var env envelope
+		if json.Unmarshal(data, &env) == nil {
+			apiErr.Message = env.Message
+			apiErr.Data = env.Data
+		}
+		return resp.StatusCode, apiErr
+	}
+
+	if raw, ok := out.(*[]byte); ok {
+		// Binary responses, e.g. archives, are returned as they are
+		*raw = data
+		return resp.StatusCode, nil
+	}
+	if out != nil && len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("tricerapass: could not decode response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,177 @@
+package client_test
+
+import (
+	"TriceraPass/internal/testenv"
+	"TriceraPass/pkg/client"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestMain runs the suite from the repository root so the email templates can be found.
+func TestMain(m *testing.M) {
+	testenv.Main(m)
+}
+
+// Test registration, confirmation and login
+func TestRegisterConfirmAndLogin(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	userID := env.Register(t, "grant", "clever-girl")
+
+	sent := env.Mail.WaitFor(t, 1)
+	if len(sent) != 1 || sent[0].To != "grant@jurassic.park" {
+		t.Errorf("expected one confirmation email to grant, got %+v", sent)
+	}
+
+	c := client.New(env.Server.URL)
+	if _, err := c.Login(ctx, "grant@jurassic.park", "wrong"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected client.ErrUnauthorized for a wrong password, got %v", err)
+	}
+
+	// Knowing the user ID no longer confirms the account
+	resp, err := http.Post(env.Server.URL+"/auth/api/confirmation/"+userID, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if confirmation, err := c.GetLastConfirmation(ctx, userID); err != nil || confirmation.Confirmed {
+		t.Fatalf("expected the user ID not to confirm, got %+v, error: %v", confirmation, err)
+	}
+
+	if err := c.ConfirmEmail(ctx, testenv.ConfirmationToken(t, sent, "grant@jurassic.park")); err != nil {
+		t.Fatalf("Error confirming user: %v", err)
+	}
+	confirmation, err := c.GetLastConfirmation(ctx, userID)
+	if err != nil || !confirmation.Confirmed {
+		t.Errorf("expected user to be confirmed, got %+v, error: %v", confirmation, err)
+	}
+
+	c = env.Login(t, "grant", "clever-girl")
+	user, err := c.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.Email != "grant@jurassic.park" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+}
+
+// Test that protected calls refresh an expired access token automatically
+func TestAutomaticRefresh(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	userID := env.Register(t, "sattler", "life-finds-a-way")
+	c := env.Login(t, "sattler", "life-finds-a-way")
+
+	// Replace the access token with a stale one, keeping the refresh token
+	tokens := c.Tokens()
+	c.SetTokens(client.TokenPair{Token: "stale", RefreshToken: tokens.RefreshToken})
+
+	if _, err := c.GetUserByID(ctx, userID); err != nil {
+		t.Fatalf("expected the client to refresh its tokens, got %v", err)
+	}
+	if c.Tokens().Token == "stale" {
+		t.Errorf("expected a new access token to be stored")
+	}
+
+	// Without a refresh token the 401 is returned as is
+	c.SetTokens(client.TokenPair{Token: "stale"})
+	if _, err := c.GetUserByID(ctx, userID); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("expected client.ErrUnauthorized, got %v", err)
+	}
+}
+
+// Test the password change and the admin endpoints
+func TestPasswordAndAdmin(t *testing.T) {
+	env := testenv.New(t)
+	ctx := context.Background()
+
+	env.Register(t, "hammond", "spared-no-expense")
+	userID := env.Register(t, "malcolm", "chaos-theory")
+
+	user := env.Login(t, "malcolm", "chaos-theory")
+	if _, err := user.ListUsers(ctx); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected client.ErrForbidden for a regular user, got %v", err)
+	}
+
+	if _, err := user.UpdatePassword(ctx, "chaos-theory", "strange-attractor", false); err != nil {
+		t.Fatalf("Error changing password: %v", err)
+	}
+	if _, err := user.UpdatePassword(ctx, "strange-attractor", "strange-attractor", false); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected reusing the current password to be rejected, got %v", err)
+	}
+	env.Login(t, "malcolm", "strange-attractor")
+
+	// The first registered user is the admin
+	admin := env.Login(t, "hammond", "spared-no-expense")
+	users, err := admin.ListUsers(ctx)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("expected two users, got %d, error: %v", len(users), err)
+	}
+
+	modes, err := admin.ListModes(ctx)
+	if err != nil || len(modes) != 2 {
+		t.Fatalf("expected two modes, got %d, error: %v", len(modes), err)
+	}
+
+	if err := admin.DeleteUser(ctx, userID); err != nil {
+		t.Fatalf("Error deleting user: %v", err)
+	}
+	users, err = admin.ListUsers(ctx)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("expected the deleted user to wait for the purge, got %d users, error: %v", len(users), err)
+	}
+	for _, u := range users {
+		if (u.ID == userID) == u.DeletedAt.IsZero() {
+			t.Errorf("expected only the deleted user to be marked, got %+v", u)
+		}
+	}
+	if _, err := client.New(env.Server.URL).Login(ctx, "malcolm@jurassic.park", "strange-attractor"); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected the deleted user to be unable to log in, got %v", err)
+	}
+}
+
+// Test that failed responses are decoded into an APIError matching the sentinel errors
+func TestAPIError(t *testing.T) {
+	responses := map[string]func(w http.ResponseWriter){
+		"/auth/api/login": func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error": true, "message": "too many login attempts", "data": {"code": "throttled"}}`))
+		},
+		"/auth/api/register": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": true, "message": "weak password", "data": [{"rule": "min_length", "message": "too short"}]}`))
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		responses[r.URL.Path](w)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+
+	_, err := c.Login(ctx, "nedry@jurassic.park", "ah-ah-ah")
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrTooManyRequests) || errors.Is(err, client.ErrForbidden) {
+		t.Fatalf("expected a throttled APIError, got %v", err)
+	}
+	if apiErr.RetryAfter != 7*time.Second || apiErr.Code() != "throttled" || apiErr.Message != "too many login attempts" {
+		t.Errorf("unexpected error details: %+v, code %q", apiErr, apiErr.Code())
+	}
+
+	_, err = c.Register(ctx, client.RegisterRequest{Email: "nedry@jurassic.park", Password: "short"})
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrBadRequest) {
+		t.Fatalf("expected a bad request APIError, got %v", err)
+	}
+	if violations := apiErr.PasswordViolations(); len(violations) != 1 || violations[0].Rule != "min_length" || apiErr.Code() != "" {
+		t.Errorf("expected the violated rule without a code, got %+v, code %q", violations, apiErr.Code())
+	}
+}
@@ -0,0 +1,162 @@
+package client
+
+import "time"
+
+// TokenPair holds the access and refresh tokens issued on login.
+type TokenPair struct {
+	Token        string `json:"access_token"`  // JWT access token.
+	RefreshToken string `json:"refresh_token"` // JWT refresh token.
+	// PasswordRotationRequired is set on login when the password was found in a breach list.
+	PasswordRotationRequired bool `json:"password_rotation_required,omitempty"`
+}
+
+// RegisterRequest is the payload for registering a new user.
+type RegisterRequest struct {
+	UserName  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	// InviteCode is required while registration is invite-only.
+	InviteCode string `json:"invite_code,omitempty"`
+}
+
+// UpdateUserRequest is the payload for updating a user's profile.
+type UpdateUserRequest struct {
+	UserName string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// Mode is a user mode such as "admin" or "default".
+type Mode struct {
+	ID        uint      `json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+	Name      string    `json:"mode_name"`
+	UserID    string    `json:"user_id"`
+}
+
+// User is a user as returned by the user and admin endpoints.
+type User struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserName  string    `json:"username"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Mode      *Mode     `json:"mode,omitempty"`
+	// PasswordRotationRequired is set when the user has to choose a new password.
+	PasswordRotationRequired bool `json:"password_rotation_required"`
+	// DeletedAt is set while a deleted account waits for its purge, zero otherwise.
+	DeletedAt time.Time `json:"deleted_at"`
+	// Status is active, suspended, locked or pending_deletion, only returned to admins.
+	Status       string     `json:"status,omitempty"`
+	StatusReason string     `json:"status_reason,omitempty"`
+	StatusUntil  *time.Time `json:"status_until,omitempty"`
+}
+
+// SuspendRequest suspends or locks a user.
+type SuspendRequest struct {
+	// Status is "suspended" or "locked", suspended if empty.
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Until ends the suspension by itself, nil keeps it until the user is reactivated.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Confirmation is an email confirmation record of a user.
+type Confirmation struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	ExpiredAt int64     `json:"expired_at"`
+	CreatedAt time.Time `json:"created_at"`
+	Confirmed bool      `json:"confirmed"`
+}
+
+// PasswordPolicy describes the rules a new password has to satisfy.
+type PasswordPolicy struct {
+	MinLength        int  `json:"min_length"`
+	MaxLength        int  `json:"max_length"`
+	RequireUpper     bool `json:"require_upper"`
+	RequireLower     bool `json:"require_lower"`
+	RequireDigit     bool `json:"require_digit"`
+	RequireSymbol    bool `json:"require_symbol"`
+	MaxRepeated      int  `json:"max_repeated"`
+	DisallowUserInfo bool `json:"disallow_user_info"`
+	History          int  `json:"history"`
+}
+
+// PasswordViolation is a password policy rule that a rejected password does not satisfy.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// HashingMetrics is a snapshot of the server's password hashing pool.
+type HashingMetrics struct {
+	Concurrency  int     `json:"concurrency"`
+	QueueDepth   int     `json:"queue_depth"`
+	InFlight     int     `json:"in_flight"`
+	Queued       int64   `json:"queued"`
+	Completed    int64   `json:"completed"`
+	Rejected     int64   `json:"rejected"`
+	AvgWaitMs    float64 `json:"avg_wait_ms"`
+	AvgHashMs    float64 `json:"avg_hash_ms"`
+	RetryAfterMs int64   `json:"retry_after_ms"`
+}
+
+// ImportReport summarizes a user import.
+type ImportReport struct {
+	DryRun   bool            `json:"dry_run"`
+	Total    int             `json:"total"`
+	Imported int             `json:"imported"`
+	Failed   []ImportFailure `json:"failed"`
+}
+
+// ImportFailure is a record of an export that was not imported.
+type ImportFailure struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
+
+// Invite lets people register while registration is invite-only.
+type Invite struct {
+	ID        string     `json:"id"`
+	Email     string     `json:"email,omitempty"`
+	ModeName  string     `json:"mode_name,omitempty"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiredAt int64      `json:"expired_at"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateInviteRequest is the payload for creating an invite.
+type CreateInviteRequest struct {
+	// Email limits the invite to one address and emails it the invitation.
+	Email string `json:"email,omitempty"`
+	// Mode is assigned to the users registering with the invite, default if empty.
+	Mode string `json:"mode,omitempty"`
+	// MaxUses is how many users can register with the invite, 0 for no limit, 1 if nil.
+	MaxUses   *int       `json:"max_uses,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreatedInvite is a new invite together with its code, which can not be retrieved later.
+type CreatedInvite struct {
+	Invite Invite `json:"invite"`
+	Code   string `json:"code"`
+	URL    string `json:"url"`
+}
+
+// PendingUsersFilter narrows down the users waiting for approval. Zero values do not filter.
+type PendingUsersFilter struct {
+	// Confirmed only returns users who did, or did not, confirm their email address.
+	Confirmed *bool
+	// Email only returns users whose email address contains it.
+	Email            string
+	RegisteredAfter  time.Time
+	RegisteredBefore time.Time
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// GetUserByID returns a user by ID. Requires a logged-in client.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+//
+// Returns:
+// - *User: The user.
+// - error: An *APIError if the request fails.
+func (c *Client) GetUserByID(ctx context.Context, userID string) (*User, error) {
+	var user User
+	if _, err := c.callEnvelope(ctx, true, http.MethodGet, "/auth/api/logged_in/user/"+url.PathEscape(userID), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserByEmail returns a user by email address.
+//
+// Parameters:
+// - ctx: The request context.
+// - email: The email address of the user.
+//
+// Returns:
+// - *User: The user.
+// - error: An *APIError if the request fails.
+func (c *Client) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	var user User
+	if _, err := c.callEnvelope(ctx, false, http.MethodGet, "/auth/api/user/"+url.PathEscape(email), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUser updates the username and email of a user. Requires a logged-in client.
+// A new email address is only used once it is confirmed with ConfirmEmailChange, the
+// old address gets a link for RevertEmailChange.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the user.
+// - request: The new profile values.
+//
+// Returns:
+// - error: An *APIError if the update is rejected, ErrConflict if the email address or the
+// username is taken, regardless of the case.
+func (c *Client) UpdateUser(ctx context.Context, userID string, request UpdateUserRequest) error {
+	_, err := c.callEnvelope(ctx, true, http.MethodPatch, "/auth/api/logged_in/user/"+url.PathEscape(userID), request, nil)
+	return err
+}
+
+// UpdatePassword changes the password of the logged-in user and stores the new tokens
+// returned by the server in the client.
+//
+// Parameters:
+// - ctx: The request context.
+// - currentPassword: The password the user has now.
+// - newPassword: The new password.
+// - signOutOthers: Revoke every other session of the user.
+//
+// Returns:
+// - TokenPair: The new tokens of this session.
+// - error: ErrForbidden if the current password is wrong, or an *APIError if the new password is rejected.
+func (c *Client) UpdatePassword(ctx context.Context, currentPassword, newPassword string, signOutOthers bool) (TokenPair, error) {
+	payload := struct {
+		CurrentPassword      string `json:"current_password"`
+		NewPassword          string `json:"new_password"`
+		SignOutOtherSessions bool   `json:"sign_out_other_sessions"`
+	}{CurrentPassword: currentPassword, NewPassword: newPassword, SignOutOtherSessions: signOutOthers}
+
+	var tokens TokenPair
+	if _, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/logged_in/password", payload, &tokens); err != nil {
+		return TokenPair{}, err
+	}
+
+	c.SetTokens(tokens)
+	return tokens, nil
+}
+
+// SendPasswordResetEmail sends a password reset email to the logged-in user.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the logged-in user.
+//
+// Returns:
+// - error: An *APIError if the request fails.
+func (c *Client) SendPasswordResetEmail(ctx context.Context, userID string) error {
+	payload := struct {
+		UserID string `json:"user_id"`
+	}{UserID: userID}
+	_, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/logged_in/user/send_password_email/"+url.PathEscape(userID), payload, nil)
+	return err
+}
+
+// DeleteOwnAccount deletes the account of the logged-in user and logs out every session.
+// The account can be restored with RestoreAccount until the server purges it after its
+// grace period.
+//
+// Parameters:
+// - ctx: The request context.
+// - userID: The ID of the logged-in user.
+//
+// Returns:
+// - error: An *APIError if the request fails.
+func (c *Client) DeleteOwnAccount(ctx context.Context, userID string) error {
+	_, err := c.callEnvelope(ctx, true, http.MethodDelete, "/auth/api/logged_in/user/"+url.PathEscape(userID), nil, nil)
+	return err
+}
+
+// RequestDataExport asks the server to build an archive of the logged-in user's data.
+// The download link is emailed once the archive is ready.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - string: The ID of the export.
+// - error: An *APIError if the request fails, ErrConflict while an earlier export is being built.
+func (c *Client) RequestDataExport(ctx context.Context) (string, error) {
+	var data struct {
+		ExportID string `json:"export_id"`
+	}
+	if _, err := c.callEnvelope(ctx, true, http.MethodPost, "/auth/api/logged_in/export", nil, &data); err != nil {
+		return "", err
+	}
+	return data.ExportID, nil
+}
+
+// DownloadDataExport downloads the archive of a data export.
+//
+// Parameters:
+// - ctx: The request context.
+// - token: The token of the download link in the email.
+//
+// Returns:
+// - []byte: The ZIP archive.
+// - error: An *APIError if the token is invalid or the link expired (410).
+func (c *Client) DownloadDataExport(ctx context.Context, token string) ([]byte, error) {
+	var archive []byte
+	if err := c.call(ctx, http.MethodGet, "/auth/api/export/download?token="+url.QueryEscape(token), nil, &archive); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
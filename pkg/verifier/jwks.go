@@ -0,0 +1,211 @@
+package verifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRefetchInterval limits how often the JWKS is refetched, whether it is stale or a
+// token names an unknown key ID.
+const minRefetchInterval = 30 * time.Second
+
+// JWKS is a cached set of public keys fetched from a JWKS endpoint. Keys are
+// refetched once the refresh interval has passed, or early when a token names
+// a key ID that is not in the cache, but at most once every 30 seconds. While a
+// refetch fails the previously fetched keys keep being served.
+type JWKS struct {
+	url             string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	lastErr     error
+	fetching    chan struct{} // Closed when the running fetch ends, nil while none runs
+}
+
+// jsonWebKey is the subset of RFC 7517 fields needed for RSA and EC public keys.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewJWKS creates a key cache for the given JWKS URL.
+//
+// Parameters:
+// - url: The URL of the JWKS document.
+// - refreshInterval: How long fetched keys are cached, defaults to one hour when zero.
+// - client: The HTTP client used for fetching, defaults to a client with a 10 second timeout when nil.
+//
+// Returns:
+// - *JWKS: The key cache. Keys are fetched lazily on first use.
+func NewJWKS(url string, refreshInterval time.Duration, client *http.Client) *JWKS {
+	if refreshInterval <= 0 {
+		refreshInterval = time.Hour
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &JWKS{url: url, refreshInterval: refreshInterval, client: client}
+}
+
+// Key returns the public key with the given key ID, fetching the JWKS when the cache is stale.
+// The fetch runs outside the lock, callers missing the key wait for a running fetch.
+//
+// Parameters:
+// - kid: The key ID from the token header. An empty ID matches the only key of a single-key set.
+//
+// Returns:
+// - interface{}: The *rsa.PublicKey or *ecdsa.PublicKey for the key ID.
+// - error: An error if the key is unknown or the JWKS can not be fetched.
+func (k *JWKS) Key(kid string) (interface{}, error) {
+	k.mu.Lock()
+	key, known := k.lookup(kid)
+	stale := time.Since(k.fetchedAt) > k.refreshInterval
+	switch {
+	case (stale || !known) && k.fetching == nil && time.Since(k.lastAttempt) > minRefetchInterval:
+		done := make(chan struct{})
+		k.fetching = done
+		k.lastAttempt = time.Now()
+		k.mu.Unlock()
+
+		keys, err := k.fetch()
+
+		k.mu.Lock()
+		if err == nil {
+			k.keys = keys
+			k.fetchedAt = time.Now()
+		}
+		k.lastErr = err
+		k.fetching = nil
+		close(done)
+		key, known = k.lookup(kid)
+	case !known && k.fetching != nil:
+		done := k.fetching
+		k.mu.Unlock()
+		<-done
+		k.mu.Lock()
+		key, known = k.lookup(kid)
+	}
+	fetched, lastErr := k.keys != nil, k.lastErr
+	k.mu.Unlock()
+
+	if !known {
+		if !fetched && lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// lookup finds a cached key. The caller must hold the lock.
+func (k *JWKS) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// fetch downloads and parses the JWKS document. It does not touch the cache, so it
+// runs without the lock.
+func (k *JWKS) fetch() (map[string]interface{}, error) {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return nil, fmt.Errorf("could not parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip keys we do not understand instead of failing the whole set
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// publicKey converts the JWK into an RSA or ECDSA public key.
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+// decodeBigInt decodes a base64url encoded unsigned big-endian integer.
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
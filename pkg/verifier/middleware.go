@@ -0,0 +1,127 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+type contextKey string
+
+const claimsContextKey contextKey = "tricerapassClaims"
+
+// NewContext returns a copy of the context carrying the given claims.
+//
+// Parameters:
+// - ctx: The parent context.
+// - claims: The verified claims to store.
+//
+// Returns:
+// - context.Context: The derived context.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// FromContext returns the claims stored by Middleware.
+//
+// Parameters:
+// - ctx: The request context.
+//
+// Returns:
+// - *Claims: The verified claims.
+// - bool: False if the request was not authenticated by Middleware.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// Middleware verifies the token of every request and stores the claims in the
+// request context. Requests without a valid token are answered with 401.
+//
+// Parameters:
+// - next: The next HTTP handler to call after authentication succeeds.
+//
+// Returns:
+// - http.Handler: The middleware handler.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		claims, err := v.VerifyRequest(r)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+	})
+}
+
+// RequireMode returns middleware that only lets users in one of the given modes through.
+// It must be used after Middleware. The mode is read from the token, so a mode change
+// takes effect once the user's access token is refreshed.
+//
+// Parameters:
+// - modes: The accepted modes, e.g. "admin".
+//
+// Returns:
+// - func(http.Handler) http.Handler: The middleware.
+func RequireMode(modes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := FromContext(r.Context())
+			if !ok {
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+
+			for _, mode := range modes {
+				if claims.Mode == mode {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			writeError(w, http.StatusForbidden, "forbidden")
+		})
+	}
+}
+
+// RequireScope returns middleware that only lets tokens granting all of the given scopes through.
+// It must be used after Middleware.
+//
+// Parameters:
+// - scopes: The required scopes.
+//
+// Returns:
+// - func(http.Handler) http.Handler: The middleware.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := FromContext(r.Context())
+			if !ok {
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					writeError(w, http.StatusForbidden, "forbidden")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeError answers with the same JSON envelope as the TriceraPass API.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{Error: true, Message: message})
+}
@@ -0,0 +1,252 @@
+// Package verifier validates TriceraPass access tokens in downstream Go services.
+// It verifies tokens with the shared HMAC secret or with keys from a cached,
+// automatically refreshed JWKS endpoint, and provides net/http (and therefore chi)
+// middleware that stores the typed claims in the request context.
+//
+// A typical setup looks like:
+//
+//	v, err := verifier.New(verifier.Config{Secret: os.Getenv("JWT_SECRET"), Issuer: "dr-malcom.com"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	mux.Use(v.Middleware)
+//	mux.With(verifier.RequireMode("admin")).Get("/admin", adminHandler)
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Errors returned when a token can not be verified.
+var (
+	ErrNoToken          = errors.New("no auth token")
+	ErrInvalidHeader    = errors.New("invalid auth header")
+	ErrExpiredToken     = errors.New("expired token")
+	ErrInvalidIssuer    = errors.New("invalid issuer")
+	ErrInvalidAudience  = errors.New("invalid audience")
+	ErrUnexpectedMethod = errors.New("unexpected signing method")
+	ErrRestrictedToken  = errors.New("restricted token")
+	ErrWrongTokenType   = errors.New("wrong token type")
+)
+
+// Token types carried in the typ claim. Refresh tokens are only good for renewing a
+// session and are rejected wherever an access token is expected.
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// RestrictionPasswordChange marks tokens issued to users whose password has to be
+// changed. Such tokens are only good for the change password endpoint.
+const RestrictionPasswordChange = "password_change"
+
+// Claims represents the claims of a TriceraPass access token.
+type Claims struct {
+	jwt.RegisteredClaims        // Standard JWT registered claims (e.g., iat, exp, etc.).
+	Name                 string `json:"name,omitempty"`        // Full name of the user.
+	Mode                 string `json:"mode,omitempty"`        // Name of the user's mode at the time of issue.
+	Scope                string `json:"scope,omitempty"`       // Space-delimited list of granted scopes.
+	Restriction          string `json:"restriction,omitempty"` // Set on tokens that are only good for one purpose, see RestrictionPasswordChange.
+	EmailVerified        bool   `json:"email_verified"`        // Whether the user confirmed the email address.
+	Type                 string `json:"typ,omitempty"`         // TokenTypeAccess or TokenTypeRefresh.
+}
+
+// TokenType returns the type of the token. Access tokens issued before the types were
+// introduced carry "JWT" and count as access tokens.
+//
+// Returns:
+// - string: TokenTypeAccess, TokenTypeRefresh or the unknown value of the typ claim.
+func (c *Claims) TokenType() string {
+	if c.Type == "JWT" {
+		return TokenTypeAccess
+	}
+	return c.Type
+}
+
+// UserID returns the ID of the user the token was issued to.
+//
+// Returns:
+// - string: The subject of the token.
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+// Scopes returns the scopes granted by the token as a slice.
+//
+// Returns:
+// - []string: The scopes contained in the scope claim.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether the token grants the given scope.
+//
+// Parameters:
+// - scope: The scope to look for.
+//
+// Returns:
+// - bool: True if the scope is present in the scope claim.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Config holds the settings used to verify tokens. Either Secret or JWKSURL must be set.
+type Config struct {
+	Secret              string        // Shared HMAC secret (security.jwt.secret in settings.yml).
+	JWKSURL             string        // URL of a JWKS document with the public keys used to sign tokens.
+	JWKSRefreshInterval time.Duration // How long fetched keys are cached, defaults to one hour.
+	Issuer              string        // Expected issuer claim, skipped when empty.
+	Audience            string        // Expected audience claim, skipped when empty.
+	CookieName          string        // Optional cookie to read the token from when no Authorization header is sent.
+	HTTPClient          *http.Client  // Client used to fetch the JWKS, defaults to a client with a 10 second timeout.
+	AllowedRestrictions []string      // Restricted tokens accepted anyway, all restricted tokens are rejected by default.
+	TokenType           string        // Accepted token type, TokenTypeAccess by default.
+}
+
+// Verifier validates tokens according to its Config.
+type Verifier struct {
+	config Config
+	jwks   *JWKS
+}
+
+// New creates a Verifier from the given configuration.
+//
+// Parameters:
+// - config: The verification settings.
+//
+// Returns:
+// - *Verifier: The configured verifier.
+// - error: An error if neither a secret nor a JWKS URL is configured.
+func New(config Config) (*Verifier, error) {
+	if config.Secret == "" && config.JWKSURL == "" {
+		return nil, errors.New("verifier needs a secret or a JWKS URL")
+	}
+
+	v := &Verifier{config: config}
+	if config.JWKSURL != "" {
+		v.jwks = NewJWKS(config.JWKSURL, config.JWKSRefreshInterval, config.HTTPClient)
+	}
+	return v, nil
+}
+
+// Verify parses a signed token and checks its signature, expiry, issuer, audience and type.
+// Restricted tokens are rejected with ErrRestrictedToken unless their restriction is allowed,
+// refresh tokens are rejected with ErrWrongTokenType unless the verifier is configured for them.
+//
+// Parameters:
+// - token: The signed JWT string.
+//
+// Returns:
+// - *Claims: A pointer to the Claims struct containing the token claims.
+// - error: An error if the token is invalid or expired.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, v.keyFunc)
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrExpiredToken
+		}
+		return nil, err
+	}
+
+	tokenType := v.config.TokenType
+	if tokenType == "" {
+		tokenType = TokenTypeAccess
+	}
+	if claims.TokenType() != tokenType {
+		return nil, ErrWrongTokenType
+	}
+
+	if v.config.Issuer != "" && claims.Issuer != v.config.Issuer {
+		return nil, ErrInvalidIssuer
+	}
+
+	if v.config.Audience != "" && !claims.VerifyAudience(v.config.Audience, true) {
+		return nil, ErrInvalidAudience
+	}
+
+	if claims.Restriction != "" && !v.restrictionAllowed(claims.Restriction) {
+		return nil, ErrRestrictedToken
+	}
+
+	return claims, nil
+}
+
+// VerifyRequest reads the bearer token from the Authorization header, or from the
+// configured cookie when no header is sent, and verifies it.
+//
+// Parameters:
+// - r: The HTTP request carrying the token.
+//
+// Returns:
+// - *Claims: A pointer to the Claims struct containing the token claims.
+// - error: An error if no token is present or the token is invalid.
+func (v *Verifier) VerifyRequest(r *http.Request) (*Claims, error) {
+	token, err := v.tokenFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return v.Verify(token)
+}
+
+// restrictionAllowed reports whether restricted tokens of the given kind are accepted.
+func (v *Verifier) restrictionAllowed(restriction string) bool {
+	for _, allowed := range v.config.AllowedRestrictions {
+		if allowed == restriction {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenFromRequest extracts the raw token from the request.
+func (v *Verifier) tokenFromRequest(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		if v.config.CookieName != "" {
+			if cookie, err := r.Cookie(v.config.CookieName); err == nil && cookie.Value != "" {
+				return cookie.Value, nil
+			}
+		}
+		return "", ErrNoToken
+	}
+
+	headerParts := strings.Split(authHeader, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return "", ErrInvalidHeader
+	}
+
+	return headerParts[1], nil
+}
+
+// keyFunc selects the key used to check the signature of a token.
+func (v *Verifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if v.config.Secret == "" {
+			return nil, fmt.Errorf("%w: %v", ErrUnexpectedMethod, token.Header["alg"])
+		}
+		return []byte(v.config.Secret), nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if v.jwks == nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnexpectedMethod, token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return v.jwks.Key(kid)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnexpectedMethod, token.Header["alg"])
+	}
+}
@@ -0,0 +1,265 @@
+package verifier
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signHMAC signs the claims, as an access token unless they set another type.
+func signHMAC(t *testing.T, claims jwt.MapClaims, secret string) string {
+	t.Helper()
+	if _, ok := claims["typ"]; !ok {
+		claims["typ"] = TokenTypeAccess
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	return token
+}
+
+// Test HMAC verification including issuer, audience and expiry checks
+func TestVerifySecret(t *testing.T) {
+	v, err := New(Config{Secret: "secret", Issuer: "issuer", Audience: "audience"})
+	if err != nil {
+		t.Fatalf("Error creating verifier: %v", err)
+	}
+
+	valid := jwt.MapClaims{"sub": "user-1", "iss": "issuer", "aud": "audience", "mode": "admin", "scope": "a b", "exp": time.Now().Add(time.Minute).Unix()}
+	claims, err := v.Verify(signHMAC(t, valid, "secret"))
+	if err != nil {
+		t.Fatalf("expected token to verify, got %v", err)
+	}
+	if claims.UserID() != "user-1" || claims.Mode != "admin" || !claims.HasScope("b") {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	expired := jwt.MapClaims{"sub": "user-1", "iss": "issuer", "aud": "audience", "exp": time.Now().Add(-time.Minute).Unix()}
+	if _, err := v.Verify(signHMAC(t, expired, "secret")); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("expected ErrExpiredToken, got %v", err)
+	}
+
+	wrongIssuer := jwt.MapClaims{"sub": "user-1", "iss": "other", "aud": "audience", "exp": time.Now().Add(time.Minute).Unix()}
+	if _, err := v.Verify(signHMAC(t, wrongIssuer, "secret")); !errors.Is(err, ErrInvalidIssuer) {
+		t.Errorf("expected ErrInvalidIssuer, got %v", err)
+	}
+
+	wrongAudience := jwt.MapClaims{"sub": "user-1", "iss": "issuer", "aud": "other", "exp": time.Now().Add(time.Minute).Unix()}
+	if _, err := v.Verify(signHMAC(t, wrongAudience, "secret")); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("expected ErrInvalidAudience, got %v", err)
+	}
+
+	refresh := jwt.MapClaims{"sub": "user-1", "iss": "issuer", "aud": "audience", "typ": TokenTypeRefresh, "exp": time.Now().Add(time.Minute).Unix()}
+	if _, err := v.Verify(signHMAC(t, refresh, "secret")); !errors.Is(err, ErrWrongTokenType) {
+		t.Errorf("expected refresh tokens to be rejected, got %v", err)
+	}
+	untyped := jwt.MapClaims{"sub": "user-1", "iss": "issuer", "aud": "audience", "typ": "", "exp": time.Now().Add(time.Minute).Unix()}
+	if _, err := v.Verify(signHMAC(t, untyped, "secret")); !errors.Is(err, ErrWrongTokenType) {
+		t.Errorf("expected untyped tokens to be rejected, got %v", err)
+	}
+	legacy := jwt.MapClaims{"sub": "user-1", "iss": "issuer", "aud": "audience", "typ": "JWT", "exp": time.Now().Add(time.Minute).Unix()}
+	if _, err := v.Verify(signHMAC(t, legacy, "secret")); err != nil {
+		t.Errorf("expected access tokens typed JWT to verify, got %v", err)
+	}
+
+	if _, err := v.Verify(signHMAC(t, valid, "other-secret")); err == nil {
+		t.Errorf("expected token signed with another secret to be rejected")
+	}
+}
+
+// Test that restricted tokens are only accepted when their restriction is allowed
+func TestVerifyRestricted(t *testing.T) {
+	restricted := jwt.MapClaims{"sub": "user-1", "restriction": RestrictionPasswordChange, "exp": time.Now().Add(time.Minute).Unix()}
+	token := signHMAC(t, restricted, "secret")
+
+	strict, _ := New(Config{Secret: "secret"})
+	if _, err := strict.Verify(token); !errors.Is(err, ErrRestrictedToken) {
+		t.Errorf("expected ErrRestrictedToken, got %v", err)
+	}
+
+	lenient, _ := New(Config{Secret: "secret", AllowedRestrictions: []string{RestrictionPasswordChange}})
+	claims, err := lenient.Verify(token)
+	if err != nil || claims.Restriction != RestrictionPasswordChange {
+		t.Errorf("expected the restricted token to verify, got %+v, error: %v", claims, err)
+	}
+}
+
+// Test RSA verification with keys served from a JWKS endpoint
+func TestVerifyJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key-1",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	v, err := New(Config{JWKSURL: server.URL})
+	if err != nil {
+		t.Fatalf("Error creating verifier: %v", err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "user-1", "typ": TokenTypeAccess, "exp": time.Now().Add(time.Minute).Unix()})
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(signed); err != nil {
+			t.Fatalf("expected token to verify, got %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected the JWKS to be fetched once, got %d", fetches)
+	}
+
+	// HMAC tokens are rejected when no secret is configured
+	if _, err := v.Verify(signHMAC(t, jwt.MapClaims{"sub": "user-1"}, "")); !errors.Is(err, ErrUnexpectedMethod) {
+		t.Errorf("expected ErrUnexpectedMethod, got %v", err)
+	}
+}
+
+// Test that JWKS refetches are rate limited, run once for concurrent callers and keep
+// serving the cached keys while they fail
+func TestJWKSRefetch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	var fetches int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(50 * time.Millisecond)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key-1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	// The cache is stale right away, concurrent callers share the first fetch
+	jwks := NewJWKS(server.URL, time.Nanosecond, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := jwks.Key("key-1"); err != nil {
+				t.Errorf("expected the key, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected one fetch for concurrent callers, got %d", n)
+	}
+
+	// Stale keys are not refetched more than once every 30 seconds
+	for i := 0; i < 3; i++ {
+		if _, err := jwks.Key("key-1"); err != nil {
+			t.Errorf("expected the key, got %v", err)
+		}
+	}
+	if _, err := jwks.Key("key-2"); err == nil {
+		t.Error("expected an unknown key to be rejected")
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected the refetch to be rate limited, got %d fetches", n)
+	}
+
+	// A failing refetch keeps the cached keys
+	failing.Store(true)
+	jwks.mu.Lock()
+	jwks.lastAttempt = time.Now().Add(-time.Minute)
+	jwks.mu.Unlock()
+	if _, err := jwks.Key("key-1"); err != nil {
+		t.Errorf("expected the cached key while the refetch fails, got %v", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("expected a second fetch, got %d", n)
+	}
+
+	// Without cached keys the fetch error is returned
+	empty := NewJWKS(server.URL, 0, nil)
+	if _, err := empty.Key("key-1"); err == nil || !strings.Contains(err.Error(), "could not fetch JWKS") {
+		t.Errorf("expected the fetch error, got %v", err)
+	}
+}
+
+// Test the middleware chain with RequireMode and RequireScope
+func TestMiddleware(t *testing.T) {
+	v, err := New(Config{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error creating verifier: %v", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := FromContext(r.Context())
+		_, _ = w.Write([]byte(claims.UserID()))
+	})
+	admin := v.Middleware(RequireMode("admin")(ok))
+	scoped := v.Middleware(RequireScope("users:read")(ok))
+
+	userToken := signHMAC(t, jwt.MapClaims{"sub": "user-1", "mode": "default", "scope": "users:read", "exp": time.Now().Add(time.Minute).Unix()}, "secret")
+
+	cases := []struct {
+		name    string
+		handler http.Handler
+		header  string
+		status  int
+	}{
+		{"missing token", admin, "", http.StatusUnauthorized},
+		{"wrong mode", admin, "Bearer " + userToken, http.StatusForbidden},
+		{"granted scope", scoped, "Bearer " + userToken, http.StatusOK},
+		{"malformed header", scoped, "Token " + userToken, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		w := httptest.NewRecorder()
+		c.handler.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.status, w.Code)
+		}
+	}
+}